@@ -20,15 +20,95 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
+	"github.com/golang/glog"
 	"github.com/spf13/cobra"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 
 	"github.com/kubernetes-csi/csi-driver-nfs/pkg/nfs"
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/nfs/validation"
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/nfs/volume"
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/testserver"
 )
 
 var (
-	endpoint string
-	nodeID   string
+	endpoint        string
+	nodeID          string
+	workingMountDir string
+	defaultDirMode  string
+	provisioningUID int
+	provisioningGID int
+	deleteParallel  int
+	resvPort        string
+	tcpProxy        bool
+	tcpProxyAddress string
+	demo            bool
+	demoDir         string
+	grpcCompression bool
+	metricsAddress  string
+	pruneAge        time.Duration
+	dnsCacheTTL     time.Duration
+	mounter         string
+	verifyPV        bool
+	maxSnapshots    int
+	snapshotsDir    string
+	shares          []string
+	scratchDir      string
+	maxPublishes    int
+	sysctls         map[string]string
+	moduleParams    map[string]string
+	requireEmpty    bool
+	failThreshold   int
+	failCooldown    time.Duration
+	warmUp          bool
+	warmUpServers   []string
+	retryAttempts   int
+	retryBaseDelay  time.Duration
+	retryMaxDelay   time.Duration
+	retryCodes      []string
+	mountStats      bool
+	nfs4Roots       map[string]string
+	clusterID       string
+	quarantineDir   string
+	legacyArchive   bool
+	legacyIDs       bool
+	uuidSuffix      bool
+	tagXattrs       bool
+	verifyServer    bool
+	ctrlPublish     bool
+	shareAliases    string
+	clusterIDSubDir bool
+	capacityTTL     time.Duration
+	probeCanary     string
+	canaryInterval  time.Duration
+	autofsRoot      string
+	nsPolicy        string
+	mountRate       float64
+	mountBurst      int
+	bgWindows       []string
+	bgIORate        string
+	exportLabels    int
+	selfCheck       []string
+	allowedServers  []string
+	topologyKey     string
+	topoServers     map[string]string
+	nodeTopology    string
+
+	deleteJobImage        string
+	deleteJobNamespace    string
+	deleteJobNodeSelector map[string]string
+	deleteJobCPULimit     string
+	deleteJobMemoryLimit  string
+
+	// Set unless --default-dir-mode is given explicitly
+	dirModeFromAccessModes bool
 )
 
 func init() {
@@ -43,17 +123,92 @@ func main() {
 		Use:   "NFS",
 		Short: "CSI based NFS driver",
 		Run: func(cmd *cobra.Command, args []string) {
+			dirModeFromAccessModes = !cmd.Flags().Changed("default-dir-mode")
 			handle()
 		},
 	}
 
 	cmd.Flags().AddGoFlagSet(flag.CommandLine)
 
-	cmd.PersistentFlags().StringVar(&nodeID, "nodeid", "", "node id")
-	cmd.MarkPersistentFlagRequired("nodeid")
+	// Local flags, so that subcommands do not require them
+	cmd.Flags().StringVar(&nodeID, "nodeid", "", "node id")
+	cmd.MarkFlagRequired("nodeid")
+
+	cmd.Flags().StringVar(&endpoint, "endpoint", "", "CSI endpoint")
+	cmd.MarkFlagRequired("endpoint")
+
+	cmd.PersistentFlags().StringVar(&workingMountDir, "working-mount-dir", "/tmp", "working directory for provisioner to mount nfs shares temporarily")
+	cmd.PersistentFlags().DurationVar(&pruneAge, "working-mount-dir-prune-age", 10*time.Minute, "remove empty directories left behind in the working directory once they are older than this (0 to keep them)")
+	cmd.PersistentFlags().StringVar(&defaultDirMode, "default-dir-mode", "0755", "octal mode of directories created by the provisioner")
+	cmd.PersistentFlags().IntVar(&provisioningUID, "provisioning-uid", -1, "uid used by the provisioner to create and delete directories, for exports that squash root (-1 to run as the driver process)")
+	cmd.PersistentFlags().IntVar(&provisioningGID, "provisioning-gid", -1, "gid used by the provisioner to create and delete directories, for exports that squash root (-1 to run as the driver process)")
+
+	cmd.PersistentFlags().IntVar(&deleteParallel, "delete-parallelism", 16, "maximum number of files and directories removed concurrently when deleting a volume")
+	cmd.PersistentFlags().StringVar(&deleteJobImage, "delete-job-image", "", "if set, volume data is deleted by Kubernetes Jobs running this image instead of by the controller")
+	cmd.PersistentFlags().StringVar(&deleteJobNamespace, "delete-job-namespace", "default", "namespace of the delete jobs")
+	cmd.PersistentFlags().StringToStringVar(&deleteJobNodeSelector, "delete-job-node-selector", nil, "node selector of the delete jobs, e.g. disktype=ssd")
+	cmd.PersistentFlags().StringVar(&deleteJobCPULimit, "delete-job-cpu-limit", "", "cpu limit of the delete jobs")
+	cmd.PersistentFlags().StringVar(&deleteJobMemoryLimit, "delete-job-memory-limit", "", "memory limit of the delete jobs")
+	cmd.PersistentFlags().BoolVar(&verifyPV, "verify-pv-before-delete", false, "only delete volume data if the persistent volume is released and has the Delete reclaim policy, checked with the Kubernetes API")
+	cmd.PersistentFlags().BoolVar(&requireEmpty, "require-empty-on-delete", false, "refuse to delete volumes that contain data unless their persistent volume is annotated with nfs.csi.k8s.io/allow-delete-data=true")
+	cmd.PersistentFlags().IntVar(&failThreshold, "server-failure-threshold", 5, "consecutive failures to mount an nfs server after which the controller fails requests for it for --server-failure-cooldown (0 to disable)")
+	cmd.PersistentFlags().DurationVar(&failCooldown, "server-failure-cooldown", 30*time.Second, "how long requests for a failing nfs server fail fast")
+	cmd.PersistentFlags().BoolVar(&warmUp, "warm-up", false, "at startup, resolve and connect to the nfs servers of the volumes mounted on the node and of --warm-up-servers")
+	cmd.PersistentFlags().StringSliceVar(&warmUpServers, "warm-up-servers", nil, "additional nfs servers to check with --warm-up")
+	cmd.PersistentFlags().IntVar(&retryAttempts, "retry-max-attempts", nfs.DefaultRetryPolicy.MaxAttempts, "attempts of mounts and deletions of volume data within a request, 1 to leave retries to the caller")
+	cmd.PersistentFlags().DurationVar(&retryBaseDelay, "retry-base-delay", nfs.DefaultRetryPolicy.BaseDelay, "delay after the first failed attempt, doubled after every further attempt")
+	cmd.PersistentFlags().DurationVar(&retryMaxDelay, "retry-max-delay", nfs.DefaultRetryPolicy.MaxDelay, "maximum delay between attempts")
+	cmd.PersistentFlags().StringSliceVar(&retryCodes, "retry-codes", []string{"UNAVAILABLE", "INTERNAL"}, "gRPC codes of the errors that are retried")
+	cmd.PersistentFlags().BoolVar(&mountStats, "mount-stats-metrics", false, "add metrics from the nfs client statistics of every published volume, read from /proc/self/mountstats (requires --metrics-address)")
+	cmd.PersistentFlags().StringToStringVar(&nfs4Roots, "nfs4-root", nil, "directory an nfs server exports as NFSv4 pseudo root (fsid=0), e.g. nfs.example.com=/srv/nfs; shares below it are mounted relative to it with NFSv4")
+	cmd.PersistentFlags().StringVar(&clusterID, "cluster-id", "", "id of the cluster, recorded in the metadata of provisioned volumes; volumes of other clusters are quarantined instead of deleted")
+	cmd.PersistentFlags().BoolVar(&clusterIDSubDir, "cluster-id-in-subdir", false, "prefix the subdirectories of new volumes with --cluster-id")
+	cmd.PersistentFlags().StringVar(&quarantineDir, "quarantine-dir", ".csi-nfs-quarantine", "directory under the base share that volumes of other clusters are moved to by DeleteVolume")
+	cmd.PersistentFlags().BoolVar(&legacyArchive, "legacy-archive-names", false, "name volume directories archived by DeleteVolume archived-{subdirectory} like the nfs-client-provisioner, without the time of deletion")
+	cmd.PersistentFlags().BoolVar(&uuidSuffix, "uuid-suffix", false, "append a short id derived from the volume name to the subdirectories of new volumes, so that a recreated claim never reuses a retained or archived directory, unless the StorageClass sets uuidSuffix")
+	cmd.PersistentFlags().BoolVar(&legacyIDs, "legacy-volume-ids", false, "give new volumes ids of the form {server}/{baseDir}/{subDir}, which drivers before v2 volume ids can parse, instead of v2:... ids")
+	cmd.PersistentFlags().StringVar(&shareAliases, "share-aliases-file", "", "YAML file mapping the shareAlias parameter of StorageClasses to shares, e.g. fast-tier: nfs1.example.com:/export/fast; read again when it changes")
+	cmd.PersistentFlags().BoolVar(&ctrlPublish, "controller-publish", false, "advertise PUBLISH_UNPUBLISH_VOLUME and report the nodes volumes are published to in ListVolumes; the nodes are kept in memory, so the external-attacher has to publish them again after a restart")
+	cmd.PersistentFlags().BoolVar(&verifyServer, "verify-server", false, "check in CreateVolume that the nfs server is reachable and the share can be mounted, unless the StorageClass sets verifyServer")
+	cmd.PersistentFlags().BoolVar(&tagXattrs, "tag-xattrs", false, "set user.csi.* extended attributes with the PersistentVolume and claim names on new volume directories (needs NFSv4.2 xattr support on the server)")
+	cmd.PersistentFlags().DurationVar(&capacityTTL, "capacity-cache-ttl", 30*time.Second, "how long GetCapacity results of a share are cached (0 to disable)")
+	cmd.PersistentFlags().StringVar(&probeCanary, "probe-canary", "", "export (server:/path) that Probe mounts to check that the driver can mount shares; the driver is reported as not ready while it fails")
+	cmd.PersistentFlags().DurationVar(&canaryInterval, "probe-canary-interval", time.Minute, "minimum time between two canary mounts of Probe")
+	cmd.PersistentFlags().StringVar(&topologyKey, "topology-key", nfs.DefaultTopologyKey, "topology segment that --topology-servers maps to nfs servers")
+	cmd.PersistentFlags().StringToStringVar(&topoServers, "topology-servers", nil, "nfs servers of the values of the --topology-key segment, e.g. zone-a=nfs-a.example.com; StorageClasses without a server get the server of the requested segment")
+	cmd.PersistentFlags().StringVar(&nodeTopology, "node-topology", "", "value of the --topology-key segment of this node, reported by NodeGetInfo")
+	cmd.PersistentFlags().StringSliceVar(&allowedServers, "allowed-servers", nil, "nfs servers the controller may mount for CreateVolume and other requests (empty for any)")
+	cmd.PersistentFlags().StringSliceVar(&selfCheck, "self-check-on-start", nil, "exports (server:/path) on which a canary volume is provisioned, published, written to and deleted at start; the driver is reported as not ready until all passed")
+	cmd.PersistentFlags().StringVar(&nsPolicy, "namespace-policy-configmap", "", "ConfigMap (namespace/name) mapping namespaces to the default owner and mode of their volumes, e.g. team-a: uid=1000,gid=1000,mode=0770")
+	cmd.PersistentFlags().IntVar(&maxSnapshots, "max-concurrent-snapshots", 4, "maximum number of snapshot archives written at a time (0 for no limit)")
+	cmd.PersistentFlags().StringVar(&snapshotsDir, "snapshots-dir", ".snapshots", "directory under the base share that snapshot archives are written to")
+	cmd.PersistentFlags().StringVar(&resvPort, "resvport", "", "default for using a reserved source port for nfs mounts, \"true\" (resvport) or \"false\" (noresvport); empty to use the mount helper default")
+
+	cmd.PersistentFlags().StringVar(&autofsRoot, "autofs-root", "", "publish volumes by bind mounting them from this autofs managed directory, laid out as {root}/{server}/{share} like the -hosts map, e.g. /net")
+	cmd.PersistentFlags().Float64Var(&mountRate, "mount-rate-per-server", 0, "maximum nfs mounts per second and server; mounts above the rate wait (0 for no limit)")
+	cmd.PersistentFlags().IntVar(&mountBurst, "mount-burst-per-server", 10, "mounts per server that may happen at once before --mount-rate-per-server applies")
+	cmd.PersistentFlags().StringSliceVar(&bgWindows, "background-windows", nil, "daily windows of local time for background work on shares such as snapshot archives, e.g. 22:00-06:00 (empty for any time)")
+	cmd.PersistentFlags().StringVar(&bgIORate, "background-io-rate", "", "bytes per second background work, restores and clones may write per share, e.g. 50Mi (empty for no limit)")
+	cmd.PersistentFlags().StringVar(&scratchDir, "scratch-dir", "/var/lib/csi-nfs-scratch", "local directory for the writable layers of scratch overlay volumes (empty to disable them)")
+	cmd.PersistentFlags().IntVar(&maxPublishes, "max-publishes-per-volume", 0, "maximum number of target paths one volume may be published to on a node (0 for no limit)")
+	cmd.PersistentFlags().StringToStringVar(&sysctls, "sysctl", nil, "sysctls set on the node before the first mount, e.g. sunrpc.tcp_slot_table_entries=128")
+	cmd.PersistentFlags().StringToStringVar(&moduleParams, "module-parameter", nil, "kernel module parameters set on the node before the first mount, e.g. nfs.callback_tcpport=4045")
+	cmd.PersistentFlags().StringVar(&mounter, "mounter", nfs.MounterKernel, "how shares are mounted, \""+nfs.MounterKernel+"\" (kernel nfs client) or \""+nfs.MounterFUSE+"\" (fuse-nfs)")
+	cmd.PersistentFlags().DurationVar(&dnsCacheTTL, "dns-cache-ttl", 30*time.Second, "how long resolved nfs server addresses are cached; failed lookups are never cached (0 to leave resolving to the mount helper)")
+
+	cmd.PersistentFlags().BoolVar(&tcpProxy, "tcp-proxy", false, "mount nfs shares through a local TCP proxy, for development clusters where the nfs server is only reachable by the driver (NFSv4 only)")
+	cmd.PersistentFlags().StringVar(&tcpProxyAddress, "tcp-proxy-upstream", "", "host:port the TCP proxy connects to, e.g. a port-forward or jump host; defaults to port 2049 of the nfs server")
 
-	cmd.PersistentFlags().StringVar(&endpoint, "endpoint", "", "CSI endpoint")
-	cmd.MarkPersistentFlagRequired("endpoint")
+	cmd.PersistentFlags().BoolVar(&grpcCompression, "grpc-compression", false, "gzip compress gRPC responses, e.g. large ListVolumes responses; all CSI clients must support gzip")
+
+	cmd.PersistentFlags().StringSliceVar(&shares, "shares", nil, "base shares (server:/path) whose volumes and snapshots ListVolumes and ListSnapshots return")
+	cmd.PersistentFlags().StringVar(&metricsAddress, "metrics-address", "", "address to serve prometheus metrics on, e.g. :8080 (empty to disable)")
+	cmd.PersistentFlags().IntVar(&exportLabels, "max-export-metric-labels", 20, "number of exports that get a label of their own in the provisioning metrics; further exports are reported as \"other\"")
+
+	cmd.PersistentFlags().BoolVar(&demo, "demo", false, "serve a local directory with a user space nfs server for trying out the driver")
+	cmd.PersistentFlags().StringVar(&demoDir, "demo-dir", "", "directory in which demo mode creates the exported temporary directory, $TMPDIR if empty")
+
+	cmd.AddCommand(newSupportBundleCommand())
 
 	cmd.ParseFlags(os.Args[1:])
 	if err := cmd.Execute(); err != nil {
@@ -65,6 +220,239 @@ func main() {
 }
 
 func handle() {
-	d := nfs.NewDriver(nodeID, endpoint)
+	mode, err := strconv.ParseUint(defaultDirMode, 8, 32)
+	if err != nil || mode > 07777 {
+		fmt.Fprintf(os.Stderr, "invalid --default-dir-mode %q\n", defaultDirMode)
+		os.Exit(1)
+	}
+
+	var defaultResvPort *bool
+	if resvPort != "" {
+		b, err := strconv.ParseBool(resvPort)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid --resvport %q\n", resvPort)
+			os.Exit(1)
+		}
+		defaultResvPort = &b
+	}
+
+	m, err := nfs.NewMounter(mounter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid --mounter: %v\n", err)
+		os.Exit(1)
+	}
+
+	codes, err := nfs.ParseCodes(retryCodes)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid --retry-codes: %v\n", err)
+		os.Exit(1)
+	}
+
+	if clusterID != "" && !validation.IsSafePathElement(clusterID) {
+		fmt.Fprintf(os.Stderr, "invalid --cluster-id %q: must be usable as a directory name\n", clusterID)
+		os.Exit(1)
+	}
+	if _, _, ok := volume.ParseMigratedID(probeCanary); probeCanary != "" && !ok {
+		fmt.Fprintf(os.Stderr, "invalid --probe-canary %q: must be server:/path\n", probeCanary)
+		os.Exit(1)
+	}
+	var windows []nfs.TimeWindow
+	for _, s := range bgWindows {
+		w, err := nfs.ParseTimeWindow(s)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid --background-windows: %v\n", err)
+			os.Exit(1)
+		}
+		windows = append(windows, w)
+	}
+	var ioRate int64
+	if bgIORate != "" {
+		q, err := resource.ParseQuantity(bgIORate)
+		if err != nil || q.Sign() < 0 {
+			fmt.Fprintf(os.Stderr, "invalid --background-io-rate %q\n", bgIORate)
+			os.Exit(1)
+		}
+		ioRate = q.Value()
+	}
+	for _, share := range shares {
+		if _, _, ok := volume.ParseMigratedID(share); !ok {
+			fmt.Fprintf(os.Stderr, "invalid --shares %q: must be server:/path\n", share)
+			os.Exit(1)
+		}
+	}
+	for _, server := range allowedServers {
+		if _, err := validation.NormalizeServer(server); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid --allowed-servers: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	for value, server := range topoServers {
+		if _, err := validation.NormalizeServer(server); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid --topology-servers %v: %v\n", value, err)
+			os.Exit(1)
+		}
+	}
+	for _, export := range selfCheck {
+		if _, _, ok := volume.ParseMigratedID(export); !ok {
+			fmt.Fprintf(os.Stderr, "invalid --self-check-on-start %q: must be server:/path\n", export)
+			os.Exit(1)
+		}
+	}
+	if !validation.IsSafePathElement(snapshotsDir) {
+		fmt.Fprintf(os.Stderr, "invalid --snapshots-dir %q: must be a single directory name\n", snapshotsDir)
+		os.Exit(1)
+	}
+	if clusterIDSubDir && clusterID == "" {
+		fmt.Fprintf(os.Stderr, "--cluster-id-in-subdir requires --cluster-id\n")
+		os.Exit(1)
+	}
+
+	var demoServer *testserver.Server
+	var serverMountOptions map[string][]string
+	if demo {
+		demoServer = startDemoServer()
+		serverMountOptions = map[string][]string{demoServer.Address: demoServer.MountOptions}
+	}
+
+	var kubeClient kubernetes.Interface
+	var deleteJob *nfs.DeleteJobOptions
+	if deleteJobImage != "" {
+		deleteJob = &nfs.DeleteJobOptions{
+			Namespace:    deleteJobNamespace,
+			Image:        deleteJobImage,
+			NodeSelector: deleteJobNodeSelector,
+			Resources: v1.ResourceRequirements{
+				Limits: v1.ResourceList{},
+			},
+		}
+		for name, value := range map[v1.ResourceName]string{v1.ResourceCPU: deleteJobCPULimit, v1.ResourceMemory: deleteJobMemoryLimit} {
+			if value == "" {
+				continue
+			}
+			q, err := resource.ParseQuantity(value)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "invalid delete job %s limit %q: %v\n", name, value, err)
+				os.Exit(1)
+			}
+			deleteJob.Resources.Limits[name] = q
+		}
+	}
+	if deleteJob != nil || verifyPV || requireEmpty || nsPolicy != "" {
+		kubeClient = newKubeClient()
+	}
+
+	d := nfs.NewDriver(&nfs.DriverOptions{
+		NodeID:                 nodeID,
+		Endpoint:               endpoint,
+		WorkingMountDir:        workingMountDir,
+		DefaultDirMode:         os.FileMode(mode),
+		DirModeFromAccessModes: dirModeFromAccessModes,
+		ProvisioningUID:        provisioningUID,
+		ProvisioningGID:        provisioningGID,
+		DeleteParallelism:      deleteParallel,
+		DefaultResvPort:        defaultResvPort,
+		TCPProxy:               tcpProxy,
+		TCPProxyUpstream:       tcpProxyAddress,
+		CompressResponses:      grpcCompression,
+		MetricsAddress:         metricsAddress,
+		WorkingDirPruneAge:     pruneAge,
+		DNSCacheTTL:            dnsCacheTTL,
+		Mounter:                m,
+		KubeClient:             kubeClient,
+		DeleteJob:              deleteJob,
+		VerifyPVOnDelete:       verifyPV,
+		MaxConcurrentSnapshots: maxSnapshots,
+		SnapshotsDir:           snapshotsDir,
+		Shares:                 shares,
+		ScratchDir:             scratchDir,
+		MaxPublishesPerVolume:  maxPublishes,
+		Sysctls:                sysctls,
+		ModuleParams:           moduleParams,
+		RequireEmptyOnDelete:   requireEmpty,
+		ServerFailureThreshold: failThreshold,
+		ServerFailureCooldown:  failCooldown,
+		WarmUp:                 warmUp,
+		WarmUpServers:          warmUpServers,
+		MountStatsMetrics:      mountStats,
+		NFS4Roots:              nfs4Roots,
+		ServerMountOptions:     serverMountOptions,
+		ClusterID:              clusterID,
+		QuarantineDir:          quarantineDir,
+		LegacyArchiveNames:     legacyArchive,
+		LegacyVolumeIDs:        legacyIDs,
+		UUIDSuffix:             uuidSuffix,
+		TagXattrs:              tagXattrs,
+		VerifyServer:           verifyServer,
+		ControllerPublish:      ctrlPublish,
+		ShareAliasesFile:       shareAliases,
+		ClusterIDInSubDir:      clusterIDSubDir,
+		CapacityCacheTTL:       capacityTTL,
+		AutofsRoot:             autofsRoot,
+		NamespacePolicy:        nsPolicy,
+		MountRatePerServer:     mountRate,
+		MountBurstPerServer:    mountBurst,
+		ProbeCanary:            probeCanary,
+		ProbeCanaryInterval:    canaryInterval,
+		BackgroundWindows:      windows,
+		BackgroundIORate:       ioRate,
+		MaxExportMetricLabels:  exportLabels,
+		SelfCheckExports:       selfCheck,
+		AllowedServers:         allowedServers,
+		TopologyKey:            topologyKey,
+		TopologyServers:        topoServers,
+		NodeTopology:           nodeTopology,
+		RetryPolicy: nfs.RetryPolicy{
+			MaxAttempts:    retryAttempts,
+			BaseDelay:      retryBaseDelay,
+			MaxDelay:       retryMaxDelay,
+			RetryableCodes: codes,
+		},
+	})
+	if demoServer != nil {
+		stopOnSignal(d)
+	}
 	d.Run()
+	if demoServer != nil {
+		if err := demoServer.Stop(); err != nil {
+			glog.Errorf("failed to stop demo nfs server: %v", err)
+		}
+	}
+}
+
+// startDemoServer serves a temporary directory over NFS for demo mode
+func startDemoServer() *testserver.Server {
+	server, err := testserver.Start(demoDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to start demo nfs server: %v\n", err)
+		os.Exit(1)
+	}
+	glog.Infof("Demo mode: volumes can use server=%s share=%s", server.Address, server.Share)
+	return server
+}
+
+// stopOnSignal stops d when the driver is terminated, so that Run returns
+// and the demo server is stopped
+func stopOnSignal(d *nfs.Driver) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-c
+		glog.Infof("Stopping the driver")
+		d.Stop()
+	}()
+}
+
+// newKubeClient returns a client for the cluster the driver runs in
+func newKubeClient() kubernetes.Interface {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to get in-cluster config: %v\n", err)
+		os.Exit(1)
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create kubernetes client: %v\n", err)
+		os.Exit(1)
+	}
+	return client
 }
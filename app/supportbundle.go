@@ -0,0 +1,101 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/nfs"
+)
+
+// newSupportBundleCommand returns the support-bundle subcommand, which
+// collects a support bundle of the driver running in the same container,
+// e.g. with kubectl exec
+func newSupportBundleCommand() *cobra.Command {
+	var (
+		output     string
+		pid        int
+		metricsURL string
+	)
+	cmd := &cobra.Command{
+		Use:   "support-bundle",
+		Short: "Collect configuration, mounts and metrics of the running driver into an archive for bug reports",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := writeSupportBundle(output, pid, metricsURL); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to write support bundle: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Fprintf(os.Stderr, "Wrote support bundle to %s\n", output)
+		},
+	}
+	cmd.Flags().StringVar(&output, "output", "nfs-support-bundle.tar.gz", "file to write the bundle to, - for stdout")
+	cmd.Flags().IntVar(&pid, "pid", 1, "process id of the driver, whose command line is included")
+	cmd.Flags().StringVar(&metricsURL, "metrics-url", "", "URL of the metrics of the driver, e.g. http://127.0.0.1:8080/metrics")
+	return cmd
+}
+
+func writeSupportBundle(output string, pid int, metricsURL string) error {
+	b := &nfs.SupportBundle{
+		WorkingMountDir: workingMountDir,
+	}
+	cmdline, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		b.Args = []string{fmt.Sprintf("error: %v", err)}
+	} else {
+		b.Args = strings.Split(string(bytes.TrimRight(cmdline, "\x00")), "\x00")
+	}
+	if metricsURL != "" {
+		b.Metrics, err = fetchMetrics(metricsURL)
+		if err != nil {
+			b.Metrics = []byte(fmt.Sprintf("error: %v\n", err))
+		}
+	}
+
+	if output == "-" {
+		return b.Write(os.Stdout)
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	if err := b.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func fetchMetrics(url string) ([]byte, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
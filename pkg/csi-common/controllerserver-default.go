@@ -24,7 +24,10 @@ import (
 	"google.golang.org/grpc/status"
 )
 
+// DefaultControllerServer answers the RPCs it does not implement, including
+// those of newer spec versions, with Unimplemented
 type DefaultControllerServer struct {
+	csi.UnimplementedControllerServer
 	Driver *CSIDriver
 }
 
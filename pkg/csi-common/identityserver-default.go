@@ -24,7 +24,10 @@ import (
 	"google.golang.org/grpc/status"
 )
 
+// DefaultIdentityServer answers the RPCs it does not implement, including
+// those of newer spec versions, with Unimplemented
 type DefaultIdentityServer struct {
+	csi.UnimplementedIdentityServer
 	Driver *CSIDriver
 }
 
@@ -24,7 +24,10 @@ import (
 	"google.golang.org/grpc/status"
 )
 
+// DefaultNodeServer answers the RPCs it does not implement, including
+// those of newer spec versions, with Unimplemented
 type DefaultNodeServer struct {
+	csi.UnimplementedNodeServer
 	Driver *CSIDriver
 }
 
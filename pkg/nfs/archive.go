@@ -0,0 +1,143 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/golang/glog"
+)
+
+// Suffix of archives that are still being written
+const partialArchiveSuffix = ".partial"
+
+// writeArchive archives the contents of dir into the file dest. The
+// archive is streamed straight into dest, which is usually on the nfs
+// share as well, so nothing is staged on the local disk of the driver and
+// memory use does not depend on the size of dir. The archive is written
+// under a temporary name first and only appears at dest once complete.
+// All writes to dest pass through throttle.
+func writeArchive(dir, dest string, throttle func(io.Writer) io.Writer) (err error) {
+	partial := dest + partialArchiveSuffix
+	f, err := os.OpenFile(partial, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			if rmErr := os.Remove(partial); rmErr != nil && !os.IsNotExist(rmErr) {
+				glog.Warningf("failed to remove partial archive %v: %v", partial, rmErr)
+			}
+		}
+	}()
+
+	if err = archiveDir(dir, throttle(f)); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(partial, dest)
+}
+
+// archiveDir writes a gzip compressed tar archive of the contents of dir
+// to w, one entry at a time.
+func archiveDir(dir string, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	gw := gzip.NewWriter(bw)
+	tw := tar.NewWriter(gw)
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		return addArchiveEntry(tw, path, filepath.ToSlash(rel), info)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func addArchiveEntry(tw *tar.Writer, path, name string, info os.FileInfo) error {
+	var link string
+	switch mode := info.Mode(); {
+	case mode.IsRegular(), mode.IsDir():
+	case mode&os.ModeSymlink != 0:
+		var err error
+		if link, err = os.Readlink(path); err != nil {
+			return err
+		}
+	default:
+		// Sockets, devices and fifos cannot be restored meaningfully
+		glog.V(4).Infof("Skipping %v of type %v while archiving", path, mode.Type())
+		return nil
+	}
+
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	n, err := io.Copy(tw, f)
+	if err != nil {
+		return err
+	}
+	if n != hdr.Size {
+		return fmt.Errorf("%v changed size while archiving", path)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/nfs/validation"
+)
+
+// autofsPath returns the path of share on server under the autofs root,
+// laid out like the -hosts map: {root}/{server}/{share}
+func (ns *nodeServer) autofsPath(server, share string) (string, error) {
+	if !validation.IsSafeRelativePath(share) || !validation.IsSafePathElement(server) {
+		return "", fmt.Errorf("invalid share %v:%v: invalid argument", server, share)
+	}
+	return filepath.Join(ns.driver.autofsRoot, server, share), nil
+}
+
+// publishAutofs bind mounts the autofs managed path of share at
+// targetPath instead of mounting the share itself. autofs mounts the
+// share on first access, with the options of its map, so nfs mount
+// options of the volume do not apply.
+func (ns *nodeServer) publishAutofs(ctx context.Context, server, share, targetPath string, readOnly bool) error {
+	source, err := ns.autofsPath(server, share)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// Accessing the path triggers the automount, which hangs if the
+	// server does not respond
+	statErr := make(chan error, 1)
+	go func() {
+		_, err := os.Stat(source)
+		statErr <- err
+	}()
+	select {
+	case err := <-statErr:
+		if err != nil {
+			return mountError(fmt.Errorf("autofs path %v is not available: %v", source, err))
+		}
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	}
+
+	options := []string{"bind"}
+	if readOnly {
+		options = append(options, "ro")
+	}
+	glog.V(4).Infof("Bind mounting autofs path %v at %v", source, targetPath)
+	return mountError(ns.mounter.Mount(source, targetPath, "", options))
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// serverBreakers is a circuit breaker per nfs server. After threshold
+// consecutive failures to reach a server, new mounts of that server fail
+// fast for cooldown instead of queueing more mounts against a server that
+// is down. Afterwards a single probe is let through while other requests
+// keep failing; another failure of the probe opens the breaker again.
+// Other servers are not affected.
+type serverBreakers struct {
+	// Consecutive failures that open a breaker, 0 to disable breakers
+	threshold int
+	cooldown  time.Duration
+
+	mutex   sync.Mutex
+	servers map[string]*breaker
+}
+
+type breaker struct {
+	failures  int
+	openUntil time.Time
+	// End of the half open state's probe in flight, zero if there is
+	// none. A probe that never reports back is replaced after cooldown.
+	probeUntil time.Time
+}
+
+func newServerBreakers(threshold int, cooldown time.Duration) *serverBreakers {
+	return &serverBreakers{
+		threshold: threshold,
+		cooldown:  cooldown,
+		servers:   map[string]*breaker{},
+	}
+}
+
+// check returns an Unavailable error if the breaker of server is open,
+// or half open with a probe in flight. Otherwise the caller may mount
+// the server and must record the outcome. On gRPC requests the time
+// after which to retry is also set as "retry-after" trailer (in seconds).
+func (b *serverBreakers) check(ctx context.Context, server string) error {
+	if b.threshold <= 0 {
+		return nil
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	s, ok := b.servers[server]
+	if !ok || s.openUntil.IsZero() {
+		return nil
+	}
+	now := time.Now()
+	until := s.openUntil
+	if !now.Before(s.openUntil) {
+		if !now.Before(s.probeUntil) {
+			// Half open: let this attempt through as the probe, the next
+			// failure opens the breaker again
+			s.probeUntil = now.Add(b.cooldown)
+			s.failures = b.threshold - 1
+			return nil
+		}
+		until = s.probeUntil
+	}
+
+	retryAfter := int(until.Sub(now)/time.Second) + 1
+	if grpc.ServerTransportStreamFromContext(ctx) != nil {
+		grpc.SetTrailer(ctx, metadata.Pairs("retry-after", fmt.Sprintf("%d", retryAfter)))
+	}
+	return status.Errorf(codes.Unavailable, "nfs server %v failed %d times in a row, retry after %ds", server, s.failures, retryAfter)
+}
+
+// Messages of mount.nfs and the kernel for servers that cannot be reached
+var serverFailureMessages = []string{
+	"connection refused",
+	"connection reset",
+	"connection timed out",
+	"timed out",
+	"no route to host",
+	"network is unreachable",
+	"host is down",
+	"not responding",
+}
+
+// isServerFailure reports whether err of a mount says that the server
+// could not be reached. Other errors, e.g. of invalid mount options,
+// missing shares or requests given up by their caller, say nothing about
+// the server and must not fail the requests of other volumes on it.
+func isServerFailure(err error) bool {
+	if err == nil || err == context.Canceled {
+		return false
+	}
+	if err == context.DeadlineExceeded {
+		return true
+	}
+	s, ok := status.FromError(err)
+	if ok {
+		switch s.Code() {
+		case codes.Unavailable, codes.DeadlineExceeded:
+			return true
+		case codes.Internal, codes.Unknown:
+		default:
+			return false
+		}
+	}
+	msg := strings.ToLower(err.Error())
+	for _, m := range serverFailureMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// record records the outcome of mounting server
+func (b *serverBreakers) record(server string, err error) {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if err == nil {
+		delete(b.servers, server)
+		return
+	}
+	s, ok := b.servers[server]
+	if !isServerFailure(err) {
+		if ok {
+			// No verdict on the server, the next request is the probe
+			s.probeUntil = time.Time{}
+		}
+		return
+	}
+	if !ok {
+		s = &breaker{}
+		b.servers[server] = s
+	}
+	s.failures++
+	s.probeUntil = time.Time{}
+	if s.failures >= b.threshold {
+		s.openUntil = time.Now().Add(b.cooldown)
+		glog.Warningf("nfs server %v failed %d times in a row, failing requests for it for %v: %v", server, s.failures, b.cooldown, err)
+	}
+}
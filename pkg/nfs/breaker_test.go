@@ -0,0 +1,186 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Mount error of a server that is down
+var errServerDown = status.Error(codes.Internal, "mount failed: exit status 32, output: mount.nfs: Connection timed out")
+
+func TestIsServerFailure(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errServerDown, true},
+		{status.Error(codes.Internal, "mount.nfs: Connection refused"), true},
+		{status.Error(codes.Internal, "mount.nfs: No route to host"), true},
+		{status.Error(codes.Unavailable, "server unavailable"), true},
+		{status.Error(codes.DeadlineExceeded, "mount timed out"), true},
+		{context.DeadlineExceeded, true},
+		{errors.New("dial tcp 192.0.2.10:2049: connect: connection refused"), true},
+		{context.Canceled, false},
+		{status.Error(codes.Canceled, "canceled"), false},
+		{status.Error(codes.InvalidArgument, "mount.nfs: an incorrect mount option was specified"), false},
+		{status.Error(codes.NotFound, "share not found"), false},
+		{status.Error(codes.ResourceExhausted, "too many mounts"), false},
+		{status.Error(codes.Aborted, "an operation on the volume is in progress"), false},
+		{status.Error(codes.PermissionDenied, "mount.nfs: access denied by server while mounting"), false},
+		{status.Error(codes.Internal, "mount.nfs: mounting 192.0.2.10:/export failed, reason given by server: No such file or directory"), false},
+	}
+	for _, test := range tests {
+		if got := isServerFailure(test.err); got != test.want {
+			t.Errorf("isServerFailure(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
+
+func TestServerBreakersOpen(t *testing.T) {
+	b := newServerBreakers(3, time.Hour)
+	ctx := context.Background()
+
+	// Errors that say nothing about the server are not counted
+	for _, mountErr := range []error{
+		status.Error(codes.InvalidArgument, "invalid mount option"),
+		status.Error(codes.NotFound, "share not found"),
+		status.Error(codes.ResourceExhausted, "too many mounts"),
+		status.Error(codes.Aborted, "in progress"),
+		context.Canceled,
+	} {
+		for i := 0; i < 3; i++ {
+			b.record("nfs", mountErr)
+		}
+		if err := b.check(ctx, "nfs"); err != nil {
+			t.Fatalf("breaker opened after errors %v: %v", mountErr, err)
+		}
+	}
+
+	b.record("nfs", errServerDown)
+	b.record("nfs", errServerDown)
+	if err := b.check(ctx, "nfs"); err != nil {
+		t.Fatalf("breaker opened before the threshold: %v", err)
+	}
+	b.record("nfs", errServerDown)
+	err := b.check(ctx, "nfs")
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("check() = %v, want Unavailable", err)
+	}
+	if err := b.check(ctx, "other"); err != nil {
+		t.Errorf("breaker of another server is open: %v", err)
+	}
+
+	// A success closes the breaker
+	b.record("nfs", nil)
+	if err := b.check(ctx, "nfs"); err != nil {
+		t.Errorf("breaker stayed open after a success: %v", err)
+	}
+}
+
+func TestServerBreakersHalfOpen(t *testing.T) {
+	cooldown := 50 * time.Millisecond
+	b := newServerBreakers(1, cooldown)
+	ctx := context.Background()
+	open := func() {
+		b.record("nfs", errServerDown)
+		if status.Code(b.check(ctx, "nfs")) != codes.Unavailable {
+			t.Fatal("breaker did not open")
+		}
+		time.Sleep(cooldown)
+	}
+
+	// Only one probe is let through at a time
+	open()
+	if err := b.check(ctx, "nfs"); err != nil {
+		t.Fatalf("probe was not let through: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := b.check(ctx, "nfs"); status.Code(err) != codes.Unavailable {
+			t.Fatalf("check() during the probe = %v, want Unavailable", err)
+		}
+	}
+
+	// A failed probe opens the breaker again
+	b.record("nfs", errServerDown)
+	if err := b.check(ctx, "nfs"); status.Code(err) != codes.Unavailable {
+		t.Fatalf("check() after a failed probe = %v, want Unavailable", err)
+	}
+	time.Sleep(cooldown)
+
+	// A probe without verdict lets the next one through
+	if err := b.check(ctx, "nfs"); err != nil {
+		t.Fatalf("probe was not let through: %v", err)
+	}
+	b.record("nfs", status.Error(codes.InvalidArgument, "invalid mount option"))
+	if err := b.check(ctx, "nfs"); err != nil {
+		t.Fatalf("probe after a probe without verdict was not let through: %v", err)
+	}
+
+	// A probe that never reports back is replaced after the cooldown
+	time.Sleep(cooldown)
+	if err := b.check(ctx, "nfs"); err != nil {
+		t.Fatalf("probe replacing a lost one was not let through: %v", err)
+	}
+
+	// A successful probe closes the breaker
+	b.record("nfs", nil)
+	for i := 0; i < 3; i++ {
+		if err := b.check(ctx, "nfs"); err != nil {
+			t.Fatalf("check() after a successful probe = %v", err)
+		}
+	}
+}
+
+// trailerStream records the trailer of a fake gRPC request
+type trailerStream struct {
+	grpc.ServerTransportStream
+	trailer metadata.MD
+}
+
+func (s *trailerStream) SetTrailer(md metadata.MD) error {
+	s.trailer = metadata.Join(s.trailer, md)
+	return nil
+}
+
+func TestServerBreakersRetryAfter(t *testing.T) {
+	b := newServerBreakers(1, time.Minute)
+	b.record("nfs", errServerDown)
+
+	stream := &trailerStream{}
+	ctx := grpc.NewContextWithServerTransportStream(context.Background(), stream)
+	if err := b.check(ctx, "nfs"); status.Code(err) != codes.Unavailable {
+		t.Fatalf("check() = %v, want Unavailable", err)
+	}
+	if got := stream.trailer.Get("retry-after"); len(got) != 1 || got[0] != "60" {
+		t.Errorf("retry-after trailer = %v, want [60]", got)
+	}
+
+	// Contexts of background work are no gRPC requests
+	if err := b.check(context.Background(), "nfs"); status.Code(err) != codes.Unavailable {
+		t.Fatalf("check() = %v, want Unavailable", err)
+	}
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"path/filepath"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// CapacityProvider reports the capacity available for new volumes on an
+// export. The default provider mounts the export and calls statfs on it;
+// alternative providers may ask the NAS management API or a quota agent
+// instead.
+type CapacityProvider interface {
+	// AvailableCapacity returns the number of bytes available on the
+	// share of server.
+	AvailableCapacity(ctx context.Context, server, share string) (int64, error)
+}
+
+// QuotaProvider limits volume directories to their capacity, e.g. through
+// a project quota agent on the nfs server. Without a provider volumes are
+// directories without quota that may use all space of their share.
+type QuotaProvider interface {
+	// SetQuota limits the directory dir, relative to the share of
+	// server, e.g. "/export", to capacity bytes. CreateVolume calls it before the
+	// volume is set up, and ControllerExpandVolume with the new
+	// capacity before reporting it. It must be idempotent, since both
+	// are retried.
+	SetQuota(ctx context.Context, server, share, dir string, capacity int64) error
+}
+
+// setQuota limits the subdirectory of vol to capacity bytes with the
+// quota provider, if there is one. Volumes without a capacity and volumes
+// that are the base share itself are not limited.
+func (cs *controllerServer) setQuota(ctx context.Context, vol *nfsVolume, capacity int64) error {
+	if cs.quota == nil || capacity <= 0 || vol.subDir == "" {
+		return nil
+	}
+	share := filepath.Join(string(filepath.Separator), vol.baseDir)
+	if err := cs.quota.SetQuota(ctx, vol.server, share, vol.subDir, capacity); err != nil {
+		if _, ok := status.FromError(err); ok {
+			return err
+		}
+		return status.Errorf(codes.Internal, "failed to set quota of volume %v: %v", vol.id, err)
+	}
+	return nil
+}
+
+// statfsCapacityProvider reports the free space of the filesystem behind
+// an export
+type statfsCapacityProvider struct {
+	cs *controllerServer
+}
+
+func (p *statfsCapacityProvider) AvailableCapacity(ctx context.Context, server, share string) (int64, error) {
+	vol := &nfsVolume{
+		server:  server,
+		baseDir: share,
+	}
+	var available int64
+	err := p.cs.exports.run(ctx, vol, func(mountPath string) error {
+		var err error
+		available, err = statfsAvailable(mountPath)
+		return err
+	})
+	return available, err
+}
+
+// invalidateCapacity drops the cached capacity of the share of vol after
+// a volume was created or deleted on it
+func (cs *controllerServer) invalidateCapacity(vol *nfsVolume) {
+	if cs.capacityCache != nil {
+		cs.capacityCache.invalidate(vol.server, vol.baseDir)
+	}
+}
+
+func (cs *controllerServer) GetCapacity(ctx context.Context, req *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
+	if err := cs.driver.csiDriver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_GET_CAPACITY); err != nil {
+		return nil, err
+	}
+	// Capabilities are optional here, unlike in CreateVolume
+	if caps := req.GetVolumeCapabilities(); len(caps) > 0 {
+		if err := cs.validateVolumeCapabilities(caps); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	if len(req.GetParameters()) == 0 {
+		// Without a share there is no capacity to report
+		return &csi.GetCapacityResponse{}, nil
+	}
+
+	params, err := cs.shareAliasParameters(req.GetParameters())
+	if err != nil {
+		return nil, err
+	}
+	if topology := req.GetAccessibleTopology(); topology != nil {
+		if params, err = cs.topologyParameters(params, []*csi.Topology{topology}); err != nil {
+			// No server in the segment, so no capacity either
+			return &csi.GetCapacityResponse{}, nil
+		}
+	}
+
+	// The name only has to pass validation, nothing is created
+	nfsVol, err := cs.newNFSVolume("capacity", 0, params)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	available, err := cs.capacity.AvailableCapacity(ctx, nfsVol.server, nfsVol.baseDir)
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+		return nil, status.Errorf(codes.Internal, "failed to get capacity of %v:%v: %v", nfsVol.server, nfsVol.baseDir, err)
+	}
+	return &csi.GetCapacityResponse{AvailableCapacity: available}, nil
+}
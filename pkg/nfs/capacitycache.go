@@ -0,0 +1,96 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// capacityCache remembers the capacity of every share for a short time.
+// The external-provisioner polls GetCapacity for every StorageClass,
+// which would otherwise mount and statfs the share every time. Creating
+// or deleting a volume on a share invalidates its entry.
+type capacityCache struct {
+	provider CapacityProvider
+	ttl      time.Duration
+
+	mutex   sync.Mutex
+	entries map[string]capacityEntry
+	// Bumped by every invalidation of a share, so that a capacity that
+	// was measured before it is not cached
+	generations map[string]uint64
+}
+
+type capacityEntry struct {
+	available int64
+	expires   time.Time
+}
+
+func newCapacityCache(provider CapacityProvider, ttl time.Duration) *capacityCache {
+	return &capacityCache{
+		provider:    provider,
+		ttl:         ttl,
+		entries:     map[string]capacityEntry{},
+		generations: map[string]uint64{},
+	}
+}
+
+func capacityKey(server, share string) string {
+	return fmt.Sprintf("%s:%s", server, filepath.Join(string(filepath.Separator), share))
+}
+
+func (c *capacityCache) AvailableCapacity(ctx context.Context, server, share string) (int64, error) {
+	key := capacityKey(server, share)
+	c.mutex.Lock()
+	entry, ok := c.entries[key]
+	generation := c.generations[key]
+	c.mutex.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.available, nil
+	}
+
+	available, err := c.provider.AvailableCapacity(ctx, server, share)
+	if err != nil {
+		return 0, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	// A volume was created or deleted while measuring, the capacity may
+	// be from before it
+	if c.generations[key] == generation {
+		c.entries[key] = capacityEntry{
+			available: available,
+			expires:   time.Now().Add(c.ttl),
+		}
+	}
+	return available, nil
+}
+
+// invalidate drops the cached capacity of share on server, as well as
+// capacities that are being measured
+func (c *capacityCache) invalidate(server, share string) {
+	key := capacityKey(server, share)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.entries, key)
+	c.generations[key]++
+}
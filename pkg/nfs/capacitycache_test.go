@@ -0,0 +1,123 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// fakeCapacityProvider reports available bytes and counts the calls.
+// While measuring is set, calls signal measuring and wait for release.
+type fakeCapacityProvider struct {
+	mutex     sync.Mutex
+	available int64
+	calls     int
+	measuring chan struct{}
+	release   chan struct{}
+}
+
+func (p *fakeCapacityProvider) AvailableCapacity(ctx context.Context, server, share string) (int64, error) {
+	p.mutex.Lock()
+	p.calls++
+	available := p.available
+	measuring, release := p.measuring, p.release
+	p.mutex.Unlock()
+	if measuring != nil {
+		measuring <- struct{}{}
+		<-release
+	}
+	return available, nil
+}
+
+func (p *fakeCapacityProvider) set(available int64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.available = available
+}
+
+func TestCapacityCache(t *testing.T) {
+	p := &fakeCapacityProvider{available: 100}
+	c := newCapacityCache(p, time.Minute)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if got, _ := c.AvailableCapacity(ctx, "nfs", "/export"); got != 100 {
+			t.Fatalf("AvailableCapacity() = %d, want 100", got)
+		}
+	}
+	if p.calls != 1 {
+		t.Errorf("provider was called %d times, want once", p.calls)
+	}
+	// Spellings of the same share share the entry
+	c.AvailableCapacity(ctx, "nfs", "export/")
+	if p.calls != 1 {
+		t.Errorf("provider was called %d times, want once", p.calls)
+	}
+
+	p.set(50)
+	c.invalidate("nfs", "export")
+	if got, _ := c.AvailableCapacity(ctx, "nfs", "/export"); got != 50 {
+		t.Errorf("AvailableCapacity() after invalidate = %d, want 50", got)
+	}
+	if p.calls != 2 {
+		t.Errorf("provider was called %d times, want twice", p.calls)
+	}
+}
+
+func TestCapacityCacheInvalidateWhileMeasuring(t *testing.T) {
+	p := &fakeCapacityProvider{
+		available: 100,
+		measuring: make(chan struct{}),
+		release:   make(chan struct{}),
+	}
+	c := newCapacityCache(p, time.Minute)
+	ctx := context.Background()
+
+	done := make(chan int64)
+	go func() {
+		available, _ := c.AvailableCapacity(ctx, "nfs", "/export")
+		done <- available
+	}()
+	<-p.measuring
+	// A volume is created while the capacity from before it is measured
+	c.invalidate("nfs", "/export")
+	close(p.release)
+	if got := <-done; got != 100 {
+		t.Errorf("AvailableCapacity() = %d, want 100", got)
+	}
+
+	p.mutex.Lock()
+	p.measuring = nil
+	p.mutex.Unlock()
+	p.set(50)
+	if got, _ := c.AvailableCapacity(ctx, "nfs", "/export"); got != 50 {
+		t.Errorf("AvailableCapacity() after an invalidation while measuring = %d, want 50", got)
+	}
+	if p.calls != 2 {
+		t.Errorf("provider was called %d times, want twice", p.calls)
+	}
+
+	// Measurements that did not overlap an invalidation are cached
+	p.set(25)
+	if got, _ := c.AvailableCapacity(ctx, "nfs", "/export"); got != 50 {
+		t.Errorf("AvailableCapacity() = %d, want the cached 50", got)
+	}
+}
@@ -0,0 +1,213 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/nfs/volume"
+)
+
+// checkCloneSource checks that the volume with id can be cloned into vol
+// and returns it. The source may be on another share or server than vol.
+// A clone must not be smaller than its source, as far as the metadata of
+// the source tells its capacity.
+func (cs *controllerServer) checkCloneSource(ctx context.Context, vol *nfsVolume, id string) (*nfsVolume, error) {
+	if err := cs.driver.csiDriver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_CLONE_VOLUME); err != nil {
+		return nil, err
+	}
+	if vol.subDir == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "volumes that share the base directory cannot be cloned into")
+	}
+	if _, _, ok := volume.ParseMigratedID(id); ok {
+		return nil, status.Errorf(codes.InvalidArgument, "volume %v was migrated from an in-tree volume and cannot be cloned", id)
+	}
+	source, err := cs.getNfsVolFromId(id)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "volume %v not found: %v", id, err)
+	}
+	if source.subDir == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "volume %v shares the base directory and cannot be cloned", id)
+	}
+	err = cs.exports.run(ctx, source, func(mountPath string) error {
+		dir := filepath.Join(mountPath, source.subDir)
+		if _, err := os.Stat(dir); err != nil {
+			if os.IsNotExist(err) {
+				return status.Errorf(codes.NotFound, "volume %v not found", id)
+			}
+			return err
+		}
+		md, err := readTrustedMetadata(dir)
+		if err != nil {
+			return err
+		}
+		if md != nil && vol.size > 0 && vol.size < md.capacity() {
+			return status.Errorf(codes.OutOfRange, "requested capacity %d is smaller than the %d bytes of volume %v", vol.size, md.capacity(), id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+	return source, nil
+}
+
+// sameShare tells whether volumes a and b are on the same share
+func sameShare(a, b *nfsVolume) bool {
+	return a.server == b.server && filepath.Clean("/"+a.baseDir) == filepath.Clean("/"+b.baseDir)
+}
+
+// mountCloneSource returns the path of the directory of source for
+// cloning it into vol, whose share is mounted at mountPath. A source on
+// another share holds the mount of its share until release is called, so
+// that volumes can be copied between servers.
+func (cs *controllerServer) mountCloneSource(ctx context.Context, source, vol *nfsVolume, mountPath string) (path string, release func(), err error) {
+	if sameShare(source, vol) {
+		return filepath.Join(mountPath, source.subDir), func() {}, nil
+	}
+	sourceMountPath, release, err := cs.mounts.acquire(ctx, source)
+	if err != nil {
+		return "", nil, status.Errorf(codes.Internal, "failed to mount share of volume %v: %v", source.id, err)
+	}
+	return filepath.Join(sourceMountPath, source.subDir), release, nil
+}
+
+// copyTree copies the contents of directory src into the existing
+// directory dst, keeping modes, owners where permitted, modification
+// times and symlinks. File contents are written through throttle. If
+// copying fails or ctx is done, dst is removed together with whatever was
+// copied so far.
+func copyTree(ctx context.Context, src, dst string, throttle func(io.Writer) io.Writer) (err error) {
+	defer func() {
+		if err == nil {
+			return
+		}
+		if rmErr := os.RemoveAll(dst); rmErr != nil {
+			glog.Errorf("failed to remove partial copy %v: %v", dst, rmErr)
+		}
+	}()
+
+	// Directories get their final mode after their entries are copied
+	var dirs []*tar.Header
+	err = filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." || rel == volumeMetadataFile {
+			// The copy gets metadata of its own
+			return nil
+		}
+		return copyEntry(path, filepath.Join(dst, rel), info, &dirs, throttle)
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		path := dirs[i].Name
+		if err := os.Chmod(path, dirs[i].FileInfo().Mode().Perm()); err != nil {
+			return err
+		}
+		if err := os.Chtimes(path, dirs[i].ModTime, dirs[i].ModTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyEntry copies the file, directory or symlink path to target.
+// Directories are appended to dirs, named by their path in the copy.
+func copyEntry(path, target string, info os.FileInfo, dirs *[]*tar.Header, throttle func(io.Writer) io.Writer) error {
+	var link string
+	switch mode := info.Mode(); {
+	case mode.IsRegular(), mode.IsDir():
+	case mode&os.ModeSymlink != 0:
+		var err error
+		if link, err = os.Readlink(path); err != nil {
+			return err
+		}
+	default:
+		glog.V(4).Infof("Skipping %v of type %v while cloning", path, mode.Type())
+		return nil
+	}
+	// The tar header carries the owner in a portable way
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case info.IsDir():
+		if err := os.Mkdir(target, 0700); err != nil {
+			return err
+		}
+		hdr.Name = target
+		*dirs = append(*dirs, hdr)
+	case link != "":
+		if err := os.Symlink(link, target); err != nil {
+			return err
+		}
+	default:
+		if err := copyFile(path, target, info.Mode().Perm(), throttle); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Lchown(target, hdr.Uid, hdr.Gid); err != nil && !os.IsPermission(err) {
+		return err
+	}
+	if info.Mode().IsRegular() {
+		return os.Chtimes(target, info.ModTime(), info.ModTime())
+	}
+	return nil
+}
+
+func copyFile(src, dst string, mode os.FileMode, throttle func(io.Writer) io.Writer) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(throttle(out), in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Chmod(dst, mode)
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCheckCloneSourceCapacity(t *testing.T) {
+	workDir := t.TempDir()
+	cs := newTestControllerServer(WithWorkingMountDir(workDir))
+	cs.driver.ns = NewNodeServer(cs.driver)
+	share := &nfsVolume{server: "192.0.2.10", baseDir: "export"}
+	// The fake mounter leaves the share at its mount path as it is
+	mountPath := filepath.Join(workDir, exportMountName(exportKey(share)))
+	if err := os.MkdirAll(mountPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	create := func(name string, capacity int64) (*csi.CreateVolumeResponse, error) {
+		return cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+			Name:               name,
+			Parameters:         map[string]string{paramServer: "192.0.2.10", paramShare: "/export"},
+			VolumeCapabilities: []*csi.VolumeCapability{testMountCapability},
+			CapacityRange:      &csi.CapacityRange{RequiredBytes: capacity},
+		})
+	}
+	check := func(sourceID string, capacity int64) error {
+		vol := &nfsVolume{server: "192.0.2.10", baseDir: "export", subDir: "clone", name: "clone", size: capacity}
+		_, err := cs.checkCloneSource(context.Background(), vol, sourceID)
+		return err
+	}
+
+	resp, err := create("pvc-1", 2<<30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sourceID := resp.GetVolume().GetVolumeId()
+	resp, err = create("pvc-2", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsizedID := resp.GetVolume().GetVolumeId()
+
+	tests := []struct {
+		name     string
+		sourceID string
+		capacity int64
+		code     codes.Code
+	}{
+		{"same size", sourceID, 2 << 30, codes.OK},
+		{"larger", sourceID, 4 << 30, codes.OK},
+		{"smaller", sourceID, 1 << 30, codes.OutOfRange},
+		// The size is up to the driver
+		{"no capacity", sourceID, 0, codes.OK},
+		{"source without capacity", unsizedID, 1 << 30, codes.OK},
+		{"missing source", "v2:192.0.2.10/export/pvc-3/pvc-3", 2 << 30, codes.NotFound},
+	}
+	for _, test := range tests {
+		if err := check(test.sourceID, test.capacity); status.Code(err) != test.code {
+			t.Errorf("%s: checkCloneSource() = %v, want %v", test.name, err, test.code)
+		}
+	}
+
+	// Clones of an expanded volume must have its expanded size
+	if _, err := cs.ControllerExpandVolume(context.Background(), &csi.ControllerExpandVolumeRequest{
+		VolumeId:      sourceID,
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 4 << 30},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := check(sourceID, 2<<30); status.Code(err) != codes.OutOfRange {
+		t.Errorf("checkCloneSource() of the expanded volume = %v, want %v", err, codes.OutOfRange)
+	}
+	if err := check(sourceID, 4<<30); err != nil {
+		t.Errorf("checkCloneSource() of the expanded volume = %v", err)
+	}
+	// Retries of the CreateVolume request of the source still succeed
+	if _, err := create("pvc-1", 2<<30); err != nil {
+		t.Errorf("CreateVolume() retry after expansion = %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// publishedNodes keeps track of the nodes each volume is published to, as
+// told by ControllerPublishVolume. The controller forgets them when it
+// restarts; the external-attacher then finds the attachments missing from
+// ListVolumes and publishes them again.
+type publishedNodes struct {
+	mutex sync.Mutex
+	nodes map[string]map[string]bool
+}
+
+func newPublishedNodes() *publishedNodes {
+	return &publishedNodes{nodes: map[string]map[string]bool{}}
+}
+
+// add records that volumeID is published to nodeID
+func (p *publishedNodes) add(volumeID, nodeID string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	nodes, ok := p.nodes[volumeID]
+	if !ok {
+		nodes = map[string]bool{}
+		p.nodes[volumeID] = nodes
+	}
+	nodes[nodeID] = true
+}
+
+// remove records that volumeID is unpublished from nodeID, or from all
+// nodes if nodeID is empty
+func (p *publishedNodes) remove(volumeID, nodeID string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if nodeID == "" {
+		delete(p.nodes, volumeID)
+		return
+	}
+	nodes := p.nodes[volumeID]
+	delete(nodes, nodeID)
+	if len(nodes) == 0 {
+		delete(p.nodes, volumeID)
+	}
+}
+
+// get returns the sorted ids of the nodes volumeID is published to
+func (p *publishedNodes) get(volumeID string) []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	var ids []string
+	for id := range p.nodes[volumeID] {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// ControllerPublishVolume only records the node, since nfs volumes need
+// nothing attached to nodes. The volume is not looked up on its share so
+// that attaching stays cheap; NodePublishVolume fails for missing volumes.
+func (cs *controllerServer) ControllerPublishVolume(ctx context.Context, req *csi.ControllerPublishVolumeRequest) (*csi.ControllerPublishVolumeResponse, error) {
+	if err := cs.driver.csiDriver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME); err != nil {
+		return nil, err
+	}
+
+	volumeID := req.GetVolumeId()
+	if volumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id is empty")
+	}
+	nodeID := req.GetNodeId()
+	if nodeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "node id is empty")
+	}
+	if err := cs.validateVolumeCapability(req.GetVolumeCapability()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if _, err := cs.volumeByID(volumeID); err != nil {
+		return nil, err
+	}
+
+	cs.published.add(volumeID, nodeID)
+	glog.V(4).Infof("Volume %v is published to node %v", volumeID, nodeID)
+	return &csi.ControllerPublishVolumeResponse{}, nil
+}
+
+func (cs *controllerServer) ControllerUnpublishVolume(ctx context.Context, req *csi.ControllerUnpublishVolumeRequest) (*csi.ControllerUnpublishVolumeResponse, error) {
+	if err := cs.driver.csiDriver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME); err != nil {
+		return nil, err
+	}
+
+	volumeID := req.GetVolumeId()
+	if volumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id is empty")
+	}
+	cs.published.remove(volumeID, req.GetNodeId())
+	glog.V(4).Infof("Volume %v is unpublished from node %q", volumeID, req.GetNodeId())
+	return &csi.ControllerUnpublishVolumeResponse{}, nil
+}
+
+// volumeStatus returns the status of volumeID for ListVolumes, nil unless
+// the driver reports the nodes volumes are published to
+func (cs *controllerServer) volumeStatus(volumeID string) *csi.ListVolumesResponse_VolumeStatus {
+	if cs.driver.csiDriver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES) != nil {
+		return nil
+	}
+	return &csi.ListVolumesResponse_VolumeStatus{PublishedNodeIds: cs.published.get(volumeID)}
+}
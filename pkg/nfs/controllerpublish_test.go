@@ -0,0 +1,206 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var testMountCapability = &csi.VolumeCapability{
+	AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+	AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+}
+
+func TestControllerPublishVolume(t *testing.T) {
+	cs := newTestControllerServer(WithControllerPublish())
+	ctx := context.Background()
+	publish := func(volumeID, nodeID string) error {
+		_, err := cs.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+			VolumeId:         volumeID,
+			NodeId:           nodeID,
+			VolumeCapability: testMountCapability,
+		})
+		return err
+	}
+	unpublish := func(volumeID, nodeID string) {
+		if _, err := cs.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{VolumeId: volumeID, NodeId: nodeID}); err != nil {
+			t.Fatalf("ControllerUnpublishVolume(%v, %v) failed: %v", volumeID, nodeID, err)
+		}
+	}
+	check := func(volumeID string, want ...string) {
+		t.Helper()
+		if got := cs.published.get(volumeID); !reflect.DeepEqual(got, want) {
+			t.Errorf("volume %v is published to %v, want %v", volumeID, got, want)
+		}
+	}
+	pvc1 := "v2:192.0.2.10/export/pvc-1/pvc-1"
+	pvc2 := "v2:192.0.2.10/export/pvc-2/pvc-2"
+
+	for _, nodeID := range []string{"node-b", "node-a", "node-b"} {
+		if err := publish(pvc1, nodeID); err != nil {
+			t.Fatalf("ControllerPublishVolume(%v) failed: %v", nodeID, err)
+		}
+	}
+	if err := publish(pvc2, "node-a"); err != nil {
+		t.Fatal(err)
+	}
+	check(pvc1, "node-a", "node-b")
+	check(pvc2, "node-a")
+
+	unpublish(pvc1, "node-b")
+	unpublish(pvc1, "node-b")
+	check(pvc1, "node-a")
+	// Without a node the volume is unpublished from all nodes
+	unpublish(pvc2, "")
+	check(pvc2)
+	unpublish("v2:192.0.2.10/export/pvc-3/pvc-3", "node-a")
+	if len(cs.published.nodes) != 1 {
+		t.Errorf("published nodes of %d volumes are recorded, want 1", len(cs.published.nodes))
+	}
+
+	for _, test := range []struct {
+		name     string
+		volumeID string
+		nodeID   string
+		cap      *csi.VolumeCapability
+		code     codes.Code
+	}{
+		{"no volume id", "", "node-a", testMountCapability, codes.InvalidArgument},
+		{"no node id", pvc1, "", testMountCapability, codes.InvalidArgument},
+		{"no capability", pvc1, "node-a", nil, codes.InvalidArgument},
+		{"invalid volume id", "nfs/export/..", "node-a", testMountCapability, codes.NotFound},
+	} {
+		_, err := cs.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+			VolumeId:         test.volumeID,
+			NodeId:           test.nodeID,
+			VolumeCapability: test.cap,
+		})
+		if status.Code(err) != test.code {
+			t.Errorf("%s: ControllerPublishVolume() = %v, want %v", test.name, err, test.code)
+		}
+	}
+	check(pvc1, "node-a")
+}
+
+func TestControllerPublishOptIn(t *testing.T) {
+	hasPublish := func(cs *controllerServer) bool {
+		resp, err := cs.ControllerGetCapabilities(context.Background(), &csi.ControllerGetCapabilitiesRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for _, c := range resp.GetCapabilities() {
+			switch c.GetRpc().GetType() {
+			case csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME,
+				csi.ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES:
+				n++
+			}
+		}
+		if n != 0 && n != 2 {
+			t.Errorf("ControllerGetCapabilities() returned %d of the publish capabilities, want both or none", n)
+		}
+		return n > 0
+	}
+
+	cs := newTestControllerServer()
+	if hasPublish(cs) {
+		t.Error("the publish capabilities are advertised by default")
+	}
+	_, err := cs.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+		VolumeId:         "v2:192.0.2.10/export/pvc-1/pvc-1",
+		NodeId:           "node-a",
+		VolumeCapability: testMountCapability,
+	})
+	if err == nil {
+		t.Error("ControllerPublishVolume() succeeded without WithControllerPublish")
+	}
+
+	if !hasPublish(newTestControllerServer(WithControllerPublish())) {
+		t.Error("the publish capabilities are not advertised with WithControllerPublish")
+	}
+	// Capabilities that are already set are not added twice
+	cs = newTestControllerServer(
+		WithControllerCapabilities(csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME),
+		WithControllerPublish())
+	if got := len(cs.driver.controllerCaps); got != 2 {
+		t.Errorf("the driver has %d controller capabilities, want 2", got)
+	}
+}
+
+func TestListVolumesPublishedNodes(t *testing.T) {
+	workDir := t.TempDir()
+	cs := newTestControllerServer(WithWorkingMountDir(workDir), WithControllerPublish())
+	cs.driver.ns = NewNodeServer(cs.driver)
+	cs.driver.shares = []string{"192.0.2.10:/export"}
+	share := &nfsVolume{server: "192.0.2.10", baseDir: "export"}
+
+	// The fake mounter leaves the share at its mount path as it is
+	mountPath := filepath.Join(workDir, exportMountName(exportKey(share)))
+	for _, name := range []string{"pvc-1", "pvc-2"} {
+		path := filepath.Join(mountPath, name)
+		if err := os.MkdirAll(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+		md := &volumeMetadata{VolumeID: "v2:192.0.2.10/export/" + name + "/" + name}
+		if err := cs.writeVolumeMetadata(path, md); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := cs.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+		VolumeId:         "v2:192.0.2.10/export/pvc-1/pvc-1",
+		NodeId:           "node-a",
+		VolumeCapability: testMountCapability,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := cs.ListVolumes(context.Background(), &csi.ListVolumesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string][]string{}
+	for _, entry := range resp.GetEntries() {
+		if entry.GetStatus() == nil {
+			t.Fatalf("ListVolumes() returned %v without status", entry.GetVolume().GetVolumeId())
+		}
+		got[entry.GetVolume().GetVolumeId()] = entry.GetStatus().GetPublishedNodeIds()
+	}
+	want := map[string][]string{
+		"v2:192.0.2.10/export/pvc-1/pvc-1": {"node-a"},
+		"v2:192.0.2.10/export/pvc-2/pvc-2": nil,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ListVolumes() returned published nodes %v, want %v", got, want)
+	}
+
+	getResp, err := cs.ControllerGetVolume(context.Background(), &csi.ControllerGetVolumeRequest{VolumeId: "v2:192.0.2.10/export/pvc-1/pvc-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := getResp.GetStatus().GetPublishedNodeIds(); !reflect.DeepEqual(got, []string{"node-a"}) {
+		t.Errorf("ControllerGetVolume() returned published nodes %v, want [node-a]", got)
+	}
+}
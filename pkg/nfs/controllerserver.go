@@ -0,0 +1,834 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/utils/exec"
+
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/csi-common"
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/nfs/validation"
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/nfs/volume"
+)
+
+type controllerServer struct {
+	*csicommon.DefaultControllerServer
+	driver  *Driver
+	exports *exportQueues
+	mounts  *exportMounts
+	workDir *workingDir
+	// Reports the capacity of exports for GetCapacity
+	capacity CapacityProvider
+	// Cache in front of capacity, nil if disabled
+	capacityCache *capacityCache
+	// Limits volume directories to their capacity, nil for no quota
+	quota QuotaProvider
+	// Background work on shares, within the background windows and IO rate
+	scheduler *shareScheduler
+	// Snapshot archives in progress
+	snapshots *snapshotJobs
+	// Restores and clones in progress
+	operations *volumeOperations
+	// Nodes the volumes are published to
+	published *publishedNodes
+	// Shares of the shareAlias parameter, nil without aliases file
+	shareAliases *shareAliases
+	// Fail fast for nfs servers that keep failing
+	breakers *serverBreakers
+	// Export label of the provisioning metrics
+	exportLabels *exportLabels
+}
+
+// nfsVolume is an internal representation of a volume
+// created by the provisioner.
+type nfsVolume struct {
+	// Volume id
+	id string
+	// Address of the NFS server.
+	// Matches paramServer.
+	server string
+	// Base directory of the NFS server to create volumes under.
+	// Matches paramShare.
+	baseDir string
+	// Subdirectory of the NFS server to create volumes under.
+	// Empty when the volume shares the whole base directory.
+	subDir string
+	// Name of the volume as requested by the CO
+	name string
+	// size of volume
+	size int64
+
+	// Options only used while provisioning the volume.
+	// Create the base directory if it does not exist
+	createShare bool
+	// Mount with resvport or noresvport, nil for the node default
+	resvPort *bool
+	// Further mount options of node mounts
+	mountOptions []string
+	// Check the server before provisioning, nil for the driver default
+	verifyServer *bool
+	// Hand out the share without the leading base directory
+	shareRelativeToExportRoot bool
+	// POSIX ACL entries applied to the subdirectory with setfacl
+	acl string
+	// NFSv4 ACEs applied to the subdirectory with nfs4_setfacl
+	nfs4ACL []string
+	// Group of the subdirectory, nil to keep the default group
+	supplementalGroup *int
+	// Owner of the subdirectory, nil to keep the provisioner's
+	uid *int
+	gid *int
+	// Claim and PersistentVolume of the volume, if known
+	pvcNamespace string
+	pvcName      string
+	pvName       string
+	// Mode of the subdirectory
+	dirMode os.FileMode
+	// Mode set by the StorageClass, nil if not set
+	mountPermissions *os.FileMode
+}
+
+// StorageClass parameters, see the validation package
+const (
+	paramServer            = validation.ParamServer
+	paramShare             = validation.ParamShare
+	paramUseBaseDirAsShare = validation.ParamUseBaseDirAsShare
+	paramCreateShare       = validation.ParamCreateShare
+	paramACL               = validation.ParamACL
+	paramNFS4ACL           = validation.ParamNFS4ACL
+	paramResvPort          = validation.ParamResvPort
+
+	paramShareRelativeToExportRoot = validation.ParamShareRelativeToExportRoot
+	paramSupplementalGroup         = validation.ParamSupplementalGroup
+	paramNamespaceDirs             = validation.ParamNamespaceDirs
+	paramShareAlias                = validation.ParamShareAlias
+)
+
+func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (resp *csi.CreateVolumeResponse, err error) {
+	if err := cs.driver.csiDriver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME); err != nil {
+		return nil, err
+	}
+
+	name := req.GetName()
+	if len(name) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "CreateVolume name must be provided")
+	}
+	if err := cs.validateVolumeCapabilities(req.GetVolumeCapabilities()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	reqCapacity := req.GetCapacityRange().GetRequiredBytes()
+	limitCapacity := req.GetCapacityRange().GetLimitBytes()
+	if reqCapacity < 0 || limitCapacity < 0 {
+		return nil, status.Error(codes.InvalidArgument, "capacity range must not be negative")
+	}
+	if limitCapacity > 0 && reqCapacity > limitCapacity {
+		return nil, status.Errorf(codes.OutOfRange, "required capacity %d exceeds the limit of %d bytes", reqCapacity, limitCapacity)
+	}
+	// Preferred segments first
+	requirements := req.GetAccessibilityRequirements()
+	params, err := cs.shareAliasParameters(req.GetParameters())
+	if err != nil {
+		return nil, err
+	}
+	params, err = cs.topologyParameters(params, append(requirements.GetPreferred(), requirements.GetRequisite()...))
+	if err != nil {
+		return nil, err
+	}
+	nfsVol, err := cs.newNFSVolume(name, reqCapacity, params)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := cs.checkServerAllowed(nfsVol.server); err != nil {
+		return nil, err
+	}
+	if err := cs.verifyServer(ctx, nfsVol); err != nil {
+		return nil, err
+	}
+	defer func(start time.Time) {
+		cs.exportLabels.observe("create", nfsVol, start, err)
+	}(time.Now())
+	var restoreFrom *validation.SnapshotID
+	var cloneFrom *nfsVolume
+	if source := req.GetVolumeContentSource(); source != nil {
+		switch {
+		case source.GetSnapshot() != nil:
+			if restoreFrom, err = cs.checkRestoreSource(ctx, nfsVol, source.GetSnapshot().GetSnapshotId()); err != nil {
+				return nil, err
+			}
+		case source.GetVolume() != nil:
+			if cloneFrom, err = cs.checkCloneSource(ctx, nfsVol, source.GetVolume().GetVolumeId()); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, status.Error(codes.InvalidArgument, "unsupported volume content source")
+		}
+	}
+	if op, ok := cs.operations.get(nfsVol.id); ok {
+		if !op.done {
+			return nil, status.Errorf(codes.Aborted, "volume %v is still being populated from %v", nfsVol.id, op.source)
+		}
+		cs.operations.forget(nfsVol.id)
+		if op.err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to populate volume %v from %v: %v", nfsVol.id, op.source, op.err)
+		}
+		// The populated volume is found like on any other retry
+	}
+	nfsVol.dirMode = cs.dirModeFor(nfsVol, req.GetVolumeCapabilities())
+	if err := cs.applyNamespacePolicy(nfsVol); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to apply namespace policy: %v", err)
+	}
+
+	if nfsVol.createShare {
+		if err = cs.createBaseDir(ctx, nfsVol); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to create base directory %v: %v", nfsVol.baseDir, err.Error())
+		}
+	}
+
+	metadata := &volumeMetadata{
+		ClusterID:     cs.driver.clusterID,
+		Parameters:    req.GetParameters(),
+		CapacityBytes: reqCapacity,
+		ContentSource: contentSourceString(req.GetVolumeContentSource()),
+		PVName:        nfsVol.pvName,
+		PVCNamespace:  nfsVol.pvcNamespace,
+		PVCName:       nfsVol.pvcName,
+		CreationTime:  time.Now().UTC(),
+		DriverVersion: version,
+		VolumeID:      nfsVol.id,
+	}
+
+	// Mount nfs base share so we can create a subdirectory. This also
+	// validates that the share exists on the server.
+	err = cs.exports.run(ctx, nfsVol, func(mountPath string) error {
+		if nfsVol.subDir == "" {
+			glog.V(4).Infof("Volume %v shares the base directory %v:%v", name, nfsVol.server, nfsVol.baseDir)
+			return nil
+		}
+
+		// Create subdirectory under base-dir. It is only accessible by
+		// the provisioner until it is set up, since its final mode may
+		// not allow the provisioner to write the metadata.
+		internalVolumePath := filepath.Join(mountPath, nfsVol.subDir)
+		if cloneFrom != nil {
+			sourcePath, release, err := cs.mountCloneSource(ctx, cloneFrom, nfsVol, mountPath)
+			if err != nil {
+				return err
+			}
+			defer release()
+			if _, err := os.Stat(sourcePath); err != nil {
+				if os.IsNotExist(err) {
+					return status.Errorf(codes.NotFound, "volume %v not found", cloneFrom.id)
+				}
+				return status.Errorf(codes.Internal, "failed to find volume %v: %v", cloneFrom.id, err)
+			}
+		}
+		if err := cs.makeDir(internalVolumePath, 0700); err != nil {
+			if !os.IsExist(err) {
+				return status.Errorf(codes.Internal, "failed to make subdirectory: %v", err.Error())
+			}
+			// A restore or clone of the volume may have been started by a
+			// request that got into the queue after the check above. Its
+			// directory has no metadata until it is complete.
+			if op, ok := cs.operations.get(nfsVol.id); ok && !op.done {
+				return status.Errorf(codes.Aborted, "volume %v is still being populated from %v", nfsVol.id, op.source)
+			}
+			// A retry, unless the name was used for another request
+			if err := checkExistingVolume(internalVolumePath, metadata); err != errIncompleteVolume {
+				return err
+			}
+			// Left behind by a request that was interrupted before the
+			// volume was set up or populated, e.g. by a restart of the
+			// controller
+			glog.Warningf("Removing incomplete volume directory %v", internalVolumePath)
+			if err := os.RemoveAll(internalVolumePath); err != nil {
+				return status.Errorf(codes.Internal, "failed to remove incomplete subdirectory: %v", err.Error())
+			}
+			if err := cs.makeDir(internalVolumePath, 0700); err != nil {
+				return status.Errorf(codes.Internal, "failed to make subdirectory: %v", err.Error())
+			}
+		}
+		if restoreFrom == nil && cloneFrom == nil {
+			return cs.setupVolumeDir(ctx, nfsVol, internalVolumePath, metadata)
+		}
+		cs.operations.start(nfsVol.id, metadata.ContentSource, func() error {
+			return cs.populateVolume(nfsVol, restoreFrom, cloneFrom, metadata)
+		})
+		return status.Errorf(codes.Aborted, "volume %v is being populated from %v", nfsVol.id, metadata.ContentSource)
+	})
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+	cs.invalidateCapacity(nfsVol)
+
+	vol := cs.nfsVolToCSI(nfsVol)
+	vol.ContentSource = req.GetVolumeContentSource()
+	vol.AccessibleTopology = cs.accessibleTopology(nfsVol.server)
+	return &csi.CreateVolumeResponse{Volume: vol}, nil
+}
+
+func (cs *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (resp *csi.DeleteVolumeResponse, err error) {
+	if err := cs.driver.csiDriver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME); err != nil {
+		return nil, err
+	}
+
+	volumeID := req.GetVolumeId()
+	if volumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id is empty")
+	}
+	if _, _, ok := volume.ParseMigratedID(volumeID); ok {
+		// Volumes translated from in-tree NFS volumes were never provisioned
+		// by this driver, so their data must be left alone.
+		glog.V(4).Infof("Volume %v was migrated from an in-tree volume, nothing to delete", volumeID)
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+	nfsVol, err := cs.getNfsVolFromId(volumeID)
+	if err != nil {
+		// An invalid ID should be treated as doesn't exist
+		glog.Warningf("failed to get nfs volume for volume id %v deletion: %v", volumeID, err)
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+	defer func(start time.Time) {
+		cs.exportLabels.observe("delete", nfsVol, start, err)
+	}(time.Now())
+
+	if nfsVol.subDir == "" {
+		glog.V(4).Infof("Volume %v shares the base directory %v:%v, nothing to delete", volumeID, nfsVol.server, nfsVol.baseDir)
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+	if op, ok := cs.operations.get(volumeID); ok {
+		if !op.done {
+			return nil, status.Errorf(codes.Aborted, "volume %v is still being populated from %v", volumeID, op.source)
+		}
+		// The volume is deleted before CreateVolume collected the result
+		cs.operations.forget(volumeID)
+	}
+
+	if cs.driver.verifyPVOnDelete {
+		if err := cs.verifyPVDeletable(nfsVol); err != nil {
+			return nil, err
+		}
+	}
+
+	quarantined, err := cs.quarantineIfForeign(ctx, nfsVol)
+	if err != nil {
+		return nil, err
+	}
+	if quarantined {
+		cs.invalidateCapacity(nfsVol)
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+
+	kept, err := cs.keepIfRequested(ctx, nfsVol)
+	if err != nil {
+		return nil, err
+	}
+	if kept {
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+
+	if err := cs.checkDeleteAllowed(ctx, nfsVol); err != nil {
+		return nil, err
+	}
+
+	if cs.driver.deleteJob != nil {
+		if err := cs.deleteWithJob(nfsVol); err != nil {
+			return nil, err
+		}
+		cs.invalidateCapacity(nfsVol)
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+
+	// Mount nfs base share so we can delete the subdirectory
+	err = cs.exports.run(ctx, nfsVol, func(mountPath string) error {
+		// Delete subdirectory under base-dir
+		internalVolumePath := filepath.Join(mountPath, nfsVol.subDir)
+
+		glog.V(2).Infof("Removing subdirectory at %v", internalVolumePath)
+		return cs.driver.retryPolicy.do(ctx, "deleting "+internalVolumePath, func() error {
+			if err := injectFault(ctx, faultRemoveAll); err != nil {
+				return status.Errorf(codes.Internal, "failed to delete subdirectory: %v", err.Error())
+			}
+			if err := removeAllParallel(internalVolumePath, cs.driver.deleteParallelism, cs.runAsProvisioner); err != nil {
+				return status.Errorf(codes.Internal, "failed to delete subdirectory: %v", err.Error())
+			}
+			if err := cs.removeVolumeRecord(internalVolumePath); err != nil {
+				return status.Errorf(codes.Internal, "failed to delete record of subdirectory: %v", err.Error())
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+	cs.invalidateCapacity(nfsVol)
+
+	return &csi.DeleteVolumeResponse{}, nil
+}
+
+// ValidateVolumeCapabilities confirms the capabilities if the driver
+// supports them and the directory of the volume exists on its share
+func (cs *controllerServer) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
+	volumeID := req.GetVolumeId()
+	if volumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id is empty")
+	}
+	if len(req.GetVolumeCapabilities()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "volume capabilities must be provided")
+	}
+
+	nfsVol, err := cs.volumeByID(volumeID)
+	if err != nil {
+		return nil, err
+	}
+	if err := cs.checkVolumeExists(ctx, nfsVol); err != nil {
+		return nil, err
+	}
+
+	if err := cs.validateVolumeCapabilities(req.GetVolumeCapabilities()); err != nil {
+		return &csi.ValidateVolumeCapabilitiesResponse{Message: err.Error()}, nil
+	}
+	return &csi.ValidateVolumeCapabilitiesResponse{
+		Confirmed: &csi.ValidateVolumeCapabilitiesResponse_Confirmed{
+			VolumeContext:      req.GetVolumeContext(),
+			VolumeCapabilities: req.GetVolumeCapabilities(),
+			Parameters:         req.GetParameters(),
+		},
+	}, nil
+}
+
+// volumeByID returns the volume of a volume id, including the ids of
+// volumes translated from in-tree NFS volumes, or a NotFound error
+func (cs *controllerServer) volumeByID(volumeID string) (*nfsVolume, error) {
+	if server, path, ok := volume.ParseMigratedID(volumeID); ok {
+		if err := validation.ValidateShare(path); err != nil {
+			return nil, status.Errorf(codes.NotFound, "invalid volume id %v: %v", volumeID, err)
+		}
+		return &nfsVolume{id: volumeID, server: server, baseDir: strings.Trim(path, "/")}, nil
+	}
+	nfsVol, err := cs.getNfsVolFromId(volumeID)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "invalid volume id %v: %v", volumeID, err)
+	}
+	return nfsVol, nil
+}
+
+// checkVolumeExists returns a NotFound error unless the share of vol can
+// be mounted and has the directory of the volume
+func (cs *controllerServer) checkVolumeExists(ctx context.Context, vol *nfsVolume) error {
+	// Mounting the share checks that it exists
+	err := cs.exports.run(ctx, vol, func(mountPath string) error {
+		if vol.subDir == "" {
+			return nil
+		}
+		if _, err := os.Stat(filepath.Join(mountPath, vol.subDir)); err != nil {
+			if os.IsNotExist(err) {
+				return status.Errorf(codes.NotFound, "volume %v not found", vol.id)
+			}
+			return status.Errorf(codes.Internal, "failed to find volume %v: %v", vol.id, err)
+		}
+		return nil
+	})
+	return toStatusError(err)
+}
+
+func (cs *controllerServer) validateVolumeCapabilities(caps []*csi.VolumeCapability) error {
+	if len(caps) == 0 {
+		return fmt.Errorf("volume capabilities must be provided")
+	}
+
+	for _, c := range caps {
+		if err := cs.validateVolumeCapability(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cs *controllerServer) validateVolumeCapability(c *csi.VolumeCapability) error {
+	if c == nil {
+		return fmt.Errorf("volume capability must be provided")
+	}
+
+	// Validate access mode
+	accessMode := c.GetAccessMode()
+	if accessMode == nil {
+		return fmt.Errorf("volume capability access mode not set")
+	}
+	supported := false
+	for _, m := range cs.driver.csiDriver.GetVolumeCapabilityAccessModes() {
+		if m.GetMode() == accessMode.GetMode() {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return fmt.Errorf("driver does not support access mode: %v", accessMode.GetMode().String())
+	}
+	switch accessMode.GetMode() {
+	case csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER:
+		// The single node modes of CSI 1.5 come with a capability
+		if err := cs.driver.csiDriver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER); err != nil {
+			return fmt.Errorf("access mode %v requires the SINGLE_NODE_MULTI_WRITER capability", accessMode.GetMode().String())
+		}
+	}
+
+	// Validate access type
+	if c.GetBlock() != nil {
+		return fmt.Errorf("driver does not support block volumes")
+	}
+	if c.GetMount() == nil {
+		return fmt.Errorf("volume capability access type not set")
+	}
+	return nil
+}
+
+// checkServerAllowed returns an InvalidArgument error unless the
+// controller may mount server, see --allowed-servers
+func (cs *controllerServer) checkServerAllowed(server string) error {
+	if cs.driver.allowedServers == nil || cs.driver.allowedServers[server] {
+		return nil
+	}
+	return status.Errorf(codes.InvalidArgument, "nfs server %v is not in the allowed servers of the driver", server)
+}
+
+// Mount nfs server at base-dir
+func (cs *controllerServer) internalMount(ctx context.Context, vol *nfsVolume) error {
+	// Volume and snapshot ids name servers too, so every mount is checked
+	if err := cs.checkServerAllowed(vol.server); err != nil {
+		return err
+	}
+	sharePath := filepath.Join(string(filepath.Separator) + vol.baseDir)
+	targetPath := cs.getInternalMountPath(vol)
+
+	glog.V(4).Infof("internally mounting %v:%v at %v", vol.server, sharePath, targetPath)
+	cs.workDir.acquire(targetPath)
+	// NodePublishVolume retries the mount according to the retry policy.
+	// Shares and base directories mounted for other volumes have no id of
+	// their own, so the request names the export as a static volume.
+	_, err := cs.driver.ns.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+		TargetPath: targetPath,
+		VolumeContext: map[string]string{
+			paramServer: vol.server,
+			paramShare:  sharePath,
+		},
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{},
+			},
+		},
+		VolumeId: volume.NewMigratedID(vol.server, sharePath),
+	})
+	cs.breakers.record(vol.server, err)
+	if err != nil {
+		cs.workDir.release(targetPath)
+	}
+	return err
+}
+
+// Unmount nfs server at base-dir
+func (cs *controllerServer) internalUnmount(ctx context.Context, vol *nfsVolume) error {
+	sharePath := filepath.Join(string(filepath.Separator) + vol.baseDir)
+	targetPath := cs.getInternalMountPath(vol)
+
+	glog.V(4).Infof("internally unmounting %v", targetPath)
+	_, err := cs.driver.ns.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{
+		VolumeId:   volume.NewMigratedID(vol.server, sharePath),
+		TargetPath: targetPath,
+	})
+	if err == nil {
+		cs.workDir.release(targetPath)
+	}
+	return err
+}
+
+// Create the base directory on the nfs server by mounting its parent
+func (cs *controllerServer) createBaseDir(ctx context.Context, vol *nfsVolume) error {
+	baseDir := filepath.Join(string(filepath.Separator), vol.baseDir)
+	parentVol := &nfsVolume{
+		server:  vol.server,
+		baseDir: filepath.Dir(baseDir),
+	}
+	mountPath, release, err := cs.mounts.acquire(ctx, parentVol)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	internalBaseDir := filepath.Join(mountPath, filepath.Base(baseDir))
+	glog.V(4).Infof("Creating base directory %v:%v", vol.server, baseDir)
+	return cs.runAsProvisioner(func() error {
+		return os.MkdirAll(internalBaseDir, cs.driver.defaultDirMode)
+	})
+}
+
+// Create a directory with the given mode, regardless of the umask
+func (cs *controllerServer) makeDir(path string, mode os.FileMode) error {
+	if err := injectFault(context.Background(), faultMkdir); err != nil {
+		return err
+	}
+	return cs.runAsProvisioner(func() error {
+		if err := os.Mkdir(path, mode); err != nil {
+			return err
+		}
+		return os.Chmod(path, mode)
+	})
+}
+
+// Run fn with the configured provisioning uid/gid, so that directory
+// operations work on exports that squash root.
+func (cs *controllerServer) runAsProvisioner(fn func() error) error {
+	return runWithFsCreds(cs.driver.provisioningUID, cs.driver.provisioningGID, fn)
+}
+
+// setupVolumeDir sets the quota of the new volume directory path, writes
+// its metadata and gives it its final mode and ACLs. The directory is
+// removed on errors so that a retry starts from scratch.
+func (cs *controllerServer) setupVolumeDir(ctx context.Context, vol *nfsVolume, path string, metadata *volumeMetadata) error {
+	removeDir := func() {
+		if rmErr := os.RemoveAll(path); rmErr != nil {
+			glog.Warningf("failed to remove subdirectory %v: %v", path, rmErr)
+		}
+	}
+	if err := cs.setQuota(ctx, vol, metadata.CapacityBytes); err != nil {
+		removeDir()
+		return err
+	}
+	if err := cs.writeVolumeMetadata(path, metadata); err != nil {
+		removeDir()
+		return status.Errorf(codes.Internal, "failed to write volume metadata: %v", err.Error())
+	}
+	if cs.driver.tagXattrs {
+		cs.tagVolumeDir(path, metadata)
+	}
+	if err := cs.setMode(vol, path); err != nil {
+		removeDir()
+		return status.Errorf(codes.Internal, "failed to set mode of subdirectory: %v", err.Error())
+	}
+	if err := cs.setACLs(vol, path); err != nil {
+		removeDir()
+		return status.Errorf(codes.Internal, "failed to set acl on subdirectory: %v", err.Error())
+	}
+	return nil
+}
+
+// Give the volume subdirectory its final mode and hand it to the
+// requested supplemental group. The setgid bit makes new files and
+// directories inherit the group, so that all pods running with the group
+// can share the volume.
+func (cs *controllerServer) setMode(vol *nfsVolume, path string) error {
+	return cs.runAsProvisioner(func() error {
+		mode := vol.dirMode
+		if vol.uid != nil || vol.gid != nil {
+			uid, gid := -1, -1
+			if vol.uid != nil {
+				uid = *vol.uid
+			}
+			if vol.gid != nil {
+				gid = *vol.gid
+			}
+			glog.V(4).Infof("Setting owner %d:%d on %v", uid, gid, path)
+			if err := os.Lchown(path, uid, gid); err != nil {
+				return err
+			}
+		}
+		if vol.supplementalGroup != nil {
+			glog.V(4).Infof("Setting group %d on %v", *vol.supplementalGroup, path)
+			if err := os.Lchown(path, -1, *vol.supplementalGroup); err != nil {
+				return err
+			}
+			mode |= os.ModeSetgid
+		}
+		return os.Chmod(path, mode)
+	})
+}
+
+// Apply the requested POSIX and NFSv4 ACLs to the volume subdirectory
+func (cs *controllerServer) setACLs(vol *nfsVolume, path string) error {
+	executor := exec.New()
+	if vol.acl != "" {
+		glog.V(4).Infof("Setting acl %q on %v", vol.acl, path)
+		if out, err := executor.Command("setfacl", "-m", vol.acl, path).CombinedOutput(); err != nil {
+			return fmt.Errorf("setfacl failed: %v, output: %s", err, out)
+		}
+	}
+	for _, ace := range vol.nfs4ACL {
+		glog.V(4).Infof("Adding nfs4 ace %q on %v", ace, path)
+		if out, err := executor.Command("nfs4_setfacl", "-a", ace, path).CombinedOutput(); err != nil {
+			return fmt.Errorf("nfs4_setfacl failed: %v, output: %s", err, out)
+		}
+	}
+	return nil
+}
+
+// Convert VolumeCreate parameters to an nfsVolume
+func (cs *controllerServer) newNFSVolume(name string, size int64, params map[string]string) (*nfsVolume, error) {
+	var errs []error
+	p, err := validation.ParseParameters(params)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if err := validation.ValidateVolumeName(name); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return nil, utilerrors.Flatten(utilerrors.NewAggregate(errs))
+	}
+
+	vol := &nfsVolume{
+		server:       p.Server,
+		baseDir:      p.Share,
+		name:         name,
+		size:         size,
+		createShare:  p.CreateShare,
+		acl:          p.ACL,
+		nfs4ACL:      p.NFS4ACL,
+		resvPort:     p.ResvPort,
+		mountOptions: p.MountOptions,
+		verifyServer: p.VerifyServer,
+
+		shareRelativeToExportRoot: p.ShareRelativeToExportRoot,
+		supplementalGroup:         p.SupplementalGroup,
+		uid:                       p.UID,
+		gid:                       p.GID,
+		pvcNamespace:              p.PVCNamespace,
+		pvcName:                   p.PVCName,
+		pvName:                    p.PVName,
+	}
+	if vol.pvName == "" {
+		// The external-provisioner names volumes after their PV
+		vol.pvName = name
+	}
+	if p.MountPermissions != nil {
+		mode := toFileMode(*p.MountPermissions)
+		vol.mountPermissions = &mode
+	}
+	if p.NamespaceDirs {
+		// The directory of the namespace is the base share of the volume
+		// and is created with its first volume
+		if !validation.IsSafePathElement(p.PVCNamespace) {
+			return nil, fmt.Errorf("%v needs the namespace of the claim, the external-provisioner must run with --extra-create-metadata", paramNamespaceDirs)
+		}
+		vol.baseDir = filepath.Join(p.Share, p.PVCNamespace)
+		vol.createShare = true
+	}
+	if !p.UseBaseDirAsShare {
+		vol.subDir = name
+		if p.SubDir != "" {
+			if vol.subDir, err = p.ExpandSubDir(name); err != nil {
+				return nil, err
+			}
+		}
+		if cs.driver.clusterIDInSubDir {
+			vol.subDir = fmt.Sprintf("%s-%s", cs.driver.clusterID, vol.subDir)
+		}
+		suffix := cs.driver.uuidSuffix
+		if p.UUIDSuffix != nil {
+			suffix = *p.UUIDSuffix
+		}
+		if suffix {
+			vol.subDir = fmt.Sprintf("%s-%s", vol.subDir, subDirSuffix(name))
+		}
+	}
+	vol.id = cs.getVolumeIdFromNfsVol(vol)
+
+	return vol, nil
+}
+
+// subDirSuffix returns the short id that the uuidSuffix parameter appends
+// to the subdirectory of volume name. It is derived from the name, which
+// the external-provisioner builds from the UID of the claim, so that
+// retries of CreateVolume get the same subdirectory while a claim that is
+// recreated with the same name does not reuse the retained or archived
+// directory of its predecessor.
+func subDirSuffix(name string) string {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+// Get working directory for CreateVolume and DeleteVolume
+func (cs *controllerServer) getInternalMountPath(vol *nfsVolume) string {
+	return filepath.Join(cs.driver.workingMountDir, vol.name)
+}
+
+// Get user-visible share path for the volume. A volume sharing the whole
+// base directory gets the share exactly as it was specified. If the share
+// is relative to the export root, the base directory is left out.
+func (cs *controllerServer) getVolumeSharePath(vol *nfsVolume) string {
+	if vol.shareRelativeToExportRoot {
+		return filepath.Join(string(filepath.Separator), vol.subDir)
+	}
+	if vol.subDir == "" {
+		return vol.baseDir
+	}
+	return filepath.Join(string(filepath.Separator), vol.baseDir, vol.subDir)
+}
+
+// Convert into nfsVolume into a csi.Volume
+func (cs *controllerServer) nfsVolToCSI(vol *nfsVolume) *csi.Volume {
+	volumeContext := &volume.Context{
+		Server:       vol.server,
+		Share:        cs.getVolumeSharePath(vol),
+		MountOptions: vol.mountOptions,
+		ResvPort:     vol.resvPort,
+	}
+	return &csi.Volume{
+		CapacityBytes: vol.size,
+		VolumeId:      vol.id,
+		VolumeContext: volumeContext.Map(),
+	}
+}
+
+// Given a nfsVolume, return a CSI volume id
+func (cs *controllerServer) getVolumeIdFromNfsVol(vol *nfsVolume) string {
+	if !cs.driver.legacyVolumeIDs {
+		return volume.NewV2ID(vol.server, vol.baseDir, vol.subDir, vol.name)
+	}
+	if vol.subDir == "" {
+		return volume.NewSharedID(vol.server, vol.baseDir, vol.name)
+	}
+	return volume.NewID(vol.server, vol.baseDir, vol.subDir)
+}
+
+// Given a CSI volume id, return a nfsVolume
+func (cs *controllerServer) getNfsVolFromId(id string) (*nfsVolume, error) {
+	v, err := validation.ParseVolumeID(id)
+	if err != nil {
+		return nil, err
+	}
+	return &nfsVolume{
+		id:      id,
+		server:  v.Server,
+		baseDir: v.BaseDir,
+		subDir:  v.SubDir,
+		name:    v.Name,
+	}, nil
+}
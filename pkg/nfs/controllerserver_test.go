@@ -0,0 +1,157 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"k8s.io/kubernetes/pkg/util/mount"
+
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/nfs/validation"
+)
+
+func newTestControllerServer(options ...Option) *controllerServer {
+	return NewControllerServer(New(append([]Option{WithNodeID("test"), WithMounter(&mount.FakeMounter{})}, options...)...))
+}
+
+func TestNewNFSVolumeDefaults(t *testing.T) {
+	cs := newTestControllerServer()
+	vol, err := cs.newNFSVolume("pvc-1", 1024, map[string]string{
+		paramServer: "NFS.example.com",
+		paramShare:  "/export",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &nfsVolume{
+		id:      "v2:nfs.example.com/export/pvc-1/pvc-1",
+		server:  "nfs.example.com",
+		baseDir: "/export",
+		subDir:  "pvc-1",
+		name:    "pvc-1",
+		size:    1024,
+		pvName:  "pvc-1",
+	}
+	if !reflect.DeepEqual(vol, want) {
+		t.Errorf("newNFSVolume() = %+v, want %+v", vol, want)
+	}
+}
+
+func TestValidateVolumeCapabilitySingleNodeModes(t *testing.T) {
+	withoutCapability := newTestControllerServer(WithControllerCapabilities(csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME))
+	for _, mode := range []csi.VolumeCapability_AccessMode_Mode{
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER,
+	} {
+		c := &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode},
+		}
+		if err := newTestControllerServer().validateVolumeCapability(c); err != nil {
+			t.Errorf("validateVolumeCapability(%v) failed: %v", mode, err)
+		}
+		if err := withoutCapability.validateVolumeCapability(c); err == nil {
+			t.Errorf("validateVolumeCapability(%v) without SINGLE_NODE_MULTI_WRITER succeeded", mode)
+		}
+	}
+}
+
+func TestDirModeForSingleNodeModes(t *testing.T) {
+	cs := newTestControllerServer()
+	gid := 2000
+	for _, test := range []struct {
+		mode csi.VolumeCapability_AccessMode_Mode
+		want os.FileMode
+	}{
+		{csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER, cs.driver.defaultDirMode},
+		{csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER, groupSharedDirMode},
+	} {
+		caps := []*csi.VolumeCapability{{AccessMode: &csi.VolumeCapability_AccessMode{Mode: test.mode}}}
+		if got := cs.dirModeFor(&nfsVolume{supplementalGroup: &gid}, caps); got != test.want {
+			t.Errorf("dirModeFor(%v) = %v, want %v", test.mode, got, test.want)
+		}
+	}
+}
+
+// FuzzGetNfsVolFromId checks that volume ids never panic the controller
+// and never name directories outside of their base share
+func FuzzGetNfsVolFromId(f *testing.F) {
+	f.Add("nfs.example.com/export/pvc-1")
+	f.Add("nfs.example.com/exports/team1//pvc-1")
+	f.Add("v2:nfs.example.com/export/pvc-1/pvc-1")
+	f.Add("nfs.example.com:/export/pvc-1")
+	f.Add("nfs/export/..")
+	f.Add("v2:nfs/%2E%2E%2F%2E%2E/pvc-1/pvc-1")
+	cs := newTestControllerServer()
+	f.Fuzz(func(t *testing.T, id string) {
+		vol, err := cs.getNfsVolFromId(id)
+		if err != nil {
+			return
+		}
+		if vol.server == "" || !validation.IsSafeRelativePath(vol.baseDir) ||
+			(vol.subDir != "" && !validation.IsSafePathElement(vol.subDir)) {
+			t.Fatalf("getNfsVolFromId(%q) accepted %+v", id, vol)
+		}
+	})
+}
+
+// FuzzNewNFSVolume checks that StorageClass parameters never panic the
+// controller, and that the volumes it accepts stay within their base
+// share and get ids that parse back to the same directories
+func FuzzNewNFSVolume(f *testing.F) {
+	f.Add("pvc-1", "nfs.example.com", "/export", "", "", false, false)
+	f.Add("pvc-1", "[fd00::1]:2049", "/exports/team1", "${pvc.namespace}-${pvc.name}", "team-a", false, true)
+	f.Add("pvc-1", "nfs", "/export", "", "../team-a", true, false)
+	f.Add("pvc-1", "nfs", "/export/../etc", "..", "", false, false)
+	servers := map[bool]*controllerServer{
+		false: newTestControllerServer(),
+		true:  newTestControllerServer(),
+	}
+	servers[true].driver.legacyVolumeIDs = true
+	f.Fuzz(func(t *testing.T, name, server, share, subDir, namespace string, namespaceDirs, legacyIDs bool) {
+		cs := servers[legacyIDs]
+		params := map[string]string{
+			paramServer:                  server,
+			paramShare:                   share,
+			validation.ParamPVCNamespace: namespace,
+		}
+		if subDir != "" {
+			params[validation.ParamSubDir] = subDir
+		}
+		if namespaceDirs {
+			params[paramNamespaceDirs] = "true"
+		}
+		vol, err := cs.newNFSVolume(name, 0, params)
+		if err != nil {
+			return
+		}
+		if !validation.IsSafeRelativePath(vol.baseDir) || (vol.subDir != "" && !validation.IsSafePathElement(vol.subDir)) {
+			t.Fatalf("newNFSVolume(%q, %v) accepted base directory %q and subdirectory %q", name, params, vol.baseDir, vol.subDir)
+		}
+		parsed, err := cs.getNfsVolFromId(vol.id)
+		if err != nil {
+			t.Fatalf("id %q of volume %+v does not parse: %v", vol.id, vol, err)
+		}
+		if parsed.server != vol.server || parsed.baseDir != strings.Trim(vol.baseDir, "/") || parsed.subDir != vol.subDir {
+			t.Fatalf("id %q of volume %+v parses to %+v", vol.id, vol, parsed)
+		}
+	})
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Annotation of a persistent volume that allows deleting the volume's
+// data with --require-empty-on-delete
+const allowDeleteDataAnnotation = "nfs.csi.k8s.io/allow-delete-data"
+
+// checkDeleteAllowed refuses to delete the data of vol if it is not empty
+// while the driver requires volumes to be empty on delete, unless the
+// persistent volume of vol is annotated to allow it. This protects data
+// against accidental reclaim policy mistakes.
+func (cs *controllerServer) checkDeleteAllowed(ctx context.Context, vol *nfsVolume) error {
+	if !cs.driver.requireEmptyOnDelete {
+		return nil
+	}
+
+	if cs.driver.kubeClient != nil {
+		pv, err := cs.findPV(vol)
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to look up persistent volume of volume %v: %v", vol.id, err)
+		}
+		if pv != nil && pv.Annotations[allowDeleteDataAnnotation] == "true" {
+			glog.V(4).Infof("Persistent volume %s allows deleting the data of volume %v", pv.Name, vol.id)
+			return nil
+		}
+	}
+
+	err := cs.exports.run(ctx, vol, func(mountPath string) error {
+		empty, err := isEmptyDir(filepath.Join(mountPath, vol.subDir))
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to check whether volume %v is empty: %v", vol.id, err)
+		}
+		if !empty {
+			return status.Errorf(codes.FailedPrecondition, "refusing to delete volume %v: it contains data; annotate its persistent volume with %s=true to allow deleting it", vol.id, allowDeleteDataAnnotation)
+		}
+		return nil
+	})
+	if err != nil {
+		return toStatusError(err)
+	}
+	return nil
+}
+
+// isEmptyDir reports whether dir has no entries besides the volume
+// metadata. A missing dir is empty.
+func isEmptyDir(dir string) (bool, error) {
+	f, err := os.Open(dir)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	for {
+		names, err := f.Readdirnames(1)
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if names[0] != volumeMetadataFile {
+			return false, nil
+		}
+	}
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+
+	"github.com/golang/glog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// Annotation of delete jobs recording the volume they delete
+	deleteJobVolumeAnnotation = "nfs.csi.k8s.io/volume-id"
+	// Mount path of the base share in delete job pods
+	deleteJobSharePath = "/share"
+	// Retries of a failing delete job before it is reported as failed
+	deleteJobBackoffLimit = 3
+)
+
+// DeleteJobOptions configures Kubernetes Jobs that remove the data of
+// deleted volumes on behalf of the controller
+type DeleteJobOptions struct {
+	// Namespace the jobs are created in
+	Namespace string
+	// Image of the job, must provide rm
+	Image string
+	// Node selector of the job pods
+	NodeSelector map[string]string
+	// Resources of the job container
+	Resources v1.ResourceRequirements
+}
+
+// deleteWithJob removes the subdirectory of vol in a Kubernetes Job. The
+// job is created on the first call; DeleteVolume returns Aborted until it
+// finishes so that the sidecar retries, and succeeds once it is done.
+func (cs *controllerServer) deleteWithJob(vol *nfsVolume) error {
+	opts := cs.driver.deleteJob
+	jobs := cs.driver.kubeClient.BatchV1().Jobs(opts.Namespace)
+	name := deleteJobName(vol.id)
+
+	job, err := jobs.Get(name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		glog.V(2).Infof("Creating job %s/%s to delete volume %v", opts.Namespace, name, vol.id)
+		_, err = jobs.Create(cs.newDeleteJob(name, vol))
+		if err != nil && !apierrors.IsAlreadyExists(err) {
+			return status.Errorf(codes.Internal, "failed to create delete job %s/%s: %v", opts.Namespace, name, err)
+		}
+		return status.Errorf(codes.Aborted, "deletion of volume %v is in progress in job %s/%s", vol.id, opts.Namespace, name)
+	}
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to get delete job %s/%s: %v", opts.Namespace, name, err)
+	}
+
+	if job.Status.Succeeded > 0 {
+		glog.V(2).Infof("Job %s/%s deleted volume %v", opts.Namespace, name, vol.id)
+		if err := cs.removeDeleteJob(name); err != nil {
+			glog.Warningf("failed to remove finished delete job %s/%s: %v", opts.Namespace, name, err)
+		}
+		return nil
+	}
+	for _, c := range job.Status.Conditions {
+		if c.Type == batchv1.JobFailed && c.Status == v1.ConditionTrue {
+			// Remove the job so that the next retry starts a new one
+			if err := cs.removeDeleteJob(name); err != nil {
+				glog.Warningf("failed to remove failed delete job %s/%s: %v", opts.Namespace, name, err)
+			}
+			return status.Errorf(codes.Internal, "delete job %s/%s failed: %s", opts.Namespace, name, c.Message)
+		}
+	}
+	return status.Errorf(codes.Aborted, "deletion of volume %v is in progress in job %s/%s", vol.id, opts.Namespace, name)
+}
+
+func (cs *controllerServer) removeDeleteJob(name string) error {
+	propagation := metav1.DeletePropagationBackground
+	err := cs.driver.kubeClient.BatchV1().Jobs(cs.driver.deleteJob.Namespace).Delete(name, &metav1.DeleteOptions{
+		PropagationPolicy: &propagation,
+	})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (cs *controllerServer) newDeleteJob(name string, vol *nfsVolume) *batchv1.Job {
+	opts := cs.driver.deleteJob
+	backoffLimit := int32(deleteJobBackoffLimit)
+
+	var securityContext *v1.PodSecurityContext
+	if cs.driver.provisioningUID >= 0 || cs.driver.provisioningGID >= 0 {
+		securityContext = &v1.PodSecurityContext{}
+		if cs.driver.provisioningUID >= 0 {
+			uid := int64(cs.driver.provisioningUID)
+			securityContext.RunAsUser = &uid
+		}
+		if cs.driver.provisioningGID >= 0 {
+			gid := int64(cs.driver.provisioningGID)
+			securityContext.RunAsGroup = &gid
+		}
+	}
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: opts.Namespace,
+			Annotations: map[string]string{
+				deleteJobVolumeAnnotation: vol.id,
+			},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: v1.PodTemplateSpec{
+				Spec: v1.PodSpec{
+					RestartPolicy:   v1.RestartPolicyNever,
+					NodeSelector:    opts.NodeSelector,
+					SecurityContext: securityContext,
+					Containers: []v1.Container{
+						{
+							Name:      "delete",
+							Image:     opts.Image,
+							Command:   []string{"rm", "-rf", "--", filepath.Join(deleteJobSharePath, vol.subDir), volumeRecordPath(filepath.Join(deleteJobSharePath, vol.subDir))},
+							Resources: opts.Resources,
+							VolumeMounts: []v1.VolumeMount{
+								{
+									Name:      "share",
+									MountPath: deleteJobSharePath,
+								},
+							},
+						},
+					},
+					Volumes: []v1.Volume{
+						{
+							Name: "share",
+							VolumeSource: v1.VolumeSource{
+								NFS: &v1.NFSVolumeSource{
+									Server: vol.server,
+									Path:   filepath.Join(string(filepath.Separator), vol.baseDir),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// deleteJobName returns a valid and stable job name for a volume id
+func deleteJobName(volumeID string) string {
+	h := fnv.New64a()
+	h.Write([]byte(volumeID))
+	return fmt.Sprintf("csi-nfs-delete-%x", h.Sum64())
+}
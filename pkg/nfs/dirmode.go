@@ -0,0 +1,67 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"os"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+// Modes of new subdirectories derived from the requested access modes
+const (
+	// Nobody writes to volumes that are only requested read-only
+	readOnlyDirMode os.FileMode = 0555
+	// Volumes shared read-write by the pods of one supplemental group
+	groupSharedDirMode os.FileMode = 0770 | os.ModeSetgid
+)
+
+// dirModeFor returns the mode of the subdirectory of vol when it is
+// requested with caps. The mountPermissions parameter of the StorageClass
+// wins. Unless modes are derived from the access modes, every other
+// subdirectory gets the default mode of the driver.
+func (cs *controllerServer) dirModeFor(vol *nfsVolume, caps []*csi.VolumeCapability) os.FileMode {
+	if vol.mountPermissions != nil {
+		return *vol.mountPermissions
+	}
+	mode := cs.driver.defaultDirMode
+	if !cs.driver.dirModeFromAccessModes || len(caps) == 0 {
+		return mode
+	}
+
+	readOnly := true
+	multiWriter := false
+	for _, c := range caps {
+		switch c.GetAccessMode().GetMode() {
+		case csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+			csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY:
+		case csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+			csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER:
+			readOnly = false
+			multiWriter = true
+		default:
+			readOnly = false
+		}
+	}
+	switch {
+	case readOnly:
+		return readOnlyDirMode
+	case multiWriter && vol.supplementalGroup != nil:
+		return groupSharedDirMode
+	}
+	return mode
+}
@@ -17,20 +17,169 @@ limitations under the License.
 package nfs
 
 import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/kubernetes/pkg/util/mount"
 
-	csicommon "github.com/kubernetes-csi/drivers/pkg/csi-common"
+	csicommon "github.com/kubernetes-csi/csi-driver-nfs/pkg/csi-common"
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/nfs/volume"
 )
 
-type driver struct {
+// Driver is the NFS CSI driver. It serves the identity, controller and
+// node services on one gRPC endpoint.
+type Driver struct {
 	csiDriver *csicommon.CSIDriver
+	nodeID    string
 	endpoint  string
+	// Listener to serve on instead of endpoint
+	listener net.Listener
+	// Interceptors called after the built-in logging interceptor
+	interceptors []grpc.UnaryServerInterceptor
+	// Supported access modes, controller and node capabilities
+	accessModes    []csi.VolumeCapability_AccessMode_Mode
+	controllerCaps []csi.ControllerServiceCapability_RPC_Type
+	nodeCaps       []csi.NodeServiceCapability_RPC_Type
+
+	// Working directory for the provisioner to temporarily mount nfs shares at
+	workingMountDir string
+	// Mode of directories created by the provisioner
+	defaultDirMode os.FileMode
+	// Derive the mode of new subdirectories from the access modes
+	dirModeFromAccessModes bool
+	// Filesystem uid and gid used by the provisioner on the nfs share,
+	// negative to keep the credentials of the driver process
+	provisioningUID int
+	provisioningGID int
+	// Number of concurrent removals when deleting a volume
+	deleteParallelism int
+	// Whether mounts use a reserved source port unless the volume says
+	// otherwise, nil to leave it to the mount helper
+	defaultResvPort *bool
+	// Mount through a local TCP proxy, for development clusters
+	tcpProxy bool
+	// Address the TCP proxy connects to instead of the nfs server
+	tcpProxyUpstream string
+	// Gzip compress all gRPC responses
+	compressResponses bool
+	// Address to serve metrics on, empty to disable
+	metricsAddress string
+	// Age after which leftover empty directories in workingMountDir
+	// are removed, 0 to keep them
+	workingDirPruneAge time.Duration
+	// Resolves nfs server hostnames, nil to leave it to the mount helper
+	resolver *resolver
+	// Reports capacity for GetCapacity, nil to use statfs
+	capacityProvider CapacityProvider
+	// Limits volume directories to their capacity, nil for no quota
+	quotaProvider QuotaProvider
+
+	mounter mount.Interface
+
+	kubeClient kubernetes.Interface
+	// Delete volume data in Kubernetes Jobs, nil to delete in the driver
+	deleteJob *DeleteJobOptions
+	// Check the persistent volume before deleting volume data
+	verifyPVOnDelete bool
+	// Number of snapshot archives written at a time, 0 for no limit
+	maxConcurrentSnapshots int
+	// Directory under the base share that holds snapshot archives
+	snapshotsDir string
+	// Shares, as server:/path, that ListVolumes and ListSnapshots list
+	shares []string
+	// Local directory for scratch overlays, empty to disable them
+	scratchDir string
+	// Number of targets a volume may be published to on the node,
+	// 0 for no limit
+	maxPublishesPerVolume int
+	// Sysctls and module parameters set before the first mount
+	hostPrep *hostPreparer
+	// Only delete volumes with data if their persistent volume allows it
+	requireEmptyOnDelete bool
+	// Consecutive mount failures after which requests for a server fail
+	// fast for serverFailureCooldown, 0 to keep trying
+	serverFailureThreshold int
+	serverFailureCooldown  time.Duration
+	// Check the nfs servers of mounted volumes and warmUpServers at start
+	warmUp        bool
+	warmUpServers []string
+	retryPolicy   RetryPolicy
+	// Report the nfs client statistics of published volumes
+	mountStatsMetrics bool
+	nfs4Roots         nfs4Roots
+	// Mount options added to every mount of a server
+	serverMountOptions map[string][]string
+	// Mounts per second and burst per nfs server, 0 for no limit
+	mountRatePerServer  float64
+	mountBurstPerServer int
+	// ConfigMap, as namespace/name, mapping namespaces to the default
+	// owner and mode of their volumes
+	namespacePolicy string
+	// Publish volumes by bind mounting them from this autofs root
+	autofsRoot string
+	// Export mounted by Probe, as server:/path, and how often
+	probeCanary         string
+	probeCanaryInterval time.Duration
+	// How long GetCapacity results are cached, 0 to disable the cache
+	capacityCacheTTL time.Duration
+	// Cluster stamped into the metadata of provisioned volumes, and
+	// prefixed to their subdirectories if clusterIDInSubDir is set
+	clusterID         string
+	clusterIDInSubDir bool
+	// Directory under the base share for volumes of other clusters
+	quarantineDir string
+	// Name archived volumes archived-{subDir} without a timestamp
+	legacyArchiveNames bool
+	// Give new volumes version 1 ids
+	legacyVolumeIDs bool
+	// Append subDirSuffix to new subdirectories unless the StorageClass
+	// says otherwise
+	uuidSuffix bool
+	// Describe volume directories with extended attributes
+	tagXattrs bool
+	// Check servers in CreateVolume unless the StorageClass says otherwise
+	verifyServer bool
+	// Advertise PUBLISH_UNPUBLISH_VOLUME and record the published nodes
+	controllerPublish bool
+	// YAML file mapping share aliases to server:/path
+	shareAliasesFile string
+	// Daily windows for background work on shares, empty for any time,
+	// and its bytes per second per share, 0 for no limit
+	backgroundWindows []TimeWindow
+	backgroundIORate  int64
+	// Exports with a label of their own in the provisioning metrics
+	maxExportMetricLabels int
+	// Exports, as server:/path, to run the self check on at start
+	selfCheckExports []string
+	// Normalized nfs servers the controller may mount, nil for any
+	allowedServers map[string]bool
+	// Topology segment that topologyServers maps to normalized servers,
+	// and the value of the segment of this node, if known
+	topologyKey     string
+	topologyServers map[string]string
+	nodeTopology    string
 
 	//ids *identityServer
 	ns    *nodeServer
+	cs    *controllerServer
 	cap   []*csi.VolumeCapability_AccessMode
 	cscap []*csi.ControllerServiceCapability
+
+	// Set while the driver is started
+	mutex         sync.Mutex
+	server        *grpcServer
+	metricsServer *http.Server
+	stopCh        chan struct{}
+	mountStats    *mountStatsCollector
 }
 
 const (
@@ -41,38 +190,434 @@ var (
 	version = "1.0.0-rc2"
 )
 
-func NewDriver(nodeID, endpoint string) *driver {
-	glog.Infof("Driver: %v version: %v", driverName, version)
+// DriverOptions defines driver parameters specified in driver deployment
+type DriverOptions struct {
+	NodeID          string
+	Endpoint        string
+	WorkingMountDir string
+	DefaultDirMode  os.FileMode
+	// DirModeFromAccessModes derives the mode of new subdirectories from
+	// the requested access modes instead of using DefaultDirMode for all
+	DirModeFromAccessModes bool
+	ProvisioningUID        int
+	ProvisioningGID        int
+	// DeleteParallelism bounds the number of concurrent removals
+	// when deleting a volume, 1 removes entries one by one.
+	DeleteParallelism int
+	DefaultResvPort   *bool
+	// TCPProxy mounts shares through a local TCP proxy to
+	// TCPProxyUpstream, or the nfs server if that is empty.
+	TCPProxy         bool
+	TCPProxyUpstream string
+	// CompressResponses gzip compresses all gRPC responses. The CSI
+	// clients must be able to decompress them.
+	CompressResponses bool
+	// MetricsAddress is the address to serve prometheus metrics on,
+	// empty to disable them.
+	MetricsAddress string
+	// WorkingDirPruneAge is the age after which leftover empty
+	// directories in WorkingMountDir are removed, 0 to keep them.
+	WorkingDirPruneAge time.Duration
+	// DNSCacheTTL is how long resolved nfs server addresses are cached.
+	// 0 leaves resolving hostnames to the mount helper.
+	DNSCacheTTL time.Duration
+	// CapacityProvider reports the capacity of exports. Defaults to
+	// mounting the export and calling statfs.
+	CapacityProvider CapacityProvider
+	// QuotaProvider limits new and expanded volumes to their capacity.
+	// Volumes have no quota without it.
+	QuotaProvider QuotaProvider
+	// Mounter used by the node server, see NewMounter. Tests may pass
+	// a mount.FakeMounter, defaults to the kernel mounter.
+	Mounter mount.Interface
+	// KubeClient is required by the features that use the Kubernetes API
+	KubeClient kubernetes.Interface
+	// DeleteJob enables deleting volume data in Kubernetes Jobs
+	DeleteJob *DeleteJobOptions
+	// VerifyPVOnDelete makes DeleteVolume check that the persistent
+	// volume is released and has the Delete reclaim policy. Requires
+	// KubeClient.
+	VerifyPVOnDelete bool
+	// MaxConcurrentSnapshots bounds the number of snapshot archives
+	// written at a time, 0 for no limit.
+	MaxConcurrentSnapshots int
+	// SnapshotsDir is the directory under the base share that snapshot
+	// archives are written to, ".snapshots" if empty.
+	SnapshotsDir string
+	// Shares are the base shares, as server:/path, whose volumes
+	// ListVolumes returns, and whose snapshots ListSnapshots returns when
+	// it is not asked for a particular snapshot or source volume.
+	Shares []string
+	// ScratchDir is the local directory holding the writable layers of
+	// scratch overlays, empty to disable them.
+	ScratchDir string
+	// MaxPublishesPerVolume bounds the number of target paths one volume
+	// may be published to on a node, 0 for no limit.
+	MaxPublishesPerVolume int
+	// Sysctls maps sysctl names such as "sunrpc.tcp_slot_table_entries"
+	// to values that are set before the first mount on a node.
+	Sysctls map[string]string
+	// ModuleParams maps kernel module parameters such as
+	// "nfs.callback_tcpport" to values that are set before the first
+	// mount on a node.
+	ModuleParams map[string]string
+	// RequireEmptyOnDelete makes DeleteVolume refuse to delete volumes
+	// that contain data, unless their persistent volume is annotated with
+	// nfs.csi.k8s.io/allow-delete-data=true. The annotation is only
+	// checked if KubeClient is set.
+	RequireEmptyOnDelete bool
+	// ServerFailureThreshold is the number of consecutive failures to
+	// mount an nfs server after which the controller fails requests for
+	// that server for ServerFailureCooldown. 0 disables it.
+	ServerFailureThreshold int
+	ServerFailureCooldown  time.Duration
+	// WarmUp makes the node plugin resolve and connect to the nfs servers
+	// of the volumes mounted on the node and of WarmUpServers when it
+	// starts, in the background.
+	WarmUp        bool
+	WarmUpServers []string
+	// RetryPolicy of mounts and deletions
+	RetryPolicy RetryPolicy
+	// MountStatsMetrics adds metrics from the nfs client statistics of
+	// every published volume to the metrics served at MetricsAddress
+	MountStatsMetrics bool
+	// NFS4Roots maps nfs servers to the directory they export as NFSv4
+	// pseudo root (fsid=0). Shares below it are mounted relative to it
+	// with NFSv4, by the controller as well as on nodes.
+	NFS4Roots map[string]string
+	// ServerMountOptions maps nfs servers to mount options that every
+	// mount of the server gets, by the controller as well as on nodes,
+	// unless the volume sets the option itself. Unlike the mountOptions
+	// parameter they are not restricted, e.g. for user space servers
+	// that need mountport.
+	ServerMountOptions map[string][]string
+	// MountRatePerServer limits the mounts of a node, and of the
+	// controller, to this many per second and nfs server, with bursts of
+	// MountBurstPerServer. 0 disables the limit.
+	MountRatePerServer  float64
+	MountBurstPerServer int
+	// NamespacePolicy is a ConfigMap, as namespace/name, that
+	// maps namespaces to the default owner and mode of the subdirectories
+	// of their volumes, e.g. "uid=1000,gid=1000,mode=0770". It requires
+	// KubeClient and the claim namespace in the CreateVolume parameters.
+	NamespacePolicy string
+	// AutofsRoot makes the node plugin publish volumes by bind mounting
+	// {AutofsRoot}/{server}/{share}, as laid out by the autofs -hosts map,
+	// instead of mounting the shares itself.
+	AutofsRoot string
+	// ProbeCanary is an export, as server:/path, that Probe mounts and
+	// unmounts at most every ProbeCanaryInterval. Probe reports the
+	// driver as not ready while this fails.
+	ProbeCanary         string
+	ProbeCanaryInterval time.Duration
+	// CapacityCacheTTL is how long the capacity of a share is cached for
+	// GetCapacity. Creating or deleting a volume drops the cached value.
+	CapacityCacheTTL time.Duration
+	// ClusterID is recorded in the metadata of every provisioned volume.
+	// DeleteVolume moves volumes of other clusters into QuarantineDir
+	// under their base share instead of deleting them.
+	ClusterID     string
+	QuarantineDir string
+	// LegacyArchiveNames names the directories of volumes archived with
+	// onDelete=archive "archived-{subdirectory}" like the
+	// nfs-client-provisioner, instead of adding the time of deletion.
+	LegacyArchiveNames bool
+	// LegacyVolumeIDs gives new volumes version 1 ids instead of version
+	// 2 ids, e.g. while older versions of the driver, which cannot parse
+	// version 2 ids, may still run.
+	LegacyVolumeIDs bool
+	// UUIDSuffix appends a short id derived from the volume name to the
+	// subdirectories of new volumes, for StorageClasses without the
+	// uuidSuffix parameter.
+	UUIDSuffix bool
+	// TagXattrs sets user.csi.* extended attributes with the names of the
+	// PersistentVolume and claim on new volume directories, for servers
+	// that support extended attributes (NFSv4.2).
+	TagXattrs bool
+	// VerifyServer makes CreateVolume check that the server is reachable
+	// and the share can be mounted before provisioning, for
+	// StorageClasses without the verifyServer parameter.
+	VerifyServer bool
+	// ShareAliasesFile is a YAML file that maps the names StorageClasses
+	// give in the shareAlias parameter to shares, as server:/path. It is
+	// read again whenever it changes.
+	ShareAliasesFile string
+	// ClusterIDInSubDir prefixes the subdirectories of new volumes with
+	// ClusterID, e.g. "cluster-a-pvc-...".
+	ClusterIDInSubDir bool
+	// BackgroundWindows are the daily windows in which the driver does
+	// background work on shares, such as writing snapshot archives, empty
+	// for any time. BackgroundIORate limits the bytes per second it
+	// writes per share, together with restores and clones, 0 for no limit.
+	BackgroundWindows []TimeWindow
+	BackgroundIORate  int64
+	// MaxExportMetricLabels is the number of exports that get a label of
+	// their own in the provisioning metrics. Requests for further exports
+	// are reported with the export label "other".
+	MaxExportMetricLabels int
+	// SelfCheckExports are exports, as server:/path, on which the driver
+	// provisions, publishes, writes to and deletes a canary volume when it
+	// starts. Probe reports the driver as not ready until all passed.
+	SelfCheckExports []string
+	// AllowedServers are the nfs servers the controller may mount, so
+	// that a StorageClass cannot make it mount arbitrary hosts. Empty
+	// allows any server.
+	AllowedServers []string
+	// ControllerPublish advertises PUBLISH_UNPUBLISH_VOLUME and
+	// LIST_VOLUMES_PUBLISHED_NODES. ControllerPublishVolume then records
+	// the node in memory, and ListVolumes and ControllerGetVolume report
+	// the recorded nodes. It needs the external-attacher.
+	ControllerPublish bool
+	// TopologyServers maps values of the topology segment TopologyKey,
+	// e.g. zones, to the nfs servers of the segment. CreateVolume picks
+	// the server from the accessibility requirements for StorageClasses
+	// without a server, and returns the segments of the server as the
+	// accessible topology of the volume. NodeTopology is the value of the
+	// segment of this node, which NodeGetInfo reports.
+	TopologyKey     string
+	TopologyServers map[string]string
+	NodeTopology    string
+}
 
-	d := &driver{}
+// DefaultDriverOptions returns the options the nfsplugin binary runs with
+// when no flags are given. WithDriverOptions applies every field, so
+// callers should start from these and change the fields they need.
+func DefaultDriverOptions() *DriverOptions {
+	return &DriverOptions{
+		WorkingMountDir:        "/tmp",
+		DefaultDirMode:         0755,
+		DirModeFromAccessModes: true,
+		ProvisioningUID:        -1,
+		ProvisioningGID:        -1,
+		DeleteParallelism:      16,
+		WorkingDirPruneAge:     10 * time.Minute,
+		DNSCacheTTL:            30 * time.Second,
+		MaxConcurrentSnapshots: 4,
+		SnapshotsDir:           defaultSnapshotsDir,
+		ScratchDir:             "/var/lib/csi-nfs-scratch",
+		ServerFailureThreshold: 5,
+		ServerFailureCooldown:  30 * time.Second,
+		RetryPolicy:            DefaultRetryPolicy,
+		MountBurstPerServer:    10,
+		ProbeCanaryInterval:    time.Minute,
+		CapacityCacheTTL:       30 * time.Second,
+		QuarantineDir:          defaultQuarantineDir,
+		MaxExportMetricLabels:  20,
+		TopologyKey:            DefaultTopologyKey,
+	}
+}
 
-	d.endpoint = endpoint
+// New returns a driver configured by options. Without options it serves
+// with DefaultDriverOptions, like the nfsplugin binary, but needs an
+// endpoint or a listener to be started.
+func New(options ...Option) *Driver {
+	glog.Infof("Driver: %v version: %v", driverName, version)
 
-	csiDriver := csicommon.NewCSIDriver(driverName, version, nodeID)
-	csiDriver.AddVolumeCapabilityAccessModes([]csi.VolumeCapability_AccessMode_Mode{csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER})
-	// NFS plugin does not support ControllerServiceCapability now.
-	// If support is added, it should set to appropriate
-	// ControllerServiceCapability RPC types.
-	csiDriver.AddControllerServiceCapabilities([]csi.ControllerServiceCapability_RPC_Type{csi.ControllerServiceCapability_RPC_UNKNOWN})
+	d := &Driver{
+		accessModes: []csi.VolumeCapability_AccessMode_Mode{
+			csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+			csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+			csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER,
+			csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+			csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
+			csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER,
+		},
+		controllerCaps: []csi.ControllerServiceCapability_RPC_Type{
+			csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+			csi.ControllerServiceCapability_RPC_GET_CAPACITY,
+			csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+			csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+			csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
+			csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
+			csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+			csi.ControllerServiceCapability_RPC_GET_VOLUME,
+			csi.ControllerServiceCapability_RPC_VOLUME_CONDITION,
+			csi.ControllerServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
+			csi.ControllerServiceCapability_RPC_MODIFY_VOLUME,
+		},
+		nodeCaps: []csi.NodeServiceCapability_RPC_Type{
+			csi.NodeServiceCapability_RPC_EXPAND_VOLUME,
+			csi.NodeServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
+		},
+	}
+	WithDriverOptions(DefaultDriverOptions())(d)
+	for _, option := range options {
+		option(d)
+	}
+	if d.controllerPublish {
+		d.controllerCaps = addControllerCapabilities(d.controllerCaps,
+			csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME,
+			csi.ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES)
+	}
+	if d.mounter == nil {
+		d.mounter = newSystemMounter()
+	}
 
+	csiDriver := csicommon.NewCSIDriver(driverName, version, d.nodeID)
+	csiDriver.AddVolumeCapabilityAccessModes(d.accessModes)
+	csiDriver.AddControllerServiceCapabilities(d.controllerCaps)
 	d.csiDriver = csiDriver
 
 	return d
 }
 
-func NewNodeServer(d *driver) *nodeServer {
+// addControllerCapabilities appends the capabilities that caps does not
+// contain yet
+func addControllerCapabilities(caps []csi.ControllerServiceCapability_RPC_Type, add ...csi.ControllerServiceCapability_RPC_Type) []csi.ControllerServiceCapability_RPC_Type {
+	for _, c := range add {
+		found := false
+		for _, have := range caps {
+			if have == c {
+				found = true
+				break
+			}
+		}
+		if !found {
+			caps = append(caps, c)
+		}
+	}
+	return caps
+}
+
+// NewDriver returns a driver configured by options, which should be based
+// on DefaultDriverOptions
+func NewDriver(options *DriverOptions) *Driver {
+	return New(WithDriverOptions(options))
+}
+
+func NewNodeServer(d *Driver) *nodeServer {
 	return &nodeServer{
 		DefaultNodeServer: csicommon.NewDefaultNodeServer(d.csiDriver),
+		driver:            d,
+		mounter:           d.mounter,
+		proxies:           map[string]*tcpProxy{},
+		publishes:         newPublishTracker(d.maxPublishesPerVolume),
+		mountLimiters:     newServerLimiters(d.mountRatePerServer, d.mountBurstPerServer),
+	}
+}
+
+func NewControllerServer(d *Driver) *controllerServer {
+	cs := &controllerServer{
+		DefaultControllerServer: csicommon.NewDefaultControllerServer(d.csiDriver),
+		driver:                  d,
+	}
+	cs.mounts = newExportMounts(cs)
+	cs.exports = newExportQueues(cs)
+	cs.workDir = newWorkingDir(cs)
+	cs.scheduler = newShareScheduler(d.backgroundWindows, d.backgroundIORate)
+	cs.snapshots = newSnapshotJobs(d.maxConcurrentSnapshots, cs.scheduler)
+	cs.operations = newVolumeOperations()
+	cs.published = newPublishedNodes()
+	if d.shareAliasesFile != "" {
+		cs.shareAliases = newShareAliases(d.shareAliasesFile)
+	}
+	cs.exportLabels = newExportLabels(d.maxExportMetricLabels)
+	cs.breakers = newServerBreakers(d.serverFailureThreshold, d.serverFailureCooldown)
+	cs.quota = d.quotaProvider
+	cs.capacity = d.capacityProvider
+	if cs.capacity == nil {
+		cs.capacity = &statfsCapacityProvider{cs: cs}
+	}
+	if d.capacityCacheTTL > 0 {
+		cs.capacityCache = newCapacityCache(cs.capacity, d.capacityCacheTTL)
+		cs.capacity = cs.capacityCache
+	}
+	return cs
+}
+
+// Start starts serving the CSI services in the background
+func (d *Driver) Start() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.server != nil {
+		return fmt.Errorf("driver is already started")
+	}
+
+	listener := d.listener
+	if listener == nil {
+		var err error
+		if listener, err = listen(d.endpoint); err != nil {
+			return err
+		}
+	}
+
+	d.ns = NewNodeServer(d)
+	d.cs = NewControllerServer(d)
+
+	if err := checkWritableDir(d.workingMountDir); err != nil {
+		glog.Warningf("Working mount directory is not writable, provisioning will fail: %v. With a read-only root filesystem, point --working-mount-dir to a writable volume such as an emptyDir.", err)
+	}
+	if d.metricsAddress != "" {
+		if d.mountStatsMetrics {
+			d.mountStats = &mountStatsCollector{publishes: d.ns.publishes}
+			if err := prometheus.Register(d.mountStats); err != nil {
+				glog.Warningf("failed to register nfs mount statistics: %v", err)
+				d.mountStats = nil
+			}
+		}
+		d.metricsServer = serveMetrics(d.metricsAddress, d.supportBundleHandler)
+	}
+	d.stopCh = make(chan struct{})
+	go d.cs.workDir.run(d.workingDirPruneAge, d.stopCh)
+	if d.warmUp {
+		go d.ns.warmUp(d.warmUpServers)
+	}
+
+	d.server = newGRPCServer(d.compressResponses, d.interceptors)
+	ids := &identityServer{
+		DefaultIdentityServer: csicommon.NewDefaultIdentityServer(d.csiDriver),
+		topology:              d.topologyEnabled(),
+	}
+	if d.probeCanary != "" {
+		server, share, _ := volume.ParseMigratedID(d.probeCanary)
+		ids.canary = newCanaryProbe(d.cs, server, share, d.probeCanaryInterval)
+	}
+	if len(d.selfCheckExports) > 0 {
+		ids.selfCheck = newSelfCheck(d, d.selfCheckExports)
+		go ids.selfCheck.run()
+	}
+	d.server.Start(listener,
+		ids,
+		d.cs,
+		d.ns)
+	return nil
+}
+
+// Wait blocks until the driver is stopped
+func (d *Driver) Wait() {
+	d.mutex.Lock()
+	s := d.server
+	d.mutex.Unlock()
+	if s != nil {
+		s.Wait()
 	}
 }
 
-func (d *driver) Run() {
-	s := csicommon.NewNonBlockingGRPCServer()
-	s.Start(d.endpoint,
-		csicommon.NewDefaultIdentityServer(d.csiDriver),
-		// NFS plugin has not implemented ControllerServer
-		// using default controllerserver.
-		csicommon.NewDefaultControllerServer(d.csiDriver),
-		NewNodeServer(d))
-	s.Wait()
+// Stop stops serving after the pending requests are finished
+func (d *Driver) Stop() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.server == nil {
+		return
+	}
+	d.server.Stop()
+	close(d.stopCh)
+	if d.metricsServer != nil {
+		d.metricsServer.Close()
+	}
+	if d.mountStats != nil {
+		prometheus.Unregister(d.mountStats)
+		d.mountStats = nil
+	}
+	d.server = nil
+	d.metricsServer = nil
+}
+
+// Run serves the CSI services until the driver is stopped
+func (d *Driver) Run() {
+	if err := d.Start(); err != nil {
+		glog.Fatalf("Failed to start driver: %v", err)
+	}
+	d.Wait()
 }
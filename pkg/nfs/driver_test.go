@@ -0,0 +1,91 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"k8s.io/kubernetes/pkg/util/mount"
+)
+
+func TestDefaultDriverOptions(t *testing.T) {
+	want := &DriverOptions{
+		WorkingMountDir:        "/tmp",
+		DefaultDirMode:         0755,
+		DirModeFromAccessModes: true,
+		ProvisioningUID:        -1,
+		ProvisioningGID:        -1,
+		DeleteParallelism:      16,
+		WorkingDirPruneAge:     10 * time.Minute,
+		DNSCacheTTL:            30 * time.Second,
+		MaxConcurrentSnapshots: 4,
+		SnapshotsDir:           ".snapshots",
+		ScratchDir:             "/var/lib/csi-nfs-scratch",
+		ServerFailureThreshold: 5,
+		ServerFailureCooldown:  30 * time.Second,
+		RetryPolicy: RetryPolicy{
+			MaxAttempts:    1,
+			BaseDelay:      time.Second,
+			MaxDelay:       30 * time.Second,
+			RetryableCodes: []codes.Code{codes.Unavailable, codes.Internal},
+		},
+		MountBurstPerServer:   10,
+		ProbeCanaryInterval:   time.Minute,
+		CapacityCacheTTL:      30 * time.Second,
+		QuarantineDir:         ".csi-nfs-quarantine",
+		MaxExportMetricLabels: 20,
+		TopologyKey:           "topology.kubernetes.io/zone",
+	}
+	if got := DefaultDriverOptions(); !reflect.DeepEqual(got, want) {
+		t.Errorf("DefaultDriverOptions() = %+v, want %+v", got, want)
+	}
+
+	// New without options serves with the defaults
+	d := New(WithNodeID("test"), WithMounter(&mount.FakeMounter{}))
+	for _, test := range []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"workingMountDir", d.workingMountDir, want.WorkingMountDir},
+		{"defaultDirMode", d.defaultDirMode, want.DefaultDirMode},
+		{"dirModeFromAccessModes", d.dirModeFromAccessModes, want.DirModeFromAccessModes},
+		{"provisioningUID", d.provisioningUID, want.ProvisioningUID},
+		{"provisioningGID", d.provisioningGID, want.ProvisioningGID},
+		{"deleteParallelism", d.deleteParallelism, want.DeleteParallelism},
+		{"workingDirPruneAge", d.workingDirPruneAge, want.WorkingDirPruneAge},
+		{"resolver", d.resolver != nil, true},
+		{"maxConcurrentSnapshots", d.maxConcurrentSnapshots, want.MaxConcurrentSnapshots},
+		{"snapshotsDir", d.snapshotsDir, want.SnapshotsDir},
+		{"scratchDir", d.scratchDir, want.ScratchDir},
+		{"serverFailureThreshold", d.serverFailureThreshold, want.ServerFailureThreshold},
+		{"serverFailureCooldown", d.serverFailureCooldown, want.ServerFailureCooldown},
+		{"retryPolicy", d.retryPolicy, want.RetryPolicy},
+		{"mountBurstPerServer", d.mountBurstPerServer, want.MountBurstPerServer},
+		{"probeCanaryInterval", d.probeCanaryInterval, want.ProbeCanaryInterval},
+		{"capacityCacheTTL", d.capacityCacheTTL, want.CapacityCacheTTL},
+		{"quarantineDir", d.quarantineDir, want.QuarantineDir},
+		{"maxExportMetricLabels", d.maxExportMetricLabels, want.MaxExportMetricLabels},
+		{"topologyKey", d.topologyKey, want.TopologyKey},
+	} {
+		if !reflect.DeepEqual(test.got, test.want) {
+			t.Errorf("New() has %s %v, want %v", test.name, test.got, test.want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"os"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ControllerExpandVolume raises the quota of a volume to its new size with
+// the quota provider. Without one, volumes are directories without quota
+// that may use all space of their share, so there is nothing to grow. The
+// nodes never need to grow anything.
+func (cs *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
+	if err := cs.driver.csiDriver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_EXPAND_VOLUME); err != nil {
+		return nil, err
+	}
+
+	volumeID := req.GetVolumeId()
+	if volumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id is empty")
+	}
+	if req.GetCapacityRange() == nil {
+		return nil, status.Error(codes.InvalidArgument, "capacity range must be provided")
+	}
+	capacity, err := expandedCapacity(req.GetCapacityRange())
+	if err != nil {
+		return nil, err
+	}
+	if c := req.GetVolumeCapability(); c != nil {
+		if err := cs.validateVolumeCapability(c); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	nfsVol, err := cs.volumeByID(volumeID)
+	if err != nil {
+		return nil, err
+	}
+	if err := cs.checkVolumeExists(ctx, nfsVol); err != nil {
+		return nil, err
+	}
+	if err := cs.setQuota(ctx, nfsVol, capacity); err != nil {
+		return nil, err
+	}
+	// Clones of the volume must be at least as large
+	if nfsVol.subDir != "" {
+		err = cs.updateVolumeMetadata(ctx, nfsVol, func(md *volumeMetadata) *volumeMetadata {
+			if md != nil {
+				md.ExpandedBytes = capacity
+			}
+			return md
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	glog.V(4).Infof("Volume %v expanded to %d bytes", volumeID, capacity)
+	return &csi.ControllerExpandVolumeResponse{
+		CapacityBytes:         capacity,
+		NodeExpansionRequired: false,
+	}, nil
+}
+
+// NodeExpandVolume acknowledges the new size of a published volume, whose
+// mount already sees all space of the share
+func (ns *nodeServer) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
+	if req.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id is empty")
+	}
+	volumePath := req.GetVolumePath()
+	if volumePath == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume path is empty")
+	}
+	capacity, err := expandedCapacity(req.GetCapacityRange())
+	if err != nil {
+		return nil, err
+	}
+	if c := req.GetVolumeCapability(); c != nil && c.GetBlock() != nil {
+		return nil, status.Error(codes.InvalidArgument, "driver does not support block volumes")
+	}
+
+	notMnt, err := ns.mounter.IsLikelyNotMountPoint(volumePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, status.Errorf(codes.NotFound, "volume path %v not found", volumePath)
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if notMnt {
+		return nil, status.Errorf(codes.NotFound, "volume %v is not mounted at %v", req.GetVolumeId(), volumePath)
+	}
+
+	glog.V(4).Infof("Volume %v at %v expanded to %d bytes", req.GetVolumeId(), volumePath, capacity)
+	return &csi.NodeExpandVolumeResponse{CapacityBytes: capacity}, nil
+}
+
+// expandedCapacity returns the size of a volume expanded to capacity, the
+// required bytes or the limit if only a limit is given
+func expandedCapacity(capacity *csi.CapacityRange) (int64, error) {
+	required := capacity.GetRequiredBytes()
+	limit := capacity.GetLimitBytes()
+	if required < 0 || limit < 0 {
+		return 0, status.Error(codes.InvalidArgument, "capacity range must not be negative")
+	}
+	if limit > 0 && required > limit {
+		return 0, status.Errorf(codes.OutOfRange, "required capacity %d exceeds the limit of %d bytes", required, limit)
+	}
+	if required == 0 {
+		return limit, nil
+	}
+	return required, nil
+}
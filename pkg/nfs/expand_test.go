@@ -0,0 +1,258 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/kubernetes/pkg/util/mount"
+)
+
+func TestControllerExpandVolume(t *testing.T) {
+	workDir := t.TempDir()
+	cs := newTestControllerServer(WithWorkingMountDir(workDir))
+	cs.driver.ns = NewNodeServer(cs.driver)
+	vol := &nfsVolume{server: "192.0.2.10", baseDir: "export", subDir: "pvc-1", name: "pvc-1"}
+	volumeID := cs.getVolumeIdFromNfsVol(vol)
+
+	// The fake mounter leaves the share at its mount path as it is
+	mountPath := filepath.Join(workDir, exportMountName(exportKey(vol)))
+	if err := os.MkdirAll(filepath.Join(mountPath, vol.subDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	mountCap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+	}
+	blockCap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+
+	tests := []struct {
+		name     string
+		volumeID string
+		capacity *csi.CapacityRange
+		cap      *csi.VolumeCapability
+		want     int64
+		code     codes.Code
+	}{
+		{"required", volumeID, &csi.CapacityRange{RequiredBytes: 2 << 30}, nil, 2 << 30, codes.OK},
+		{"required and limit", volumeID, &csi.CapacityRange{RequiredBytes: 2 << 30, LimitBytes: 4 << 30}, mountCap, 2 << 30, codes.OK},
+		{"limit only", volumeID, &csi.CapacityRange{LimitBytes: 4 << 30}, nil, 4 << 30, codes.OK},
+		{"no volume id", "", &csi.CapacityRange{RequiredBytes: 1}, nil, 0, codes.InvalidArgument},
+		{"no capacity range", volumeID, nil, nil, 0, codes.InvalidArgument},
+		{"negative", volumeID, &csi.CapacityRange{RequiredBytes: -1}, nil, 0, codes.InvalidArgument},
+		{"over the limit", volumeID, &csi.CapacityRange{RequiredBytes: 4 << 30, LimitBytes: 2 << 30}, nil, 0, codes.OutOfRange},
+		{"block", volumeID, &csi.CapacityRange{RequiredBytes: 1}, blockCap, 0, codes.InvalidArgument},
+		{"invalid volume id", "nfs/export/..", &csi.CapacityRange{RequiredBytes: 1}, nil, 0, codes.NotFound},
+		{"missing volume", "v2:192.0.2.10/export/pvc-2/pvc-2", &csi.CapacityRange{RequiredBytes: 1}, nil, 0, codes.NotFound},
+	}
+	for _, test := range tests {
+		resp, err := cs.ControllerExpandVolume(context.Background(), &csi.ControllerExpandVolumeRequest{
+			VolumeId:         test.volumeID,
+			CapacityRange:    test.capacity,
+			VolumeCapability: test.cap,
+		})
+		if status.Code(err) != test.code {
+			t.Errorf("%s: ControllerExpandVolume() = %v, want %v", test.name, err, test.code)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if resp.GetCapacityBytes() != test.want || resp.GetNodeExpansionRequired() {
+			t.Errorf("%s: ControllerExpandVolume() = %+v, want %d bytes without node expansion", test.name, resp, test.want)
+		}
+	}
+}
+
+func TestControllerExpandVolumeNotAdvertised(t *testing.T) {
+	cs := newTestControllerServer(WithControllerCapabilities(csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME))
+	_, err := cs.ControllerExpandVolume(context.Background(), &csi.ControllerExpandVolumeRequest{
+		VolumeId:      "v2:192.0.2.10/export/pvc-1/pvc-1",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 1},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("ControllerExpandVolume() without EXPAND_VOLUME = %v, want InvalidArgument", err)
+	}
+}
+
+func TestNodeExpandVolume(t *testing.T) {
+	dir := t.TempDir()
+	mounted := filepath.Join(dir, "mounted")
+	notMounted := filepath.Join(dir, "not-mounted")
+	for _, path := range []string{mounted, notMounted} {
+		if err := os.Mkdir(path, 0750); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mounter := &mount.FakeMounter{MountPoints: []mount.MountPoint{{Device: "192.0.2.10:/export/pvc-1", Path: mounted, Type: "nfs"}}}
+	ns := NewNodeServer(New(WithNodeID("test"), WithMounter(mounter)))
+	volumeID := "v2:192.0.2.10/export/pvc-1/pvc-1"
+	blockCap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+
+	tests := []struct {
+		name       string
+		volumeID   string
+		volumePath string
+		capacity   *csi.CapacityRange
+		cap        *csi.VolumeCapability
+		want       int64
+		code       codes.Code
+	}{
+		{"required", volumeID, mounted, &csi.CapacityRange{RequiredBytes: 2 << 30}, nil, 2 << 30, codes.OK},
+		{"no capacity range", volumeID, mounted, nil, nil, 0, codes.OK},
+		{"no volume id", "", mounted, &csi.CapacityRange{RequiredBytes: 1}, nil, 0, codes.InvalidArgument},
+		{"no volume path", volumeID, "", &csi.CapacityRange{RequiredBytes: 1}, nil, 0, codes.InvalidArgument},
+		{"over the limit", volumeID, mounted, &csi.CapacityRange{RequiredBytes: 4 << 30, LimitBytes: 2 << 30}, nil, 0, codes.OutOfRange},
+		{"block", volumeID, mounted, &csi.CapacityRange{RequiredBytes: 1}, blockCap, 0, codes.InvalidArgument},
+		{"not mounted", volumeID, notMounted, &csi.CapacityRange{RequiredBytes: 1}, nil, 0, codes.NotFound},
+		{"missing path", volumeID, filepath.Join(dir, "missing"), &csi.CapacityRange{RequiredBytes: 1}, nil, 0, codes.NotFound},
+	}
+	for _, test := range tests {
+		resp, err := ns.NodeExpandVolume(context.Background(), &csi.NodeExpandVolumeRequest{
+			VolumeId:         test.volumeID,
+			VolumePath:       test.volumePath,
+			CapacityRange:    test.capacity,
+			VolumeCapability: test.cap,
+		})
+		if status.Code(err) != test.code {
+			t.Errorf("%s: NodeExpandVolume() = %v, want %v", test.name, err, test.code)
+			continue
+		}
+		if err == nil && resp.GetCapacityBytes() != test.want {
+			t.Errorf("%s: NodeExpandVolume() = %d bytes, want %d", test.name, resp.GetCapacityBytes(), test.want)
+		}
+	}
+}
+
+func TestNodeGetCapabilities(t *testing.T) {
+	for _, test := range []struct {
+		options []Option
+		want    []csi.NodeServiceCapability_RPC_Type
+	}{
+		{nil, []csi.NodeServiceCapability_RPC_Type{csi.NodeServiceCapability_RPC_EXPAND_VOLUME, csi.NodeServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER}},
+		{[]Option{WithNodeCapabilities()}, nil},
+	} {
+		ns := NewNodeServer(New(append([]Option{WithNodeID("test"), WithMounter(&mount.FakeMounter{})}, test.options...)...))
+		resp, err := ns.NodeGetCapabilities(context.Background(), &csi.NodeGetCapabilitiesRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []csi.NodeServiceCapability_RPC_Type
+		for _, c := range resp.GetCapabilities() {
+			got = append(got, c.GetRpc().GetType())
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("NodeGetCapabilities() = %v, want %v", got, test.want)
+		}
+	}
+}
+
+// fakeQuota records the quotas it sets, and fails with err if set
+type fakeQuota struct {
+	quotas map[string]int64
+	err    error
+}
+
+func (q *fakeQuota) SetQuota(ctx context.Context, server, share, dir string, capacity int64) error {
+	if q.err != nil {
+		return q.err
+	}
+	q.quotas[server+":"+share+"/"+dir] = capacity
+	return nil
+}
+
+func TestQuotaProvider(t *testing.T) {
+	workDir := t.TempDir()
+	quota := &fakeQuota{quotas: map[string]int64{}}
+	options := DefaultDriverOptions()
+	options.NodeID = "test"
+	options.WorkingMountDir = workDir
+	options.Mounter = &mount.FakeMounter{}
+	options.QuotaProvider = quota
+	cs := NewControllerServer(New(WithDriverOptions(options)))
+	cs.driver.ns = NewNodeServer(cs.driver)
+	share := &nfsVolume{server: "192.0.2.10", baseDir: "export"}
+	// The fake mounter leaves the share at its mount path as it is
+	mountPath := filepath.Join(workDir, exportMountName(exportKey(share)))
+	if err := os.MkdirAll(mountPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	create := func(name string, capacity int64) (*csi.CreateVolumeResponse, error) {
+		return cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+			Name:               name,
+			Parameters:         map[string]string{paramServer: "192.0.2.10", paramShare: "/export"},
+			VolumeCapabilities: []*csi.VolumeCapability{testMountCapability},
+			CapacityRange:      &csi.CapacityRange{RequiredBytes: capacity},
+		})
+	}
+	expand := func(volumeID string, capacity int64) (*csi.ControllerExpandVolumeResponse, error) {
+		return cs.ControllerExpandVolume(context.Background(), &csi.ControllerExpandVolumeRequest{
+			VolumeId:      volumeID,
+			CapacityRange: &csi.CapacityRange{RequiredBytes: capacity},
+		})
+	}
+
+	resp, err := create("pvc-1", 1<<30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	volumeID := resp.GetVolume().GetVolumeId()
+	if _, err := create("pvc-2", 0); err != nil {
+		t.Fatal(err)
+	}
+	// Volumes without a capacity get no quota
+	want := map[string]int64{"192.0.2.10:/export/pvc-1": 1 << 30}
+	if !reflect.DeepEqual(quota.quotas, want) {
+		t.Errorf("CreateVolume() set quotas %v, want %v", quota.quotas, want)
+	}
+
+	if _, err := expand(volumeID, 2<<30); err != nil {
+		t.Fatal(err)
+	}
+	if got := quota.quotas["192.0.2.10:/export/pvc-1"]; got != 2<<30 {
+		t.Errorf("ControllerExpandVolume() set a quota of %d, want %d", got, 2<<30)
+	}
+
+	// Failures of the provider fail the requests, and status errors are
+	// passed on
+	quota.err = status.Error(codes.ResourceExhausted, "filer is full")
+	if _, err := expand(volumeID, 4<<30); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("ControllerExpandVolume() = %v, want %v", err, codes.ResourceExhausted)
+	}
+	quota.err = errors.New("agent unreachable")
+	if _, err := create("pvc-3", 1<<30); status.Code(err) != codes.Internal {
+		t.Errorf("CreateVolume() = %v, want %v", err, codes.Internal)
+	}
+	// The volume is not left behind half set up
+	if _, err := os.Stat(filepath.Join(mountPath, "pvc-3")); !os.IsNotExist(err) {
+		t.Errorf("the directory of the failed volume was not removed: %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/status"
+)
+
+// Export label of the exports beyond the limit of exportLabels
+const otherExportsLabel = "other"
+
+var (
+	provisioningDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "provisioning_duration_seconds",
+		Help:      "Duration of CreateVolume and DeleteVolume requests per export, including failed requests.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+	}, []string{"export", "operation"})
+	provisioningErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "provisioning_errors_total",
+		Help:      "Failed CreateVolume and DeleteVolume requests per export and gRPC code.",
+	}, []string{"export", "operation", "code"})
+)
+
+func init() {
+	prometheus.MustRegister(provisioningDuration, provisioningErrors)
+}
+
+// exportLabels hands out the export label of the provisioning metrics.
+// The first max exports get a label of their own, as server:/base-dir,
+// and all later ones share the label "other", so that a controller
+// serving many exports does not create an unbounded number of series.
+type exportLabels struct {
+	max int
+
+	mutex  sync.Mutex
+	labels map[string]bool
+}
+
+func newExportLabels(max int) *exportLabels {
+	return &exportLabels{
+		max:    max,
+		labels: map[string]bool{},
+	}
+}
+
+func (l *exportLabels) label(vol *nfsVolume) string {
+	export := vol.server + ":" + filepath.Join("/", vol.baseDir)
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.labels[export] {
+		return export
+	}
+	if len(l.labels) >= l.max {
+		return otherExportsLabel
+	}
+	l.labels[export] = true
+	return export
+}
+
+// observe records a provisioning request of operation on the export of
+// vol that started at start and failed with err, or succeeded if err is
+// nil
+func (l *exportLabels) observe(operation string, vol *nfsVolume, start time.Time, err error) {
+	export := l.label(vol)
+	provisioningDuration.WithLabelValues(export, operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		provisioningErrors.WithLabelValues(export, operation, status.Code(err).String()).Inc()
+	}
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+)
+
+// How long an export stays mounted after its last user released it
+const exportIdleTimeout = 10 * time.Second
+
+// exportMount is a cached mount of an export in the working mount
+// directory
+type exportMount struct {
+	// Volume describing the export, without subDir
+	vol *nfsVolume
+	// Closed once the mount attempt finished, err is its result
+	ready chan struct{}
+	err   error
+	// Closed once the mount is gone again
+	gone chan struct{}
+	// Mount of the same export that is still being unmounted
+	prev *exportMount
+
+	// Protected by exportMounts.mutex
+	refs    int
+	idle    *time.Timer
+	closing bool
+}
+
+// exportMounts caches the mounts of exports (server and base directory)
+// in the controller. Every user holds a reference to the mount of its
+// export, which is mounted by the first one and shared by all others, and
+// it stays mounted until no user held a reference for exportIdleTimeout.
+// This avoids a mount per request during bursts, and long running
+// operations, such as copying a volume, share the mount with the requests
+// for the same export.
+type exportMounts struct {
+	cs *controllerServer
+
+	mutex  sync.Mutex
+	mounts map[string]*exportMount
+}
+
+func newExportMounts(cs *controllerServer) *exportMounts {
+	return &exportMounts{
+		cs:     cs,
+		mounts: map[string]*exportMount{},
+	}
+}
+
+// exportKey returns the key of the export of vol
+func exportKey(vol *nfsVolume) string {
+	return fmt.Sprintf("%s:%s", vol.server, filepath.Join(string(filepath.Separator), vol.baseDir))
+}
+
+// acquire returns the path the export of vol is mounted at, mounting it if
+// needed. release must be called once the caller is done with the mount.
+// Only new mounts are held back by the breaker of the server; callers of
+// a mount that exists or is in progress share its outcome.
+func (m *exportMounts) acquire(ctx context.Context, vol *nfsVolume) (mountPath string, release func(), err error) {
+	key := exportKey(vol)
+	m.mutex.Lock()
+	e, ok := m.mounts[key]
+	if !ok || e.closing {
+		if err := m.cs.breakers.check(ctx, vol.server); err != nil {
+			m.mutex.Unlock()
+			return "", nil, err
+		}
+		e = &exportMount{
+			vol: &nfsVolume{
+				id:      key,
+				server:  vol.server,
+				baseDir: vol.baseDir,
+				name:    exportMountName(key),
+			},
+			ready: make(chan struct{}),
+			gone:  make(chan struct{}),
+			prev:  e,
+		}
+		m.mounts[key] = e
+		go m.mount(key, e)
+	}
+	e.refs++
+	if e.idle != nil {
+		e.idle.Stop()
+		e.idle = nil
+	}
+	m.mutex.Unlock()
+
+	var once sync.Once
+	release = func() {
+		once.Do(func() { m.release(key, e) })
+	}
+	select {
+	case <-e.ready:
+	case <-ctx.Done():
+		release()
+		return "", nil, ctx.Err()
+	}
+	if e.err != nil {
+		release()
+		return "", nil, fmt.Errorf("failed to mount nfs server: %v", e.err)
+	}
+	return m.cs.getInternalMountPath(e.vol), release, nil
+}
+
+// mount mounts the export of e once the previous mount at the same path
+// is gone. Failed mounts are dropped right away, so that the next user
+// tries again.
+func (m *exportMounts) mount(key string, e *exportMount) {
+	if e.prev != nil {
+		<-e.prev.gone
+		e.prev = nil
+	}
+	// The mount is shared by many requests, so it must not be bound to
+	// the context of any of them.
+	e.err = m.cs.internalMount(context.Background(), e.vol)
+	if e.err != nil {
+		m.mutex.Lock()
+		if m.mounts[key] == e {
+			delete(m.mounts, key)
+		}
+		e.closing = true
+		m.mutex.Unlock()
+		close(e.gone)
+	}
+	close(e.ready)
+}
+
+// release drops a reference to e and arms its idle timer once no
+// references are left
+func (m *exportMounts) release(key string, e *exportMount) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	e.refs--
+	if e.refs > 0 || e.closing {
+		return
+	}
+	e.idle = time.AfterFunc(exportIdleTimeout, func() { m.expire(key, e) })
+}
+
+// expire unmounts e unless it was acquired again in the meantime
+func (m *exportMounts) expire(key string, e *exportMount) {
+	m.mutex.Lock()
+	if e.refs > 0 || e.closing {
+		m.mutex.Unlock()
+		return
+	}
+	e.closing = true
+	e.idle = nil
+	m.mutex.Unlock()
+
+	<-e.ready
+	if e.err == nil {
+		if err := m.cs.internalUnmount(context.Background(), e.vol); err != nil {
+			glog.Warningf("failed to unmount nfs server: %v", err.Error())
+		}
+		// Closed for failed mounts already
+		close(e.gone)
+	}
+
+	m.mutex.Lock()
+	if m.mounts[key] == e {
+		delete(m.mounts, key)
+	}
+	m.mutex.Unlock()
+}
+
+// exportMountName returns the name of the directory an export is mounted
+// at in the working directory
+func exportMountName(key string) string {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return fmt.Sprintf("export-%x", h.Sum64())
+}
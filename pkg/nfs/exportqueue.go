@@ -0,0 +1,145 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"sync"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/status"
+)
+
+// exportOp is a directory operation on a mounted export
+type exportOp struct {
+	ctx context.Context
+	// fn gets the path the export is mounted at
+	fn   func(mountPath string) error
+	done chan error
+}
+
+// exportWorker runs the operations of one export one after the other
+type exportWorker struct {
+	// Volume describing the export
+	vol *nfsVolume
+	ops chan *exportOp
+	// Number of operations submitted but not finished yet,
+	// protected by exportQueues.mutex
+	pending int
+}
+
+// exportQueues serializes the controller operations on the same export
+// (server and base directory), so that conflicting operations run in a
+// deterministic order. Each export gets a worker while operations are
+// queued, which runs them one after the other under the cached mount of
+// the export, see exportMounts.
+type exportQueues struct {
+	cs *controllerServer
+
+	mutex   sync.Mutex
+	workers map[string]*exportWorker
+}
+
+func newExportQueues(cs *controllerServer) *exportQueues {
+	return &exportQueues{
+		cs:      cs,
+		workers: map[string]*exportWorker{},
+	}
+}
+
+// run queues fn on the export of vol and waits for its result
+func (q *exportQueues) run(ctx context.Context, vol *nfsVolume, fn func(mountPath string) error) error {
+	key := exportKey(vol)
+	op := &exportOp{
+		ctx:  ctx,
+		fn:   fn,
+		done: make(chan error, 1),
+	}
+
+	q.mutex.Lock()
+	w, ok := q.workers[key]
+	if !ok {
+		w = &exportWorker{
+			vol: vol,
+			ops: make(chan *exportOp),
+		}
+		q.workers[key] = w
+		go q.work(key, w)
+	}
+	// The worker does not exit while operations are pending
+	w.pending++
+	q.mutex.Unlock()
+
+	// The worker may be busy with a long operation, so the caller stops
+	// waiting for it to take op once it gives up
+	select {
+	case w.ops <- op:
+	case <-ctx.Done():
+		q.abandon(key, w)
+		return status.FromContextError(ctx.Err()).Err()
+	}
+	select {
+	case err := <-op.done:
+		return err
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+// abandon drops an operation that was never handed to the worker w of
+// export key, and stops the worker if it was the last one pending
+func (q *exportQueues) abandon(key string, w *exportWorker) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	w.pending--
+	if w.pending == 0 {
+		// The worker waits for the next operation, which never comes
+		delete(q.workers, key)
+		close(w.ops)
+	}
+}
+
+// work runs the operations of the export key until none are pending, or
+// until abandon closes its channel
+func (q *exportQueues) work(key string, w *exportWorker) {
+	for op := range w.ops {
+		err := op.ctx.Err()
+		if err == nil {
+			err = q.runOp(op, w.vol)
+		}
+		// Otherwise the caller gave up waiting, don't act on its behalf
+		op.done <- err
+
+		q.mutex.Lock()
+		w.pending--
+		if w.pending == 0 {
+			delete(q.workers, key)
+			q.mutex.Unlock()
+			return
+		}
+		q.mutex.Unlock()
+	}
+}
+
+// runOp runs op under the mount of the export of vol
+func (q *exportQueues) runOp(op *exportOp, vol *nfsVolume) error {
+	mountPath, release, err := q.cs.mounts.acquire(op.ctx, vol)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return op.fn(mountPath)
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+)
+
+// Environment variables that enable fault injection, for e2e tests and
+// chaos drills. CSI_NFS_FAULTS is a comma separated list of
+// point=action[@probability], where action is "fail" or a delay such as
+// "2s" and probability defaults to 1, e.g.
+// "mount=fail@0.2,mkdir=3s@0.5,removeall=fail". CSI_NFS_FAULT_SEED seeds
+// the random source, so that a run can be repeated exactly.
+const (
+	faultsEnv    = "CSI_NFS_FAULTS"
+	faultSeedEnv = "CSI_NFS_FAULT_SEED"
+)
+
+// Points where faults can be injected
+const (
+	// Node mounts of shares, also used by the controller
+	faultMount = "mount"
+	// Creation of volume subdirectories
+	faultMkdir = "mkdir"
+	// Removal of volume data
+	faultRemoveAll = "removeall"
+)
+
+// fault is injected at a point with a probability
+type fault struct {
+	// Delay before the operation, 0 to fail it instead
+	delay       time.Duration
+	probability float64
+}
+
+var (
+	faultsOnce sync.Once
+	faults     map[string]fault
+
+	// Protects faultRand, which is not safe for concurrent use
+	faultMutex sync.Mutex
+	faultRand  *rand.Rand
+)
+
+// loadFaults parses the fault injection environment variables once
+func loadFaults() {
+	faultsOnce.Do(func() {
+		spec := os.Getenv(faultsEnv)
+		if spec == "" {
+			return
+		}
+		var err error
+		if faults, err = parseFaults(spec); err != nil {
+			glog.Fatalf("invalid %s: %v", faultsEnv, err)
+		}
+		seed := time.Now().UnixNano()
+		if s := os.Getenv(faultSeedEnv); s != "" {
+			if seed, err = strconv.ParseInt(s, 10, 64); err != nil {
+				glog.Fatalf("invalid %s %q: %v", faultSeedEnv, s, err)
+			}
+		}
+		faultRand = rand.New(rand.NewSource(seed))
+		glog.Warningf("Fault injection is enabled: %s (seed %d)", spec, seed)
+	})
+}
+
+func parseFaults(spec string) (map[string]fault, error) {
+	parsed := map[string]fault{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kv := strings.SplitN(entry, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("%q is not point=action[@probability]", entry)
+		}
+		point, action := kv[0], kv[1]
+		switch point {
+		case faultMount, faultMkdir, faultRemoveAll:
+		default:
+			return nil, fmt.Errorf("unknown fault injection point %q", point)
+		}
+
+		f := fault{probability: 1}
+		if i := strings.LastIndex(action, "@"); i >= 0 {
+			p, err := strconv.ParseFloat(action[i+1:], 64)
+			if err != nil || p < 0 || p > 1 {
+				return nil, fmt.Errorf("invalid probability in %q, must be between 0 and 1", entry)
+			}
+			f.probability = p
+			action = action[:i]
+		}
+		if action != "fail" {
+			d, err := time.ParseDuration(action)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("invalid action in %q, must be \"fail\" or a delay", entry)
+			}
+			f.delay = d
+		}
+		parsed[point] = f
+	}
+	return parsed, nil
+}
+
+// injectFault delays the operation at point or returns an error for it,
+// if fault injection is enabled for point and the dice say so
+func injectFault(ctx context.Context, point string) error {
+	loadFaults()
+	f, ok := faults[point]
+	if !ok {
+		return nil
+	}
+	faultMutex.Lock()
+	hit := faultRand.Float64() < f.probability
+	faultMutex.Unlock()
+	if !hit {
+		return nil
+	}
+
+	if f.delay == 0 {
+		glog.Warningf("Injecting failure at %s", point)
+		return fmt.Errorf("injected failure at %s", point)
+	}
+	glog.Warningf("Injecting delay of %v at %s", f.delay, point)
+	select {
+	case <-time.After(f.delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"fmt"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// setfsid changes the filesystem uid or gid of the calling thread and
+// returns the previous one. Passing -1 only queries the current value.
+func setfsid(trap uintptr, id int) int {
+	prev, _, _ := unix.RawSyscall(trap, uintptr(id), 0, 0)
+	return int(prev)
+}
+
+// runWithFsCreds runs fn with the filesystem uid and gid of the current
+// thread switched to uid and gid. A negative id leaves it unchanged.
+// The NFS client uses these credentials for AUTH_SYS, so files created
+// by fn are owned by uid:gid even on exports that squash root.
+func runWithFsCreds(uid, gid int, fn func() error) error {
+	if uid < 0 && gid < 0 {
+		return fn()
+	}
+
+	// Filesystem credentials are per thread.
+	runtime.LockOSThread()
+
+	prevUID := setfsid(unix.SYS_SETFSUID, -1)
+	prevGID := setfsid(unix.SYS_SETFSGID, -1)
+	restore := func() error {
+		setfsid(unix.SYS_SETFSGID, prevGID)
+		setfsid(unix.SYS_SETFSUID, prevUID)
+		if setfsid(unix.SYS_SETFSUID, -1) != prevUID || setfsid(unix.SYS_SETFSGID, -1) != prevGID {
+			// Leave the thread locked so that it is terminated together
+			// with this goroutine instead of being reused.
+			return fmt.Errorf("failed to restore filesystem credentials %d:%d", prevUID, prevGID)
+		}
+		runtime.UnlockOSThread()
+		return nil
+	}
+
+	if gid >= 0 {
+		setfsid(unix.SYS_SETFSGID, gid)
+		if setfsid(unix.SYS_SETFSGID, -1) != gid {
+			restore()
+			return fmt.Errorf("failed to switch filesystem gid to %d", gid)
+		}
+	}
+	if uid >= 0 {
+		setfsid(unix.SYS_SETFSUID, uid)
+		if setfsid(unix.SYS_SETFSUID, -1) != uid {
+			restore()
+			return fmt.Errorf("failed to switch filesystem uid to %d", uid)
+		}
+	}
+
+	err := fn()
+	if restoreErr := restore(); restoreErr != nil && err == nil {
+		err = restoreErr
+	}
+	return err
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ControllerGetVolume reports the condition of a volume. The volume is
+// abnormal if its share cannot be mounted or its directory is gone; such
+// volumes are not reported as not found, so that the external health
+// monitor raises an event on their claims before pods fail to mount them.
+func (cs *controllerServer) ControllerGetVolume(ctx context.Context, req *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
+	if err := cs.driver.csiDriver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_GET_VOLUME); err != nil {
+		return nil, err
+	}
+
+	volumeID := req.GetVolumeId()
+	if volumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id is empty")
+	}
+	nfsVol, err := cs.volumeByID(volumeID)
+	if err != nil {
+		return nil, err
+	}
+
+	volumeStatus := &csi.ControllerGetVolumeResponse_VolumeStatus{}
+	if s := cs.volumeStatus(volumeID); s != nil {
+		volumeStatus.PublishedNodeIds = s.PublishedNodeIds
+	}
+	if cs.driver.csiDriver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_VOLUME_CONDITION) == nil {
+		if volumeStatus.VolumeCondition, err = cs.volumeCondition(ctx, nfsVol); err != nil {
+			return nil, err
+		}
+	}
+	return &csi.ControllerGetVolumeResponse{
+		Volume: cs.nfsVolToCSI(nfsVol),
+		Status: volumeStatus,
+	}, nil
+}
+
+// volumeCondition probes the share and directory of vol. It only fails if
+// ctx ends before the probe finished.
+func (cs *controllerServer) volumeCondition(ctx context.Context, vol *nfsVolume) (*csi.VolumeCondition, error) {
+	err := cs.checkVolumeExists(ctx, vol)
+	share := fmt.Sprintf("%s:%s", vol.server, filepath.Join(string(filepath.Separator), vol.baseDir))
+	if ctx.Err() != nil {
+		return nil, toStatusError(ctx.Err())
+	}
+	switch status.Code(err) {
+	case codes.OK:
+		return &csi.VolumeCondition{Message: "volume is healthy"}, nil
+	case codes.NotFound:
+		return &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  fmt.Sprintf("directory %v of the volume is missing on %v", vol.subDir, share),
+		}, nil
+	default:
+		return &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  fmt.Sprintf("share %v of the volume is unavailable: %v", share, status.Convert(err).Message()),
+		}, nil
+	}
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/kubernetes/pkg/util/mount"
+)
+
+// downMounter is a fake mounter that fails to mount the shares of a
+// server that is down
+type downMounter struct {
+	*mount.FakeMounter
+	server string
+}
+
+func (m *downMounter) Mount(source string, target string, fstype string, options []string) error {
+	if strings.HasPrefix(source, m.server+":") {
+		return errors.New("mount.nfs: Connection timed out")
+	}
+	return m.FakeMounter.Mount(source, target, fstype, options)
+}
+
+func TestControllerGetVolume(t *testing.T) {
+	workDir := t.TempDir()
+	mounter := &downMounter{FakeMounter: &mount.FakeMounter{}, server: "192.0.2.99"}
+	cs := newTestControllerServer(WithWorkingMountDir(workDir), WithMounter(mounter))
+	cs.driver.ns = NewNodeServer(cs.driver)
+	vol := &nfsVolume{server: "192.0.2.10", baseDir: "export", subDir: "pvc-1", name: "pvc-1"}
+
+	// The fake mounter leaves the share at its mount path as it is
+	mountPath := filepath.Join(workDir, exportMountName(exportKey(vol)))
+	if err := os.MkdirAll(filepath.Join(mountPath, vol.subDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		volumeID string
+		abnormal bool
+		message  string
+		code     codes.Code
+	}{
+		{"healthy", cs.getVolumeIdFromNfsVol(vol), false, "volume is healthy", codes.OK},
+		{"shared base directory", "v2:192.0.2.10/export//shared", false, "volume is healthy", codes.OK},
+		{"missing directory", "v2:192.0.2.10/export/pvc-2/pvc-2", true, "directory pvc-2 of the volume is missing on 192.0.2.10:/export", codes.OK},
+		{"server down", "v2:192.0.2.99/export/pvc-1/pvc-1", true, "share 192.0.2.99:/export of the volume is unavailable", codes.OK},
+		{"no volume id", "", false, "", codes.InvalidArgument},
+		{"invalid volume id", "nfs/export/..", false, "", codes.NotFound},
+	}
+	for _, test := range tests {
+		resp, err := cs.ControllerGetVolume(context.Background(), &csi.ControllerGetVolumeRequest{VolumeId: test.volumeID})
+		if status.Code(err) != test.code {
+			t.Errorf("%s: ControllerGetVolume() = %v, want %v", test.name, err, test.code)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if resp.GetVolume().GetVolumeId() != test.volumeID {
+			t.Errorf("%s: ControllerGetVolume() returned volume %v", test.name, resp.GetVolume().GetVolumeId())
+		}
+		condition := resp.GetStatus().GetVolumeCondition()
+		if condition.GetAbnormal() != test.abnormal || !strings.HasPrefix(condition.GetMessage(), test.message) {
+			t.Errorf("%s: ControllerGetVolume() reported %+v, want abnormal %v with message %q", test.name, condition, test.abnormal, test.message)
+		}
+	}
+}
+
+func TestControllerGetVolumeCanceled(t *testing.T) {
+	cs := newTestControllerServer(WithWorkingMountDir(t.TempDir()))
+	cs.driver.ns = NewNodeServer(cs.driver)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := cs.ControllerGetVolume(ctx, &csi.ControllerGetVolumeRequest{VolumeId: "v2:192.0.2.10/export/pvc-1/pvc-1"})
+	if status.Code(err) != codes.Canceled {
+		t.Errorf("ControllerGetVolume() with a canceled context = %v, want Canceled", err)
+	}
+}
+
+func TestControllerGetVolumeWithoutCondition(t *testing.T) {
+	cs := newTestControllerServer(WithControllerCapabilities(csi.ControllerServiceCapability_RPC_GET_VOLUME))
+	resp, err := cs.ControllerGetVolume(context.Background(), &csi.ControllerGetVolumeRequest{VolumeId: "v2:192.0.2.99/export/pvc-1/pvc-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetStatus().GetVolumeCondition() != nil {
+		t.Errorf("ControllerGetVolume() without VOLUME_CONDITION reported %+v", resp.GetStatus().GetVolumeCondition())
+	}
+}
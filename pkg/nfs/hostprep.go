@@ -0,0 +1,138 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	"k8s.io/utils/exec"
+)
+
+const (
+	procSysPath   = "/proc/sys"
+	sysModulePath = "/sys/module"
+)
+
+// hostPreparer sets the sysctls and kernel module parameters the node
+// needs for nfs before the first mount, e.g. sunrpc.tcp_slot_table_entries
+// or nfs.callback_tcpport. Left at their defaults they often cause silent
+// performance cliffs instead of errors.
+type hostPreparer struct {
+	// sysctl name, e.g. "sunrpc.tcp_slot_table_entries", to value
+	sysctls map[string]string
+	// module.parameter, e.g. "nfs.callback_tcpport", to value
+	moduleParams map[string]string
+
+	mutex sync.Mutex
+	done  bool
+}
+
+// prepare applies the settings unless that already succeeded. It is
+// retried on every call until it succeeds.
+func (p *hostPreparer) prepare() error {
+	if len(p.sysctls) == 0 && len(p.moduleParams) == 0 {
+		return nil
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.done {
+		return nil
+	}
+
+	for _, name := range sortedKeys(p.sysctls) {
+		if err := setSysctl(name, p.sysctls[name]); err != nil {
+			return err
+		}
+	}
+	for _, name := range sortedKeys(p.moduleParams) {
+		if err := setModuleParam(name, p.moduleParams[name]); err != nil {
+			return err
+		}
+	}
+	p.done = true
+	return nil
+}
+
+func setSysctl(name, value string) error {
+	path := filepath.Join(procSysPath, strings.Replace(name, ".", "/", -1))
+	if err := writeKernelValue(path, value); err != nil {
+		return fmt.Errorf("failed to set sysctl %s=%s: %v", name, value, err)
+	}
+	glog.V(2).Infof("Set sysctl %s=%s", name, value)
+	return nil
+}
+
+// setModuleParam sets a parameter of a loaded module through sysfs. A
+// module that is not loaded yet is loaded with the parameter instead.
+func setModuleParam(name, value string) error {
+	parts := strings.SplitN(name, ".", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("invalid module parameter %q, must be module.parameter", name)
+	}
+	module, param := parts[0], parts[1]
+
+	if _, err := os.Stat(filepath.Join(sysModulePath, module)); os.IsNotExist(err) {
+		arg := fmt.Sprintf("%s=%s", param, value)
+		if out, err := exec.New().Command("modprobe", module, arg).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to load module %s with %s: %v, output: %s", module, arg, err, out)
+		}
+		glog.V(2).Infof("Loaded module %s with %s", module, arg)
+		return nil
+	}
+
+	path := filepath.Join(sysModulePath, module, "parameters", param)
+	if err := writeKernelValue(path, value); err != nil {
+		return fmt.Errorf("failed to set module parameter %s=%s: %v", name, value, err)
+	}
+	glog.V(2).Infof("Set module parameter %s=%s", name, value)
+	return nil
+}
+
+// writeKernelValue writes value to a /proc or /sys file and checks that
+// the kernel took it, since some values are silently clamped
+func writeKernelValue(path, value string) error {
+	if err := ioutil.WriteFile(path, []byte(value), 0644); err != nil {
+		if os.IsPermission(err) || os.IsNotExist(err) {
+			return fmt.Errorf("%v (is the driver privileged and the host allowing it?)", err)
+		}
+		return err
+	}
+	got, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(got)) != strings.TrimSpace(value) {
+		return fmt.Errorf("kernel reports %q after writing %q", strings.TrimSpace(string(got)), value)
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,226 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"golang.org/x/time/rate"
+)
+
+// TimeWindow is a daily period of local time, from Start to End after
+// midnight. A window that ends before it starts spans midnight.
+type TimeWindow struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// ParseTimeWindow parses a window such as "22:00-06:00". Windows that
+// start when they end are rejected, leaving out the windows flag allows
+// background work at any time.
+func ParseTimeWindow(s string) (TimeWindow, error) {
+	var startH, startM, endH, endM int
+	var rest string
+	n, _ := fmt.Sscanf(s, "%d:%d-%d:%d%s", &startH, &startM, &endH, &endM, &rest)
+	if n != 4 || startH > 23 || endH > 24 || startM > 59 || endM > 59 ||
+		startH < 0 || endH < 0 || startM < 0 || endM < 0 || (endH == 24 && endM != 0) {
+		return TimeWindow{}, fmt.Errorf("invalid time window %q, expected HH:MM-HH:MM", s)
+	}
+	w := TimeWindow{
+		Start: time.Duration(startH)*time.Hour + time.Duration(startM)*time.Minute,
+		End:   time.Duration(endH)*time.Hour + time.Duration(endM)*time.Minute,
+	}
+	if w.Start == w.End%(24*time.Hour) {
+		return TimeWindow{}, fmt.Errorf("time window %q is empty", s)
+	}
+	return w, nil
+}
+
+// until returns how long it is from t until the window opens, 0 if t is
+// within the window
+func (w TimeWindow) until(t time.Time) time.Duration {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	now := t.Sub(midnight)
+	if w.Start <= w.End {
+		if now >= w.Start && now < w.End {
+			return 0
+		}
+	} else if now >= w.Start || now < w.End {
+		return 0
+	}
+	if now < w.Start {
+		return w.Start - now
+	}
+	return 24*time.Hour - now + w.Start
+}
+
+// shareScheduler coordinates the background work of the driver on shares,
+// such as writing snapshot archives and pruning, so that it does not
+// compete with the applications using the same nfs server. Background
+// work on a share runs one job at a time, only within the configured
+// windows, and its IO is limited to a rate per share. IO outside of the
+// windows pauses until the next window opens, so a job that does not
+// finish within a window continues in the next one. Restores and clones
+// are waited for by claims, so they only share the IO rate of the share.
+type shareScheduler struct {
+	// Windows background work may run in, empty for any time
+	windows []TimeWindow
+	// Bytes per second per share, 0 for no limit
+	ioRate int64
+
+	mutex  sync.Mutex
+	shares map[string]*shareSlot
+}
+
+type shareSlot struct {
+	// Held while a job runs on the share
+	running chan struct{}
+	limiter *rate.Limiter
+}
+
+// schedulerShare returns the share of vol as known to the scheduler
+func schedulerShare(vol *nfsVolume) string {
+	return fmt.Sprintf("%s:%s", vol.server, filepath.Join(string(filepath.Separator), vol.baseDir))
+}
+
+func newShareScheduler(windows []TimeWindow, ioRate int64) *shareScheduler {
+	return &shareScheduler{
+		windows: windows,
+		ioRate:  ioRate,
+		shares:  map[string]*shareSlot{},
+	}
+}
+
+// run waits until background work may run on share and no other job runs
+// on it, then calls fn. fn must pass all reads and writes on the share
+// through the throttle it is given.
+func (s *shareScheduler) run(ctx context.Context, share, what string, fn func(throttle func(io.Writer) io.Writer) error) error {
+	slot := s.slot(share)
+	select {
+	case slot.running <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-slot.running }()
+	if err := s.waitForWindow(ctx, share, what); err != nil {
+		return err
+	}
+
+	return fn(func(w io.Writer) io.Writer {
+		return &throttledWriter{ctx: ctx, w: w, scheduler: s, share: share, what: what, limiter: slot.limiter, windows: true}
+	})
+}
+
+// rateLimit returns a throttle that limits writes to the IO rate of share,
+// shared with the background work on it, without waiting for a window or
+// for other jobs on the share
+func (s *shareScheduler) rateLimit(ctx context.Context, share string) func(io.Writer) io.Writer {
+	slot := s.slot(share)
+	return func(w io.Writer) io.Writer {
+		if slot.limiter == nil {
+			return w
+		}
+		return &throttledWriter{ctx: ctx, w: w, scheduler: s, share: share, limiter: slot.limiter}
+	}
+}
+
+// slot returns the slot of share
+func (s *shareScheduler) slot(share string) *shareSlot {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	slot, ok := s.shares[share]
+	if !ok {
+		slot = &shareSlot{running: make(chan struct{}, 1)}
+		if s.ioRate > 0 {
+			slot.limiter = rate.NewLimiter(rate.Limit(s.ioRate), int(s.ioRate))
+		}
+		s.shares[share] = slot
+	}
+	return slot
+}
+
+// waitForWindow blocks until the current time is within one of the
+// windows, or ctx is done
+func (s *shareScheduler) waitForWindow(ctx context.Context, share, what string) error {
+	if len(s.windows) == 0 {
+		return nil
+	}
+	now := time.Now()
+	wait := s.windows[0].until(now)
+	for _, w := range s.windows[1:] {
+		if d := w.until(now); d < wait {
+			wait = d
+		}
+	}
+	if wait == 0 {
+		return nil
+	}
+	glog.V(2).Infof("Waiting %v for the next background window to %s on %v", wait, what, share)
+	select {
+	case <-time.After(wait):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// throttledWriter limits the bytes written to w to the rate of the share
+// and pauses writes of background work outside of the windows of the
+// scheduler
+type throttledWriter struct {
+	ctx       context.Context
+	w         io.Writer
+	scheduler *shareScheduler
+	share     string
+	what      string
+	limiter   *rate.Limiter
+	// Pause outside of the windows
+	windows bool
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if t.windows {
+			if err := t.scheduler.waitForWindow(t.ctx, t.share, t.what); err != nil {
+				return written, err
+			}
+		}
+		chunk := p
+		if t.limiter != nil {
+			if len(chunk) > t.limiter.Burst() {
+				chunk = chunk[:t.limiter.Burst()]
+			}
+			if err := t.limiter.WaitN(t.ctx, len(chunk)); err != nil {
+				return written, err
+			}
+		}
+		n, err := t.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,204 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestParseTimeWindow(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    TimeWindow
+		wantErr bool
+	}{
+		{in: "09:00-17:30", want: TimeWindow{Start: 9 * time.Hour, End: 17*time.Hour + 30*time.Minute}},
+		{in: "22:00-06:00", want: TimeWindow{Start: 22 * time.Hour, End: 6 * time.Hour}},
+		{in: "23:59-00:01", want: TimeWindow{Start: 23*time.Hour + 59*time.Minute, End: time.Minute}},
+		{in: "00:00-24:00", wantErr: true},
+		{in: "20:00-24:00", want: TimeWindow{Start: 20 * time.Hour, End: 24 * time.Hour}},
+		{in: "1:5-2:7", want: TimeWindow{Start: time.Hour + 5*time.Minute, End: 2*time.Hour + 7*time.Minute}},
+		{in: "10:00-10:00", wantErr: true},
+		{in: "24:00-06:00", wantErr: true},
+		{in: "25:00-06:00", wantErr: true},
+		{in: "22:00-24:30", wantErr: true},
+		{in: "22:00-25:00", wantErr: true},
+		{in: "12:60-13:00", wantErr: true},
+		{in: "12:00-13:60", wantErr: true},
+		{in: "-1:00-06:00", wantErr: true},
+		{in: "22:00--06:00", wantErr: true},
+		{in: "22:00-06:00x", wantErr: true},
+		{in: "22:00-06:00,23:00-01:00", wantErr: true},
+		{in: "22:00", wantErr: true},
+		{in: "22-06", wantErr: true},
+		{in: "", wantErr: true},
+		{in: "night", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := ParseTimeWindow(test.in)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("ParseTimeWindow(%q) = %+v, want error", test.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseTimeWindow(%q) failed: %v", test.in, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseTimeWindow(%q) = %+v, want %+v", test.in, got, test.want)
+		}
+	}
+}
+
+func TestTimeWindowUntil(t *testing.T) {
+	at := func(hour, min, sec int) time.Time {
+		return time.Date(2019, time.March, 14, hour, min, sec, 0, time.UTC)
+	}
+	day := TimeWindow{Start: 9 * time.Hour, End: 17 * time.Hour}
+	night := TimeWindow{Start: 22 * time.Hour, End: 6 * time.Hour}
+	evening := TimeWindow{Start: 20 * time.Hour, End: 24 * time.Hour}
+	tests := []struct {
+		name   string
+		window TimeWindow
+		now    time.Time
+		want   time.Duration
+	}{
+		{"before day window", day, at(8, 0, 0), time.Hour},
+		{"at start of day window", day, at(9, 0, 0), 0},
+		{"within day window", day, at(12, 0, 0), 0},
+		{"at end of day window", day, at(17, 0, 0), 16 * time.Hour},
+		{"after day window", day, at(23, 0, 0), 10 * time.Hour},
+		{"at midnight before day window", day, at(0, 0, 0), 9 * time.Hour},
+		{"before night window", night, at(21, 30, 0), 30 * time.Minute},
+		{"at start of night window", night, at(22, 0, 0), 0},
+		{"within night window before midnight", night, at(23, 59, 59), 0},
+		{"at midnight within night window", night, at(0, 0, 0), 0},
+		{"within night window after midnight", night, at(5, 59, 59), 0},
+		{"at end of night window", night, at(6, 0, 0), 16 * time.Hour},
+		{"within evening window before midnight", evening, at(23, 59, 59), 0},
+		{"at midnight after evening window", evening, at(0, 0, 0), 20 * time.Hour},
+		{"one second before evening window", evening, at(19, 59, 59), time.Second},
+	}
+	for _, test := range tests {
+		if got := test.window.until(test.now); got != test.want {
+			t.Errorf("%s: until(%v) = %v, want %v", test.name, test.now.Format("15:04:05"), got, test.want)
+		}
+	}
+}
+
+func TestShareSchedulerRunsOneJobPerShare(t *testing.T) {
+	s := newShareScheduler(nil, 0)
+	ctx := context.Background()
+	running := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- s.run(ctx, "nfs:/export", "first", func(func(io.Writer) io.Writer) error {
+			close(running)
+			<-release
+			return nil
+		})
+	}()
+	<-running
+
+	// Another share is not held up
+	if err := s.run(ctx, "nfs:/other", "other share", func(func(io.Writer) io.Writer) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	// A second job on the share waits for the first one
+	waitCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	err := s.run(waitCtx, "nfs:/export", "second", func(func(io.Writer) io.Writer) error {
+		t.Error("second job ran while the first one was running")
+		return nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Errorf("second job returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShareSchedulerThrottle(t *testing.T) {
+	// 1 KiB per second with a burst of 1 KiB
+	s := newShareScheduler(nil, 1024)
+	var buf bytes.Buffer
+	start := time.Now()
+	err := s.run(context.Background(), "nfs:/export", "copy", func(throttle func(io.Writer) io.Writer) error {
+		_, err := throttle(&buf).Write(make([]byte, 1536))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 1536 {
+		t.Errorf("wrote %d bytes, want 1536", buf.Len())
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("1.5 KiB were written in %v at 1 KiB per second", elapsed)
+	}
+}
+
+func TestShareSchedulerRateLimit(t *testing.T) {
+	// A window that is never open now, and a job that holds the share
+	now := time.Now()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	start := (now.Sub(midnight) + 2*time.Hour) % (24 * time.Hour)
+	window := TimeWindow{Start: start, End: (start + time.Hour) % (24 * time.Hour)}
+	s := newShareScheduler([]TimeWindow{window}, 1024)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	holding := make(chan struct{})
+	go s.run(ctx, "nfs:/export", "archive", func(func(io.Writer) io.Writer) error {
+		close(holding)
+		return nil
+	})
+	select {
+	case <-holding:
+		t.Fatal("job ran outside of the window")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The job waits for the window while holding the share; restores
+	// neither wait for it nor for the window, but share the IO rate
+	var buf bytes.Buffer
+	begin := time.Now()
+	if _, err := s.rateLimit(context.Background(), "nfs:/export")(&buf).Write(make([]byte, 1536)); err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(begin)
+	if buf.Len() != 1536 {
+		t.Errorf("wrote %d bytes, want 1536", buf.Len())
+	}
+	if elapsed < 400*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("1.5 KiB were written in %v at 1 KiB per second", elapsed)
+	}
+
+	// Without a rate the writer is not wrapped
+	if w := newShareScheduler([]TimeWindow{window}, 0).rateLimit(context.Background(), "nfs:/export")(&buf); w != io.Writer(&buf) {
+		t.Errorf("rateLimit() without a rate wrapped the writer")
+	}
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/nfs/validation"
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/nfs/volume"
+)
+
+// ListSnapshots lists the snapshots of the shares given to --shares, or of the share of the requested snapshot or source
+// volume. Snapshots that are still being archived are listed as not ready
+// to use. Entries are ordered by snapshot id, and the token of the next
+// page is the position of its first entry.
+func (cs *controllerServer) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
+	if err := cs.driver.csiDriver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS); err != nil {
+		return nil, err
+	}
+	var shares []*nfsVolume
+	switch {
+	case req.GetSnapshotId() != "":
+		snap, err := volume.ParseSnapshotID(req.GetSnapshotId())
+		if err != nil {
+			// Not a snapshot of this driver
+			return &csi.ListSnapshotsResponse{}, nil
+		}
+		shares = append(shares, &nfsVolume{server: snap.Server, baseDir: snap.BaseDir})
+	case req.GetSourceVolumeId() != "":
+		vol, err := cs.getNfsVolFromId(req.GetSourceVolumeId())
+		if err != nil || vol.subDir == "" {
+			// No volume that can have snapshots
+			return &csi.ListSnapshotsResponse{}, nil
+		}
+		shares = append(shares, &nfsVolume{server: vol.server, baseDir: vol.baseDir})
+	default:
+		for _, share := range cs.driver.shares {
+			server, path, _ := volume.ParseMigratedID(share)
+			if s, err := validation.NormalizeServer(server); err == nil {
+				server = s
+			}
+			shares = append(shares, &nfsVolume{server: server, baseDir: strings.Trim(path, "/")})
+		}
+	}
+
+	var snapshots []*csi.Snapshot
+	for _, share := range shares {
+		found, err := cs.listSnapshotsOnShare(ctx, share)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, found...)
+	}
+	snapshots = mergeSnapshotJobs(snapshots, cs.snapshots.list())
+
+	var entries []*csi.ListSnapshotsResponse_Entry
+	for _, s := range snapshots {
+		if id := req.GetSnapshotId(); id != "" && s.SnapshotId != id {
+			continue
+		}
+		if id := req.GetSourceVolumeId(); id != "" && s.SourceVolumeId != id {
+			continue
+		}
+		entries = append(entries, &csi.ListSnapshotsResponse_Entry{Snapshot: s})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Snapshot.SnapshotId < entries[j].Snapshot.SnapshotId
+	})
+
+	start, end, next, err := page(len(entries), req.GetMaxEntries(), req.GetStartingToken())
+	if err != nil {
+		return nil, err
+	}
+	return &csi.ListSnapshotsResponse{Entries: entries[start:end], NextToken: next}, nil
+}
+
+// page returns the range of the page of total entries that starts at
+// token, with at most maxEntries entries if it is positive, and the token
+// of the next page. Tokens are positions in the list.
+func page(total int, maxEntries int32, token string) (start, end int, next string, err error) {
+	if maxEntries < 0 {
+		return 0, 0, "", status.Errorf(codes.InvalidArgument, "max_entries %d must not be negative", maxEntries)
+	}
+	if token != "" {
+		if start, err = strconv.Atoi(token); err != nil || start < 0 || start > total {
+			return 0, 0, "", status.Errorf(codes.Aborted, "invalid starting token %q for %d entries", token, total)
+		}
+	}
+	end = total
+	if maxEntries > 0 && end-start > int(maxEntries) {
+		end = start + int(maxEntries)
+		next = strconv.Itoa(end)
+	}
+	return start, end, next, nil
+}
+
+// listSnapshotsOnShare returns the complete snapshots in the snapshots
+// directory of the share of vol
+func (cs *controllerServer) listSnapshotsOnShare(ctx context.Context, vol *nfsVolume) ([]*csi.Snapshot, error) {
+	var snapshots []*csi.Snapshot
+	err := cs.exports.run(ctx, vol, func(mountPath string) error {
+		dir := filepath.Join(mountPath, cs.driver.snapshotsDir)
+		files, err := ioutil.ReadDir(dir)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to list snapshots: %v", err)
+		}
+		for _, f := range files {
+			if !strings.HasSuffix(f.Name(), snapshotInfoSuffix) {
+				continue
+			}
+			name := strings.TrimSuffix(f.Name(), snapshotInfoSuffix)
+			info, err := readSnapshotInfoFile(filepath.Join(dir, f.Name()))
+			if err != nil {
+				glog.Warningf("Skipping snapshot %v: %v", name, err)
+				continue
+			}
+			id := volume.NewSnapshotID(vol.server, vol.baseDir, cs.driver.snapshotsDir, name)
+			snapshot, err := csiSnapshot(id, info, true)
+			if err != nil {
+				glog.Warningf("Skipping snapshot %v: %v", name, err)
+				continue
+			}
+			snapshots = append(snapshots, snapshot)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+	return snapshots, nil
+}
+
+// mergeSnapshotJobs adds the snapshots that are still being archived to
+// the complete snapshots found on shares
+func mergeSnapshotJobs(snapshots []*csi.Snapshot, jobs []snapshotJob) []*csi.Snapshot {
+	known := map[string]bool{}
+	for _, s := range snapshots {
+		known[s.SnapshotId] = true
+	}
+	for _, job := range jobs {
+		if job.done || known[job.id] {
+			continue
+		}
+		snapshot, err := csiSnapshot(job.id, &snapshotInfo{SourceVolumeID: job.sourceVolumeID, CreationTime: job.createdAt}, false)
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/nfs/validation"
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/nfs/volume"
+)
+
+// ListVolumes lists the volume subdirectories of the shares given to
+// --shares and of the namespace directories in them, ordered by volume
+// id. Directories without volume metadata and hidden directories, such as
+// the snapshots and quarantine directories, are left out, and so are
+// volumes of other clusters if --cluster-id is set.
+func (cs *controllerServer) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
+	if err := cs.driver.csiDriver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_LIST_VOLUMES); err != nil {
+		return nil, err
+	}
+
+	var entries []*csi.ListVolumesResponse_Entry
+	for _, share := range cs.driver.shares {
+		server, path, _ := volume.ParseMigratedID(share)
+		if s, err := validation.NormalizeServer(server); err == nil {
+			server = s
+		}
+		vols, err := cs.listVolumesOnShare(ctx, &nfsVolume{server: server, baseDir: strings.Trim(path, "/")})
+		if err != nil {
+			return nil, err
+		}
+		for _, vol := range vols {
+			entries = append(entries, &csi.ListVolumesResponse_Entry{
+				Volume: cs.nfsVolToCSI(vol),
+				Status: cs.volumeStatus(vol.id),
+			})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Volume.VolumeId < entries[j].Volume.VolumeId
+	})
+
+	start, end, next, err := page(len(entries), req.GetMaxEntries(), req.GetStartingToken())
+	if err != nil {
+		return nil, err
+	}
+	return &csi.ListVolumesResponse{Entries: entries[start:end], NextToken: next}, nil
+}
+
+// listVolumesOnShare returns the volumes in the subdirectories of the
+// share of vol. Only directories with volume metadata are volumes of the
+// driver. Directories without are looked into once more if they have
+// volume records, which makes them namespace directories of StorageClasses
+// with namespaceDirs; other directories without metadata may be volumes
+// of older versions of the driver, whose data is not scanned.
+func (cs *controllerServer) listVolumesOnShare(ctx context.Context, share *nfsVolume) ([]*nfsVolume, error) {
+	var vols []*nfsVolume
+	err := cs.exports.run(ctx, share, func(mountPath string) error {
+		names, err := cs.volumeDirNames(mountPath)
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to list volumes: %v", err)
+		}
+		for _, name := range names {
+			md, err := readVolumeMetadata(filepath.Join(mountPath, name))
+			if err != nil {
+				glog.Warningf("Skipping volume %v: %v", name, err)
+				continue
+			}
+			if md != nil {
+				if vol := cs.listedVolume(share.server, share.baseDir, name, md); vol != nil {
+					vols = append(vols, vol)
+				}
+				continue
+			}
+
+			if _, err := os.Stat(filepath.Join(mountPath, name, volumeRecordsDir)); err != nil {
+				continue
+			}
+			subNames, err := cs.volumeDirNames(filepath.Join(mountPath, name))
+			if err != nil {
+				glog.Warningf("Skipping directory %v: %v", name, err)
+				continue
+			}
+			for _, subName := range subNames {
+				md, err := readVolumeMetadata(filepath.Join(mountPath, name, subName))
+				if err != nil {
+					glog.Warningf("Skipping volume %v/%v: %v", name, subName, err)
+					continue
+				}
+				if md == nil {
+					continue
+				}
+				if vol := cs.listedVolume(share.server, filepath.Join(share.baseDir, name), subName, md); vol != nil {
+					vols = append(vols, vol)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+	return vols, nil
+}
+
+// volumeDirNames returns the names of the subdirectories of dir that may
+// be volumes, leaving out hidden, archived, snapshots and quarantine
+// directories
+func (cs *controllerServer) volumeDirNames(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, f := range files {
+		name := f.Name()
+		if !f.IsDir() || strings.HasPrefix(name, ".") || strings.HasPrefix(name, archivePrefix) || name == cs.driver.snapshotsDir || name == cs.driver.quarantineDir {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// listedVolume returns the volume in subdirectory subDir of baseDir with
+// metadata md, or nil if it belongs to another cluster. The id is built
+// from where the volume was found. The id in the metadata only provides
+// the name and id version of the volume, and the volume is left out if
+// that id names another directory, since the metadata of a volume can be
+// copied or rewritten by pods that use it.
+func (cs *controllerServer) listedVolume(server, baseDir, subDir string, md *volumeMetadata) *nfsVolume {
+	if cs.driver.clusterID != "" && md.ClusterID != "" && md.ClusterID != cs.driver.clusterID {
+		return nil
+	}
+	vol := &nfsVolume{
+		server:  server,
+		baseDir: baseDir,
+		subDir:  subDir,
+		name:    subDir,
+	}
+	var stored *validation.VolumeID
+	if md.VolumeID != "" {
+		var err error
+		stored, err = validation.ParseVolumeID(md.VolumeID)
+		if err != nil || stored.Server != server || stored.BaseDir != strings.Trim(baseDir, "/") || stored.SubDir != subDir {
+			glog.Warningf("Skipping volume %v/%v: its metadata has the id %q of another volume", baseDir, subDir, md.VolumeID)
+			return nil
+		}
+		vol.name = stored.Name
+	}
+	vol.id = cs.getVolumeIdFromNfsVol(vol)
+	if stored != nil && stored.Version == 1 {
+		// Volumes created with version 1 ids keep the id of their
+		// PersistentVolume
+		vol.id = volume.NewID(server, baseDir, subDir)
+	}
+	return vol
+}
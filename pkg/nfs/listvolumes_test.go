@@ -0,0 +1,128 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestListVolumesOnShare(t *testing.T) {
+	workDir := t.TempDir()
+	cs := newTestControllerServer(WithWorkingMountDir(workDir))
+	cs.driver.ns = NewNodeServer(cs.driver)
+	share := &nfsVolume{server: "192.0.2.10", baseDir: "export"}
+
+	// The fake mounter leaves the share at its mount path as it is
+	mountPath := filepath.Join(workDir, exportMountName(exportKey(share)))
+	for _, dir := range []struct {
+		path     string
+		metadata *volumeMetadata
+		// Only write the metadata file, without a record in the parent
+		fileOnly bool
+	}{
+		{"pvc-1", &volumeMetadata{VolumeID: "v2:192.0.2.10/export/pvc-1/pvc-1"}, false},
+		{"pvc-2", &volumeMetadata{}, false},
+		{"pvc-5", &volumeMetadata{VolumeID: "192.0.2.10/export/pvc-5"}, false},
+		{"team-b-claim-1f2e3d4c", &volumeMetadata{VolumeID: "v2:192.0.2.10/export/team-b-claim-1f2e3d4c/pvc-6"}, false},
+		{"other-cluster", &volumeMetadata{ClusterID: "other"}, false},
+		{"copied", &volumeMetadata{VolumeID: "v2:192.0.2.10/export/pvc-1/pvc-1"}, false},
+		{"other-server", &volumeMetadata{VolumeID: "v2:192.0.2.99/export/other-server/other-server"}, false},
+		{"not-a-volume", nil, false},
+		{"legacy", nil, false},
+		{"legacy/data", &volumeMetadata{VolumeID: "v2:192.0.2.10/export%2Flegacy/data/data"}, true},
+		{"team-a", nil, false},
+		{"team-a/data", &volumeMetadata{VolumeID: "v2:192.0.2.10/export%2Fteam-a/data/data"}, false},
+		{"team-a/not-a-volume", nil, false},
+		{"team-a/deeper", nil, false},
+		{"team-a/deeper/pvc-3", &volumeMetadata{}, false},
+		{".snapshots", nil, false},
+		{".snapshots/pvc-4", &volumeMetadata{}, false},
+	} {
+		path := filepath.Join(mountPath, dir.path)
+		if err := os.MkdirAll(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case dir.metadata == nil:
+		case dir.fileOnly:
+			data, err := json.Marshal(dir.metadata)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(path, volumeMetadataFile), data, 0644); err != nil {
+				t.Fatal(err)
+			}
+		default:
+			if err := cs.writeVolumeMetadata(path, dir.metadata); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	cs.driver.clusterID = "test"
+
+	vols, err := cs.listVolumesOnShare(context.Background(), share)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]nfsVolume{}
+	for _, vol := range vols {
+		got[vol.id] = *vol
+	}
+	pvc2 := nfsVolume{server: "192.0.2.10", baseDir: "export", subDir: "pvc-2", name: "pvc-2"}
+	pvc2.id = cs.getVolumeIdFromNfsVol(&pvc2)
+	want := map[string]nfsVolume{
+		"v2:192.0.2.10/export/pvc-1/pvc-1": {
+			id:      "v2:192.0.2.10/export/pvc-1/pvc-1",
+			server:  "192.0.2.10",
+			baseDir: "export",
+			subDir:  "pvc-1",
+			name:    "pvc-1",
+		},
+		pvc2.id: pvc2,
+		"192.0.2.10/export/pvc-5": {
+			id:      "192.0.2.10/export/pvc-5",
+			server:  "192.0.2.10",
+			baseDir: "export",
+			subDir:  "pvc-5",
+			name:    "pvc-5",
+		},
+		"v2:192.0.2.10/export/team-b-claim-1f2e3d4c/pvc-6": {
+			id:      "v2:192.0.2.10/export/team-b-claim-1f2e3d4c/pvc-6",
+			server:  "192.0.2.10",
+			baseDir: "export",
+			subDir:  "team-b-claim-1f2e3d4c",
+			name:    "pvc-6",
+		},
+		"v2:192.0.2.10/export%2Fteam-a/data/data": {
+			id:      "v2:192.0.2.10/export%2Fteam-a/data/data",
+			server:  "192.0.2.10",
+			baseDir: "export/team-a",
+			subDir:  "data",
+			name:    "data",
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("listVolumesOnShare() = %+v, want %+v", got, want)
+	}
+}
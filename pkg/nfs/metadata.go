@@ -0,0 +1,274 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// volumeMetadataFile is written into the directory of every provisioned
+// volume and describes where the volume came from
+const volumeMetadataFile = ".csi-nfs.json"
+
+// volumeMetadata is the content of volumeMetadataFile
+type volumeMetadata struct {
+	// Cluster that provisioned the volume, see --cluster-id
+	ClusterID string `json:"clusterID,omitempty"`
+
+	// CreateVolume request the volume was provisioned for, to tell a
+	// retry from a conflicting request for the same name. Missing in the
+	// metadata of older versions.
+	Parameters    map[string]string `json:"parameters,omitempty"`
+	CapacityBytes int64             `json:"capacityBytes,omitempty"`
+	// "snapshot:{id}" or "volume:{id}" for restored and cloned volumes
+	ContentSource string `json:"contentSource,omitempty"`
+
+	// For admins of the filer to find the Kubernetes objects of a
+	// directory. The claim is only known if the external-provisioner runs
+	// with --extra-create-metadata.
+	PVName        string    `json:"pvName,omitempty"`
+	PVCNamespace  string    `json:"pvcNamespace,omitempty"`
+	PVCName       string    `json:"pvcName,omitempty"`
+	CreationTime  time.Time `json:"creationTime"`
+	DriverVersion string    `json:"driverVersion,omitempty"`
+
+	// ID the volume was created with, for ListVolumes to report the id
+	// of the PersistentVolume whatever the current id format
+	VolumeID string `json:"volumeID,omitempty"`
+
+	// Capacity the volume was expanded to by ControllerExpandVolume.
+	// CapacityBytes stays the one of the CreateVolume request, so that
+	// retries of the request still match.
+	ExpandedBytes int64 `json:"expandedBytes,omitempty"`
+
+	// Mount options set by ControllerModifyVolume, which the node plugins
+	// apply with a remount
+	MountOptions []string `json:"mountOptions,omitempty"`
+}
+
+// capacity returns the current capacity of the volume
+func (md *volumeMetadata) capacity() int64 {
+	if md.ExpandedBytes > md.CapacityBytes {
+		return md.ExpandedBytes
+	}
+	return md.CapacityBytes
+}
+
+// contentSourceString returns the ContentSource of volumeMetadata for
+// source
+func contentSourceString(source *csi.VolumeContentSource) string {
+	switch {
+	case source.GetSnapshot() != nil:
+		return "snapshot:" + source.GetSnapshot().GetSnapshotId()
+	case source.GetVolume() != nil:
+		return "volume:" + source.GetVolume().GetVolumeId()
+	}
+	return ""
+}
+
+// errIncompleteVolume is returned by checkExistingVolume for the directory
+// of a CreateVolume request that did not complete
+var errIncompleteVolume = errors.New("incomplete volume directory")
+
+// checkExistingVolume decides a CreateVolume request whose volume
+// directory dir exists already. It returns nil if the directory was
+// provisioned for the same request, so that the retry succeeds, and an
+// AlreadyExists error if it was provisioned for a different one.
+func checkExistingVolume(dir string, want *volumeMetadata) error {
+	md, err := readVolumeMetadata(dir)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to read metadata of existing volume: %v", err)
+	}
+	if md == nil {
+		// Restores and clones write the metadata after the content, so
+		// the content of a directory without metadata may be incomplete
+		if want.ContentSource != "" {
+			return errIncompleteVolume
+		}
+		// The metadata is written when the directory is set up, so an
+		// empty directory was left behind before that. Any other
+		// directory was not made by the driver and is left alone.
+		empty, err := isEmptyDir(dir)
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to read existing volume: %v", err)
+		}
+		if empty {
+			return errIncompleteVolume
+		}
+		return status.Errorf(codes.AlreadyExists, "directory %v exists without volume metadata", filepath.Base(dir))
+	}
+	if md.ClusterID != want.ClusterID {
+		return status.Errorf(codes.AlreadyExists, "volume %v was provisioned by cluster %q", filepath.Base(dir), md.ClusterID)
+	}
+	if md.Parameters == nil {
+		// Metadata of an older version, that only had the cluster
+		return nil
+	}
+	if !reflect.DeepEqual(md.Parameters, want.Parameters) || md.CapacityBytes != want.CapacityBytes || md.ContentSource != want.ContentSource {
+		return status.Errorf(codes.AlreadyExists, "volume %v exists with different parameters, capacity or content source", filepath.Base(dir))
+	}
+	return nil
+}
+
+// volumeRecordsDir is created next to the volume directories of a base
+// share and keeps a copy of the metadata of each volume that only the
+// provisioner can access. Pods that mount a volume can rewrite the
+// metadata file in it, so DeleteVolume only trusts the copy.
+const volumeRecordsDir = ".csi-nfs-volumes"
+
+// volumeRecordPath returns the path of the record of the volume directory
+// dir
+func volumeRecordPath(dir string) string {
+	return filepath.Join(filepath.Dir(dir), volumeRecordsDir, filepath.Base(dir)+".json")
+}
+
+// writeVolumeMetadata writes md into the volume directory dir and into
+// the record of the volume
+func (cs *controllerServer) writeVolumeMetadata(dir string, md *volumeMetadata) error {
+	data, err := json.Marshal(md)
+	if err != nil {
+		return err
+	}
+	return cs.runAsProvisioner(func() error {
+		if err := os.Mkdir(filepath.Join(filepath.Dir(dir), volumeRecordsDir), 0700); err != nil && !os.IsExist(err) {
+			return err
+		}
+		if err := ioutil.WriteFile(volumeRecordPath(dir), data, 0600); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(dir, volumeMetadataFile), data, 0644)
+	})
+}
+
+// readVolumeRecord reads the record of the volume directory dir. It
+// returns nil if the volume has none, e.g. because it was provisioned
+// before volumes had records.
+func readVolumeRecord(dir string) (*volumeMetadata, error) {
+	return parseVolumeMetadata(ioutil.ReadFile(volumeRecordPath(dir)))
+}
+
+// removeVolumeRecord removes the record of the volume directory dir once
+// the directory is gone
+func (cs *controllerServer) removeVolumeRecord(dir string) error {
+	return cs.runAsProvisioner(func() error {
+		if err := os.Remove(volumeRecordPath(dir)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	})
+}
+
+// readVolumeMetadata reads the metadata of the volume directory dir. It
+// returns nil if the volume has none, e.g. because it was provisioned by
+// an older version of the driver.
+func readVolumeMetadata(dir string) (*volumeMetadata, error) {
+	return parseVolumeMetadata(ioutil.ReadFile(filepath.Join(dir, volumeMetadataFile)))
+}
+
+// readTrustedMetadata reads the record of the volume directory dir, or
+// the metadata in it for volumes provisioned before volumes had records
+func readTrustedMetadata(dir string) (*volumeMetadata, error) {
+	md, err := readVolumeRecord(dir)
+	if err == nil && md == nil {
+		md, err = readVolumeMetadata(dir)
+	}
+	return md, err
+}
+
+// updateVolumeMetadata writes the metadata that update returns for the
+// current metadata of vol, which is nil for volumes without any. Nothing
+// is written if update returns nil.
+func (cs *controllerServer) updateVolumeMetadata(ctx context.Context, vol *nfsVolume, update func(*volumeMetadata) *volumeMetadata) error {
+	err := cs.exports.run(ctx, vol, func(mountPath string) error {
+		dir := filepath.Join(mountPath, vol.subDir)
+		if _, err := os.Stat(dir); err != nil {
+			if os.IsNotExist(err) {
+				return status.Errorf(codes.NotFound, "volume %v not found", vol.id)
+			}
+			return err
+		}
+		md, err := readTrustedMetadata(dir)
+		if err != nil {
+			return err
+		}
+		if md = update(md); md == nil {
+			return nil
+		}
+		return cs.writeVolumeMetadata(dir, md)
+	})
+	return toStatusError(err)
+}
+
+// parseVolumeMetadata parses the content of a metadata file or record,
+// as returned by ioutil.ReadFile. A missing file is no metadata.
+func parseVolumeMetadata(data []byte, err error) (*volumeMetadata, error) {
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	md := &volumeMetadata{}
+	if err := json.Unmarshal(data, md); err != nil {
+		return nil, err
+	}
+	return md, nil
+}
+
+// Prefix of the extended attributes that describe volume directories
+const xattrPrefix = "user.csi."
+
+// tagVolumeDir sets extended attributes on the volume directory dir that
+// describe the volume like its metadata, so that backup and chargeback
+// tools on the server can identify it without reading the metadata file.
+// Not every server supports extended attributes, so failures are only
+// logged.
+func (cs *controllerServer) tagVolumeDir(dir string, md *volumeMetadata) {
+	attrs := []struct{ name, value string }{
+		{"driver", driverName},
+		{"cluster-id", md.ClusterID},
+		{"pv-name", md.PVName},
+		{"pvc-namespace", md.PVCNamespace},
+		{"pvc-name", md.PVCName},
+	}
+	err := cs.runAsProvisioner(func() error {
+		for _, attr := range attrs {
+			if attr.value == "" {
+				continue
+			}
+			if err := setXattr(dir, xattrPrefix+attr.name, attr.value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		glog.Warningf("failed to set extended attributes on %v: %v", dir, err)
+	}
+}
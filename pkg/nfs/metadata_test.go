@@ -0,0 +1,76 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCheckExistingVolume(t *testing.T) {
+	cs := newTestControllerServer()
+	want := &volumeMetadata{
+		Parameters:    map[string]string{paramServer: "192.0.2.10", paramShare: "/export"},
+		CapacityBytes: 1024,
+	}
+	restore := &volumeMetadata{Parameters: want.Parameters, CapacityBytes: 1024, ContentSource: "snapshot:snap-1"}
+
+	for _, test := range []struct {
+		name     string
+		metadata *volumeMetadata
+		files    []string
+		want     *volumeMetadata
+		err      error
+		code     codes.Code
+	}{
+		{name: "retry", metadata: want, want: want},
+		{name: "other capacity", metadata: &volumeMetadata{Parameters: want.Parameters, CapacityBytes: 2048}, want: want, code: codes.AlreadyExists},
+		{name: "other cluster", metadata: &volumeMetadata{ClusterID: "other"}, want: want, code: codes.AlreadyExists},
+		{name: "interrupted before setup", want: want, err: errIncompleteVolume},
+		{name: "foreign directory", files: []string{"data"}, want: want, code: codes.AlreadyExists},
+		{name: "interrupted restore", files: []string{"data"}, want: restore, err: errIncompleteVolume},
+	} {
+		dir := filepath.Join(t.TempDir(), "pvc-1")
+		if err := os.Mkdir(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		if test.metadata != nil {
+			if err := cs.writeVolumeMetadata(dir, test.metadata); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, name := range test.files {
+			if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("data"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := checkExistingVolume(dir, test.want)
+		switch {
+		case test.err != nil:
+			if err != test.err {
+				t.Errorf("%s: checkExistingVolume() = %v, want %v", test.name, err, test.err)
+			}
+		case status.Code(err) != test.code:
+			t.Errorf("%s: checkExistingVolume() = %v, want %v", test.name, err, test.code)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"net/http"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "csi_nfs"
+
+var (
+	workingDirBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "working_mount_dir_bytes",
+		Help:      "Bytes stored on the local disk under the working mount directory, not counting mounted shares.",
+	})
+	workingDirEntries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "working_mount_dir_entries",
+		Help:      "Directories and files directly under the working mount directory that are not mount points.",
+	})
+	workingDirMounts = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "working_mount_dir_mounts",
+		Help:      "Shares mounted under the working mount directory.",
+	})
+	workingDirPruned = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "working_mount_dir_pruned_total",
+		Help:      "Leftover empty directories removed from the working mount directory.",
+	})
+	volumePublishes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "volume_publishes",
+		Help:      "Target paths a volume is published to on this node.",
+	}, []string{"volume_id"})
+	serverReachable = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "server_reachable",
+		Help:      "Whether the nfs port of a server accepted a connection during the warm-up of the node plugin.",
+	}, []string{"server"})
+	restoreProgress = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "restore_progress_ratio",
+		Help:      "Share of the snapshot archive read by a running restore of a volume.",
+	}, []string{"volume_id"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		workingDirBytes,
+		workingDirEntries,
+		workingDirMounts,
+		workingDirPruned,
+		volumePublishes,
+		serverReachable,
+		restoreProgress,
+	)
+}
+
+// serveMetrics serves the prometheus metrics at /metrics and support
+// bundles at /debug/support-bundle on address in the background
+func serveMetrics(address string, supportBundle http.HandlerFunc) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", prometheus.Handler())
+	mux.HandleFunc("/debug/support-bundle", supportBundle)
+	server := &http.Server{Addr: address, Handler: mux}
+	glog.Infof("Serving metrics on %s", address)
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			glog.Fatalf("Failed to serve metrics: %v", err)
+		}
+	}()
+	return server
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/nfs/validation"
+)
+
+// ControllerModifyVolume changes the mutable parameters of a volume. The
+// only one is mountOptions, a comma separated list of the mount options
+// that a remount can change. The volume context of a PersistentVolume is
+// never updated, so the options are recorded in the metadata of the
+// volume, and node plugins apply them to the mounts of the volume when it
+// is published again.
+func (cs *controllerServer) ControllerModifyVolume(ctx context.Context, req *csi.ControllerModifyVolumeRequest) (*csi.ControllerModifyVolumeResponse, error) {
+	if err := cs.driver.csiDriver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_MODIFY_VOLUME); err != nil {
+		return nil, err
+	}
+
+	volumeID := req.GetVolumeId()
+	if volumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id is empty")
+	}
+	var options []string
+	for k, v := range req.GetMutableParameters() {
+		if strings.ToLower(k) != validation.ParamMountOptions {
+			return nil, status.Errorf(codes.InvalidArgument, "parameter %q cannot be modified", k)
+		}
+		mo, err := parseMutableMountOptions(v)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		options = mo
+	}
+
+	nfsVol, err := cs.volumeByID(volumeID)
+	if err != nil {
+		return nil, err
+	}
+	if nfsVol.subDir == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "volume %v has no directory of its own to record the modification in", volumeID)
+	}
+	err = cs.updateVolumeMetadata(ctx, nfsVol, func(md *volumeMetadata) *volumeMetadata {
+		if md == nil {
+			md = &volumeMetadata{VolumeID: volumeID}
+		}
+		md.MountOptions = options
+		return md
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	glog.V(4).Infof("Volume %v modified to mount options %v", volumeID, options)
+	return &csi.ControllerModifyVolumeResponse{}, nil
+}
+
+// modifiedMountOptions returns the mount options that ControllerModifyVolume
+// recorded for the volume mounted at target. Pods can write the metadata
+// too, so options that a remount cannot change are dropped.
+func (ns *nodeServer) modifiedMountOptions(target string) []string {
+	md, err := readVolumeMetadata(target)
+	if err != nil {
+		glog.Warningf("Failed to read the metadata of the volume at %v: %v", target, err)
+		return nil
+	}
+	if md == nil || len(md.MountOptions) == 0 {
+		return nil
+	}
+	options, err := parseMutableMountOptions(strings.Join(md.MountOptions, ","))
+	if err != nil {
+		glog.Warningf("Ignoring the modified mount options of the volume at %v: %v", target, err)
+		return nil
+	}
+	return options
+}
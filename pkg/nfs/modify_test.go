@@ -0,0 +1,165 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/kubernetes/pkg/util/mount"
+
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/nfs/volume"
+)
+
+func TestControllerModifyVolume(t *testing.T) {
+	workDir := t.TempDir()
+	cs := newTestControllerServer(WithWorkingMountDir(workDir))
+	cs.driver.ns = NewNodeServer(cs.driver)
+	share := &nfsVolume{server: "192.0.2.10", baseDir: "export"}
+	// The fake mounter leaves the share at its mount path as it is
+	mountPath := filepath.Join(workDir, exportMountName(exportKey(share)))
+	if err := os.MkdirAll(mountPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:               "pvc-1",
+		Parameters:         map[string]string{paramServer: "192.0.2.10", paramShare: "/export"},
+		VolumeCapabilities: []*csi.VolumeCapability{testMountCapability},
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 1 << 30},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	volumeID := resp.GetVolume().GetVolumeId()
+	dir := filepath.Join(mountPath, "pvc-1")
+
+	tests := []struct {
+		name     string
+		volumeID string
+		params   map[string]string
+		want     []string
+		code     codes.Code
+	}{
+		{"mount options", volumeID, map[string]string{"mountOptions": "noatime,nodiratime"}, []string{"noatime", "nodiratime"}, codes.OK},
+		{"cleared", volumeID, map[string]string{"mountOptions": ""}, nil, codes.OK},
+		{"not remountable", volumeID, map[string]string{"mountOptions": "hard"}, nil, codes.InvalidArgument},
+		{"read-only", volumeID, map[string]string{"mountOptions": "ro"}, nil, codes.InvalidArgument},
+		{"not allowed", volumeID, map[string]string{"mountOptions": "mounthost=x"}, nil, codes.InvalidArgument},
+		{"immutable parameter", volumeID, map[string]string{paramServer: "192.0.2.11"}, nil, codes.InvalidArgument},
+		{"no volume id", "", map[string]string{"mountOptions": "noatime"}, nil, codes.InvalidArgument},
+		{"missing volume", "v2:192.0.2.10/export/pvc-2/pvc-2", map[string]string{"mountOptions": "noatime"}, nil, codes.NotFound},
+	}
+	for _, test := range tests {
+		_, err := cs.ControllerModifyVolume(context.Background(), &csi.ControllerModifyVolumeRequest{
+			VolumeId:          test.volumeID,
+			MutableParameters: test.params,
+		})
+		if status.Code(err) != test.code {
+			t.Errorf("%s: ControllerModifyVolume() = %v, want %v", test.name, err, test.code)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		for _, read := range []func(string) (*volumeMetadata, error){readVolumeMetadata, readVolumeRecord} {
+			md, err := read(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(md.MountOptions, test.want) {
+				t.Errorf("%s: metadata has mount options %v, want %v", test.name, md.MountOptions, test.want)
+			}
+			// The rest of the metadata is kept
+			if md.CapacityBytes != 1<<30 {
+				t.Errorf("%s: metadata has a capacity of %d, want %d", test.name, md.CapacityBytes, 1<<30)
+			}
+		}
+	}
+}
+
+func TestNodePublishVolumeModified(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		metadata string
+		readonly bool
+		want     [][]string
+	}{
+		{
+			name: "no metadata",
+			want: [][]string{{"nfsvers=4.1"}},
+		},
+		{
+			name:     "modified",
+			metadata: `{"mountOptions":["noatime"]}`,
+			want:     [][]string{{"nfsvers=4.1"}, {"remount", "noatime"}},
+		},
+		{
+			// Pods can write the metadata, and must not get a read-only
+			// volume written
+			name:     "written by a pod",
+			metadata: `{"mountOptions":["rw","noatime"]}`,
+			readonly: true,
+			want:     [][]string{{"nfsvers=4.1", "ro"}},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			mounter := &optionsMounter{FakeMounter: &mount.FakeMounter{}}
+			ns := NewNodeServer(New(WithNodeID("test"), WithMounter(mounter)))
+			// The fake mounter leaves the target as it is, so the metadata
+			// is visible at the target like in the mounted volume
+			targetPath := filepath.Join(t.TempDir(), "target")
+			if err := os.MkdirAll(targetPath, 0750); err != nil {
+				t.Fatal(err)
+			}
+			if test.metadata != "" {
+				if err := ioutil.WriteFile(filepath.Join(targetPath, volumeMetadataFile), []byte(test.metadata), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			_, err := ns.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:   "v2:192.0.2.10/export/pvc-1/pvc-1",
+				TargetPath: targetPath,
+				VolumeContext: map[string]string{
+					volume.ContextServer: "192.0.2.10",
+					volume.ContextShare:  "/export/pvc-1",
+				},
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{MountFlags: []string{"nfsvers=4.1"}},
+					},
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+					},
+				},
+				Readonly: test.readonly,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(mounter.options, test.want) {
+				t.Errorf("NodePublishVolume() mounted with %v, want %v", mounter.options, test.want)
+			}
+		})
+	}
+}
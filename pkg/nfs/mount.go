@@ -0,0 +1,69 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"fmt"
+
+	"golang.org/x/net/context"
+	"k8s.io/kubernetes/pkg/util/mount"
+)
+
+// Mounter backends that can be selected by name
+const (
+	// Kernel nfs client, through the mount command of the host
+	MounterKernel = "kernel"
+	// Userspace nfs client, through fuse-nfs
+	MounterFUSE = "fuse"
+)
+
+// NewMounter returns the mounter backend with the given name, for use as
+// DriverOptions.Mounter.
+func NewMounter(backend string) (mount.Interface, error) {
+	switch backend {
+	case MounterKernel, "":
+		return newSystemMounter(), nil
+	case MounterFUSE:
+		return newFUSEMounter(), nil
+	}
+	return nil, fmt.Errorf("unknown mounter %q, must be %q or %q", backend, MounterKernel, MounterFUSE)
+}
+
+// contextMounter is implemented by mounters that can abort a mount when
+// the context of the request is done.
+type contextMounter interface {
+	MountContext(ctx context.Context, source, target, fstype string, options []string) error
+}
+
+// systemMounter mounts with the mount command of the host and kills the
+// mount helper (e.g. mount.nfs) when the request is abandoned, so that it
+// cannot complete the mount behind the back of a retry.
+type systemMounter struct {
+	mount.Interface
+}
+
+func newSystemMounter() *systemMounter {
+	return &systemMounter{Interface: mount.New("")}
+}
+
+// mountWithContext mounts with m, honouring ctx if m supports it
+func mountWithContext(ctx context.Context, m mount.Interface, source, target, fstype string, options []string) error {
+	if cm, ok := m.(contextMounter); ok {
+		return cm.MountContext(ctx, source, target, fstype, options)
+	}
+	return m.Mount(source, target, fstype, options)
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strings"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"k8s.io/kubernetes/pkg/util/mount"
+)
+
+// fuseMounter mounts nfs shares with the userspace client fuse-nfs
+// (libnfs), for nodes without the kernel nfs client. Mounted shares are
+// listed, checked and unmounted like kernel mounts.
+type fuseMounter struct {
+	mount.Interface
+}
+
+func newFUSEMounter() *fuseMounter {
+	return &fuseMounter{Interface: mount.New("")}
+}
+
+func (m *fuseMounter) Mount(source, target, fstype string, options []string) error {
+	if hasMountOption(options, "remount") {
+		return m.Interface.Mount(source, target, fstype, options)
+	}
+	return m.MountContext(context.Background(), source, target, fstype, options)
+}
+
+// MountContext runs fuse-nfs, which returns once the share is mounted
+func (m *fuseMounter) MountContext(ctx context.Context, source, target, fstype string, options []string) error {
+	share, err := fuseShareURL(source, options)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"-n", share, "-m", target}
+	glog.V(4).Infof("Mounting cmd (fuse-nfs) with arguments (%s)", args)
+	output, err := exec.CommandContext(ctx, "fuse-nfs", args...).CombinedOutput()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("mount failed: %v\nMounting command: fuse-nfs\nMounting arguments: %s\nOutput: %s", err, strings.Join(args, " "), string(output))
+	}
+	return nil
+}
+
+// fuseShareURL returns the libnfs URL of the host:/path or [ipv6]:/path
+// source, with the mount options fuse-nfs supports
+func fuseShareURL(source string, options []string) (string, error) {
+	var host, path string
+	if strings.HasPrefix(source, "[") {
+		i := strings.Index(source, "]:")
+		if i < 0 {
+			return "", fmt.Errorf("invalid nfs source %q", source)
+		}
+		host, path = source[:i+1], source[i+2:]
+	} else {
+		parts := strings.SplitN(source, ":", 2)
+		if len(parts) != 2 {
+			return "", fmt.Errorf("invalid nfs source %q", source)
+		}
+		host, path = parts[0], parts[1]
+	}
+	share := &url.URL{
+		Scheme: "nfs",
+		Host:   host,
+		Path:   path,
+	}
+	query := url.Values{}
+	for _, o := range options {
+		kv := strings.SplitN(o, "=", 2)
+		switch {
+		case len(kv) == 2 && (kv[0] == "nfsvers" || kv[0] == "vers"):
+			query.Set("version", kv[1])
+		case len(kv) == 2 && kv[0] == "port":
+			// libnfs asks the portmapper unless the ports are given
+			query.Set("nfsport", kv[1])
+		case len(kv) == 2 && kv[0] == "mountport":
+			query.Set("mountport", kv[1])
+		case o == "ro":
+			// fuse-nfs cannot enforce it, so refuse rather than
+			// mounting writable
+			return "", fmt.Errorf("read-only mounts are not supported by the %s mounter: invalid argument", MounterFUSE)
+		default:
+			glog.Warningf("Ignoring mount option %q not supported by the %s mounter", o, MounterFUSE)
+		}
+	}
+	share.RawQuery = query.Encode()
+	return share.String(), nil
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"testing"
+)
+
+func TestFUSEShareURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		options []string
+		want    string
+		wantErr bool
+	}{
+		{"host", "nfs.example.com:/export/data", nil, "nfs://nfs.example.com/export/data", false},
+		{"ipv4", "192.0.2.10:/export", []string{"nfsvers=3"}, "nfs://192.0.2.10/export?version=3", false},
+		{"ipv6", "[fd00::1]:/export/data", nil, "nfs://[fd00::1]/export/data", false},
+		{"ipv6 with port", "[fd00::1]:/export", []string{"port=2050"}, "nfs://[fd00::1]/export?nfsport=2050", false},
+		{"ports", "nfs.example.com:/export", []string{"port=2049", "mountport=20048", "vers=3"}, "nfs://nfs.example.com/export?mountport=20048&nfsport=2049&version=3", false},
+		{"ignored options", "nfs.example.com:/export", []string{"hard", "timeo=600"}, "nfs://nfs.example.com/export", false},
+		{"read-only", "nfs.example.com:/export", []string{"ro"}, "", true},
+		{"no path", "nfs.example.com", nil, "", true},
+		{"unterminated ipv6", "[fd00::1:/export", nil, "", true},
+	}
+	for _, test := range tests {
+		got, err := fuseShareURL(test.source, test.options)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: fuseShareURL() = %v, want error %v", test.name, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: fuseShareURL() = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
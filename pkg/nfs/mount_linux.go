@@ -0,0 +1,73 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+)
+
+// MountContext runs "mount" in its own process group and kills the whole
+// group, including the helper started by mount, once ctx is done.
+func (m *systemMounter) MountContext(ctx context.Context, source, target, fstype string, options []string) error {
+	args := []string{}
+	if fstype != "" {
+		args = append(args, "-t", fstype)
+	}
+	if len(options) > 0 {
+		args = append(args, "-o", strings.Join(options, ","))
+	}
+	args = append(args, source, target)
+
+	glog.V(4).Infof("Mounting cmd (mount) with arguments (%s)", args)
+	var output bytes.Buffer
+	cmd := exec.Command("mount", args...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("mount failed: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		glog.Warningf("Killing mount of %s to %s: %v", source, target, ctx.Err())
+		// The negative pid addresses the process group
+		if killErr := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); killErr != nil {
+			glog.Warningf("failed to kill mount process group %d: %v", cmd.Process.Pid, killErr)
+		}
+		<-done
+		return ctx.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("mount failed: %v\nMounting command: mount\nMounting arguments: %s\nOutput: %s", err, strings.Join(args, " "), output.String())
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc/status"
+)
+
+// serverLimiters limit the rate of node mounts per nfs server with a
+// token bucket each. A node that schedules hundreds of pods against one
+// server at once would otherwise hit it with a storm of MOUNT or
+// EXCHANGE_ID calls. Mounts above the rate wait for their turn, with some
+// jitter so that the waiting mounts do not all fire at once.
+type serverLimiters struct {
+	// Mounts per second per server, 0 for no limit
+	limit rate.Limit
+	burst int
+
+	mutex    sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+func newServerLimiters(mountsPerSecond float64, burst int) *serverLimiters {
+	if burst < 1 {
+		burst = 1
+	}
+	return &serverLimiters{
+		limit:    rate.Limit(mountsPerSecond),
+		burst:    burst,
+		limiters: map[string]*rate.Limiter{},
+	}
+}
+
+// wait blocks until a mount of server is allowed or ctx is done
+func (l *serverLimiters) wait(ctx context.Context, server string) error {
+	if l.limit <= 0 {
+		return nil
+	}
+	l.mutex.Lock()
+	limiter, ok := l.limiters[server]
+	if !ok {
+		limiter = rate.NewLimiter(l.limit, l.burst)
+		l.limiters[server] = limiter
+	}
+	l.mutex.Unlock()
+
+	r := limiter.Reserve()
+	delay := r.Delay()
+	if delay == 0 {
+		return nil
+	}
+	// Up to the interval between two mounts
+	if jitter := int64(float64(time.Second) / float64(l.limit)); jitter > 0 {
+		delay += time.Duration(rand.Int63n(jitter))
+	}
+	glog.V(4).Infof("Delaying mount of nfs server %v by %v", server, delay)
+	select {
+	case <-time.After(delay):
+		return nil
+	case <-ctx.Done():
+		r.Cancel()
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const mountStatsFile = "/proc/self/mountstats"
+
+// nfsOpStats are the per-operation statistics of an nfs mount
+type nfsOpStats struct {
+	ops      uint64
+	trans    uint64
+	timeouts uint64
+	// Cumulative round trip time in milliseconds
+	rttMs uint64
+}
+
+// nfsMountStats are the nfs client statistics of one mount
+type nfsMountStats struct {
+	readBytes  uint64
+	writeBytes uint64
+	ops        map[string]nfsOpStats
+}
+
+// parseMountStats parses the nfs mounts in a mountstats file, keyed by
+// mount point
+func parseMountStats(r io.Reader) (map[string]*nfsMountStats, error) {
+	result := map[string]*nfsMountStats{}
+	var current *nfsMountStats
+	inOps := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		// device <source> mounted on <mount point> with fstype <type> ...
+		if fields[0] == "device" {
+			current = nil
+			inOps = false
+			// Not nfsd, the nfs server, or other file systems starting in nfs
+			if len(fields) >= 8 && fields[2] == "mounted" && (fields[7] == "nfs" || fields[7] == "nfs4") {
+				current = &nfsMountStats{ops: map[string]nfsOpStats{}}
+				result[strings.Replace(fields[4], "\\040", " ", -1)] = current
+			}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		switch {
+		case fields[0] == "bytes:" && len(fields) >= 7:
+			// normal read/write, direct read/write, server read/write
+			current.readBytes = parseUint(fields[5])
+			current.writeBytes = parseUint(fields[6])
+		case fields[0] == "per-op":
+			inOps = true
+		case inOps && strings.HasSuffix(fields[0], ":") && len(fields) >= 8:
+			// ops, transmissions, timeouts, bytes sent, bytes received,
+			// queue time, rtt, execute time
+			current.ops[strings.TrimSuffix(fields[0], ":")] = nfsOpStats{
+				ops:      parseUint(fields[1]),
+				trans:    parseUint(fields[2]),
+				timeouts: parseUint(fields[3]),
+				rttMs:    parseUint(fields[7]),
+			}
+		}
+	}
+	return result, scanner.Err()
+}
+
+func parseUint(s string) uint64 {
+	n, _ := strconv.ParseUint(s, 10, 64)
+	return n
+}
+
+var (
+	mountOpsDesc = prometheus.NewDesc(metricsNamespace+"_volume_ops_total",
+		"NFS operations of a published volume on this node.",
+		[]string{"volume_id", "op"}, nil)
+	mountRetransDesc = prometheus.NewDesc(metricsNamespace+"_volume_retransmissions_total",
+		"Retransmitted NFS operations of a published volume on this node.",
+		[]string{"volume_id", "op"}, nil)
+	mountTimeoutsDesc = prometheus.NewDesc(metricsNamespace+"_volume_timeouts_total",
+		"Timed out NFS operations of a published volume on this node.",
+		[]string{"volume_id", "op"}, nil)
+	mountRTTDesc = prometheus.NewDesc(metricsNamespace+"_volume_rtt_seconds_total",
+		"Cumulative round trip time of the NFS operations of a published volume on this node.",
+		[]string{"volume_id", "op"}, nil)
+	mountReadBytesDesc = prometheus.NewDesc(metricsNamespace+"_volume_read_bytes_total",
+		"Bytes read from the NFS server for a published volume on this node.",
+		[]string{"volume_id"}, nil)
+	mountWriteBytesDesc = prometheus.NewDesc(metricsNamespace+"_volume_write_bytes_total",
+		"Bytes written to the NFS server for a published volume on this node.",
+		[]string{"volume_id"}, nil)
+)
+
+// mountStatsCollector reports the nfs client statistics of the published
+// volumes, read from mountStatsFile on every scrape. All targets of a
+// volume share the statistics of the same nfs superblock, so every volume
+// is reported once.
+type mountStatsCollector struct {
+	publishes *publishTracker
+}
+
+func (c *mountStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- mountOpsDesc
+	ch <- mountRetransDesc
+	ch <- mountTimeoutsDesc
+	ch <- mountRTTDesc
+	ch <- mountReadBytesDesc
+	ch <- mountWriteBytesDesc
+}
+
+func (c *mountStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	f, err := os.Open(mountStatsFile)
+	if err != nil {
+		glog.Warningf("failed to read nfs mount statistics: %v", err)
+		return
+	}
+	defer f.Close()
+	stats, err := parseMountStats(f)
+	if err != nil {
+		glog.Warningf("failed to parse nfs mount statistics: %v", err)
+		return
+	}
+
+	reported := map[string]bool{}
+	for target, volumeID := range c.publishes.volumesByTarget() {
+		s, ok := stats[target]
+		if !ok || reported[volumeID] {
+			continue
+		}
+		reported[volumeID] = true
+		ch <- prometheus.MustNewConstMetric(mountReadBytesDesc, prometheus.CounterValue, float64(s.readBytes), volumeID)
+		ch <- prometheus.MustNewConstMetric(mountWriteBytesDesc, prometheus.CounterValue, float64(s.writeBytes), volumeID)
+		for op, o := range s.ops {
+			if o.ops == 0 {
+				continue
+			}
+			retrans := float64(0)
+			if o.trans > o.ops {
+				retrans = float64(o.trans - o.ops)
+			}
+			ch <- prometheus.MustNewConstMetric(mountOpsDesc, prometheus.CounterValue, float64(o.ops), volumeID, op)
+			ch <- prometheus.MustNewConstMetric(mountRetransDesc, prometheus.CounterValue, retrans, volumeID, op)
+			ch <- prometheus.MustNewConstMetric(mountTimeoutsDesc, prometheus.CounterValue, float64(o.timeouts), volumeID, op)
+			ch <- prometheus.MustNewConstMetric(mountRTTDesc, prometheus.CounterValue, float64(o.rttMs)/1000, volumeID, op)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMountStats(t *testing.T) {
+	// In the format of the kernel, with an nfs v3, v4.1 and v4.0 mount,
+	// one without statistics yet and mounts of other file systems
+	f, err := os.Open("testdata/mountstats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got, err := parseMountStats(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]*nfsMountStats{
+		"/var/lib/kubelet/pods/8a3c/volumes/kubernetes.io~csi/pvc-1/mount": {
+			readBytes:  1610678272,
+			writeBytes: 536936448,
+			ops: map[string]nfsOpStats{
+				"NULL":    {ops: 1, trans: 1},
+				"GETATTR": {ops: 2041, trans: 2041, rttMs: 1371},
+				"SETATTR": {ops: 27, trans: 27, rttMs: 35},
+				"LOOKUP":  {ops: 1843, trans: 1843, rttMs: 1544},
+				"ACCESS":  {ops: 1211, trans: 1211, rttMs: 893},
+				"READ":    {ops: 24577, trans: 24581, timeouts: 2, rttMs: 183722},
+				"WRITE":   {ops: 8193, trans: 8193, rttMs: 91422},
+				"CREATE":  {ops: 27, trans: 27, rttMs: 42},
+				"REMOVE":  {},
+				"COMMIT":  {ops: 4, trans: 4, rttMs: 612},
+			},
+		},
+		"/var/lib/kubelet/pods/9b4d/volumes/kubernetes.io~csi/team a/mount": {
+			readBytes:  7344128,
+			writeBytes: 3149824,
+			ops: map[string]nfsOpStats{
+				"NULL":                 {ops: 1, trans: 1},
+				"READ":                 {ops: 112, trans: 112, rttMs: 1420},
+				"WRITE":                {ops: 48, trans: 49, timeouts: 1, rttMs: 2231},
+				"COMMIT":               {ops: 3, trans: 3, rttMs: 18},
+				"OPEN":                 {ops: 57, trans: 57, rttMs: 104},
+				"OPEN_CONFIRM":         {},
+				"CLOSE":                {ops: 56, trans: 56, rttMs: 51},
+				"GETATTR":              {ops: 122, trans: 122, rttMs: 97},
+				"BIND_CONN_TO_SESSION": {},
+				"SEQUENCE":             {ops: 602, trans: 602, rttMs: 498},
+			},
+		},
+		"/mnt/v40": {
+			readBytes:  1024,
+			writeBytes: 2048,
+			ops: map[string]nfsOpStats{
+				"NULL":  {},
+				"READ":  {ops: 1, trans: 1, rttMs: 3},
+				"WRITE": {ops: 1, trans: 3, timeouts: 2, rttMs: 2512},
+			},
+		},
+		"/mnt/fresh": {ops: map[string]nfsOpStats{}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		for mountPoint, stats := range got {
+			if !reflect.DeepEqual(stats, want[mountPoint]) {
+				t.Errorf("stats of %q = %+v, want %+v", mountPoint, stats, want[mountPoint])
+			}
+		}
+		for mountPoint := range want {
+			if _, ok := got[mountPoint]; !ok {
+				t.Errorf("no stats of %q", mountPoint)
+			}
+		}
+	}
+}
+
+func TestParseMountStatsOfHost(t *testing.T) {
+	f, err := os.Open(mountStatsFile)
+	if err != nil {
+		t.Skipf("no mount statistics: %v", err)
+	}
+	defer f.Close()
+	if _, err := parseMountStats(f); err != nil {
+		t.Errorf("failed to parse %v: %v", mountStatsFile, err)
+	}
+}
+
+func TestParseMountStatsNonNFS(t *testing.T) {
+	stats := `device nfsd mounted on /proc/fs/nfsd with fstype nfsd
+device sunrpc mounted on /run/rpc_pipefs with fstype rpc_pipefs
+device /dev/sda1 mounted on / with fstype ext4
+	bytes:	1 2 3 4 5 6 7 8
+	per-op statistics
+	        READ: 1 1 0 176 1148 0 3 3 0
+`
+	got, err := parseMountStats(strings.NewReader(stats))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("parseMountStats() = %v, want no nfs mounts", got)
+	}
+}
@@ -18,26 +18,61 @@ package nfs
 
 import (
 	"fmt"
+	"net"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/glog"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"k8s.io/kubernetes/pkg/util/mount"
 	"k8s.io/kubernetes/pkg/volume/util"
 
-	"github.com/kubernetes-csi/drivers/pkg/csi-common"
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/csi-common"
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/nfs/validation"
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/nfs/volume"
 )
 
 type nodeServer struct {
 	*csicommon.DefaultNodeServer
+	driver  *Driver
+	mounter mount.Interface
+
+	// TCP proxies of mounted target paths, when the driver mounts
+	// through a proxy
+	proxiesMutex sync.Mutex
+	proxies      map[string]*tcpProxy
+
+	// Target paths of the published volumes
+	publishes *publishTracker
+	// Rate limits of mounts per server
+	mountLimiters *serverLimiters
 }
 
+// Volume attributes, see the volume package
+const (
+	// Comma separated mount options, for statically created volumes
+	attrMountOptions = volume.ContextMountOptions
+	// "true" to mount with resvport, "false" to mount with noresvport
+	attrResvPort = volume.ContextResvPort
+)
+
 func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	if req.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id is empty")
+	}
+	if req.GetTargetPath() == "" {
+		return nil, status.Error(codes.InvalidArgument, "target path is empty")
+	}
+	if req.GetVolumeCapability() == nil {
+		return nil, status.Error(codes.InvalidArgument, "volume capability must be provided")
+	}
 	targetPath := req.GetTargetPath()
-	notMnt, err := mount.New("").IsLikelyNotMountPoint(targetPath)
+	notMnt, err := ns.mounter.IsLikelyNotMountPoint(targetPath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			if err := os.MkdirAll(targetPath, 0750); err != nil {
@@ -49,37 +84,231 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 		}
 	}
 
-	if !notMnt {
-		return &csi.NodePublishVolumeResponse{}, nil
+	volCtx, err := volume.ParseContext(req.GetVolumeContext())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if volCtx.Version > volume.CurrentContextVersion {
+		glog.Warningf("Volume %v has context version %d, this node plugin only knows version %d and ignores newer keys", req.GetVolumeId(), volCtx.Version, volume.CurrentContextVersion)
 	}
 
 	mo := req.GetVolumeCapability().GetMount().GetMountFlags()
+	mo = append(mo, volCtx.MountOptions...)
+	if !hasMountOption(mo, "resvport", "noresvport") {
+		resvPort := ns.driver.defaultResvPort
+		if volCtx.ResvPort != nil {
+			resvPort = volCtx.ResvPort
+		}
+		if resvPort != nil {
+			if *resvPort {
+				mo = append(mo, "resvport")
+			} else {
+				mo = append(mo, "noresvport")
+			}
+		}
+	}
 	if req.GetReadonly() {
 		mo = append(mo, "ro")
 	}
 
-	s := req.GetVolumeContext()["server"]
-	ep := req.GetVolumeContext()["share"]
-	source := fmt.Sprintf("%s:%s", s, ep)
+	if !ns.publishes.allowed(req.GetVolumeId(), targetPath) {
+		return nil, status.Errorf(codes.ResourceExhausted, "volume %v is already published to %d targets on this node", req.GetVolumeId(), ns.driver.maxPublishesPerVolume)
+	}
+
+	if !notMnt {
+		// Published again, possibly with changed options
+		mo = append(mo, ns.modifiedMountOptions(targetPath)...)
+		if err := ns.remountIfChanged(targetPath, mo); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		ns.publishes.add(req.GetVolumeId(), targetPath)
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
 
-	mounter := mount.New("")
-	err = mounter.Mount(source, targetPath, "nfs", mo)
+	s, ep, err := publishSource(req.GetVolumeId(), volCtx)
 	if err != nil {
-		if os.IsPermission(err) {
-			return nil, status.Error(codes.PermissionDenied, err.Error())
+		return nil, err
+	}
+	if err := ns.mountLimiters.wait(ctx, s); err != nil {
+		return nil, err
+	}
+	for _, o := range ns.driver.serverMountOptions[s] {
+		if !hasMountOption(mo, strings.SplitN(o, "=", 2)[0]) {
+			mo = append(mo, o)
 		}
-		if strings.Contains(err.Error(), "invalid argument") {
-			return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	host, port := validation.SplitServer(s)
+
+	if ns.driver.autofsRoot != "" {
+		if port != 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "server %v with a port cannot be published through autofs", s)
+		}
+		if volCtx.ScratchOverlay && !req.GetReadonly() {
+			return nil, status.Error(codes.InvalidArgument, "scratch overlays cannot be published through autofs")
+		}
+		if err := ns.publishAutofs(ctx, s, ep, targetPath, req.GetReadonly()); err != nil {
+			return nil, err
+		}
+		ns.publishes.add(req.GetVolumeId(), targetPath)
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+
+	ep = ns.driver.nfs4Roots.mountPath(s, ep, mo)
+	if ns.driver.resolver != nil && !usesKerberos(mo) {
+		host = ns.driver.resolver.resolve(host, mountNetwork(mo))
+	}
+	source := validation.MountSource(host, ep)
+
+	if err := ns.driver.hostPrep.prepare(); err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "failed to prepare node for nfs mounts: %v", err)
+	}
+
+	var proxy *tcpProxy
+	if ns.driver.tcpProxy {
+		if proxy, mo, err = ns.startProxy(host, port, mo); err != nil {
+			return nil, err
+		}
+		source = validation.MountSource("127.0.0.1", ep)
+	} else if port != 0 && !hasMountOption(mo, "port") {
+		mo = append(mo, fmt.Sprintf("port=%d", port))
+	}
+
+	err = ns.driver.retryPolicy.do(ctx, fmt.Sprintf("mounting %v at %v", source, targetPath), func() error {
+		if err := injectFault(ctx, faultMount); err != nil {
+			return mountError(err)
+		}
+		if volCtx.ScratchOverlay && !req.GetReadonly() {
+			return mountError(ns.mountScratchOverlay(ctx, source, targetPath, mo, volCtx))
+		}
+		return mountError(mountWithContext(ctx, ns.mounter, source, targetPath, "nfs", mo))
+	})
+	if err != nil {
+		if proxy != nil {
+			proxy.Close()
+		}
+		return nil, err
+	}
+	if proxy != nil {
+		ns.proxiesMutex.Lock()
+		ns.proxies[targetPath] = proxy
+		ns.proxiesMutex.Unlock()
+	}
+	ns.publishes.add(req.GetVolumeId(), targetPath)
+
+	// Options set by ControllerModifyVolume are only known once the
+	// volume is mounted
+	if modified := ns.modifiedMountOptions(targetPath); len(modified) > 0 {
+		if err := ns.remountIfChanged(targetPath, append(mo, modified...)); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
 		}
-		return nil, status.Error(codes.Internal, err.Error())
 	}
 
 	return &csi.NodePublishVolumeResponse{}, nil
 }
 
+// publishSource returns the normalized server and the share to mount for
+// a volume. Volumes translated from in-tree NFS volumes and static volumes
+// may carry the share in their volume handle, whatever its depth, and
+// attributes that are set take precedence. Handles and contexts come from
+// outside of the driver, so all errors are InvalidArgument errors.
+func publishSource(volumeID string, volCtx *volume.Context) (server, share string, err error) {
+	server, share = volCtx.Server, volCtx.Share
+	if server == "" || share == "" {
+		handleServer, handleShare, ok := volume.ParseMigratedID(volumeID)
+		if !ok {
+			return "", "", status.Errorf(codes.InvalidArgument, "volume context lacks %s or %s, and volume id %q is not of the form server:/path", volume.ContextServer, volume.ContextShare, volumeID)
+		}
+		if server == "" {
+			server = handleServer
+		}
+		if share == "" {
+			if err := validation.ValidateShare(handleShare); err != nil {
+				return "", "", status.Error(codes.InvalidArgument, err.Error())
+			}
+			share = handleShare
+		}
+	}
+	s, err := validation.NormalizeServer(server)
+	if err != nil {
+		return "", "", status.Error(codes.InvalidArgument, err.Error())
+	}
+	return s, share, nil
+}
+
+// mountError converts an error of the mounter to a status error
+func mountError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return status.FromContextError(err).Err()
+	}
+	if os.IsPermission(err) {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	if strings.Contains(err.Error(), "invalid argument") {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// startProxy starts a TCP proxy to the nfs service on host and port, 0
+// for the well known port, and returns the mount options needed to mount
+// through it
+func (ns *nodeServer) startProxy(host string, port int, mo []string) (*tcpProxy, []string, error) {
+	for _, o := range mo {
+		kv := strings.SplitN(o, "=", 2)
+		if len(kv) == 2 && (kv[0] == "vers" || kv[0] == "nfsvers") && !strings.HasPrefix(kv[1], "4") {
+			return nil, nil, status.Errorf(codes.InvalidArgument, "mount option %q is not supported through the TCP proxy, only NFSv4 is", o)
+		}
+	}
+	if hasMountOption(mo, "port") {
+		return nil, nil, status.Error(codes.InvalidArgument, "mount option port is not supported through the TCP proxy")
+	}
+
+	upstream := ns.driver.tcpProxyUpstream
+	if upstream == "" {
+		upstream = net.JoinHostPort(host, nfsPort)
+		if port != 0 {
+			upstream = net.JoinHostPort(host, strconv.Itoa(port))
+		}
+	}
+	proxy, err := newTCPProxy(upstream)
+	if err != nil {
+		return nil, nil, status.Errorf(codes.Internal, "failed to start TCP proxy: %v", err)
+	}
+
+	mo = append(mo, fmt.Sprintf("port=%d", proxy.port()))
+	if !hasMountOption(mo, "vers", "nfsvers") {
+		mo = append(mo, "nfsvers=4")
+	}
+	return proxy, mo, nil
+}
+
+// stopProxy stops the TCP proxy of a target path, if there is one
+func (ns *nodeServer) stopProxy(targetPath string) {
+	ns.proxiesMutex.Lock()
+	proxy, ok := ns.proxies[targetPath]
+	delete(ns.proxies, targetPath)
+	ns.proxiesMutex.Unlock()
+
+	if ok {
+		if err := proxy.Close(); err != nil {
+			glog.Warningf("failed to stop TCP proxy of %v: %v", targetPath, err)
+		}
+	}
+}
+
 func (ns *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
+	if req.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id is empty")
+	}
+	if req.GetTargetPath() == "" {
+		return nil, status.Error(codes.InvalidArgument, "target path is empty")
+	}
 	targetPath := req.GetTargetPath()
-	notMnt, err := mount.New("").IsLikelyNotMountPoint(targetPath)
+	notMnt, err := ns.mounter.IsLikelyNotMountPoint(targetPath)
 
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -92,14 +321,31 @@ func (ns *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 		return nil, status.Error(codes.NotFound, "Volume not mounted")
 	}
 
-	err = util.UnmountPath(req.GetTargetPath(), mount.New(""))
+	err = util.UnmountPath(req.GetTargetPath(), ns.mounter)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
+	ns.stopProxy(targetPath)
+	ns.publishes.remove(req.GetVolumeId(), targetPath)
+	if err := ns.cleanupScratch(targetPath); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to clean up scratch overlay: %v", err)
+	}
 
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
 
+func (ns *nodeServer) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
+	caps := []*csi.NodeServiceCapability{}
+	for _, c := range ns.driver.nodeCaps {
+		caps = append(caps, &csi.NodeServiceCapability{
+			Type: &csi.NodeServiceCapability_Rpc{
+				Rpc: &csi.NodeServiceCapability_RPC{Type: c},
+			},
+		})
+	}
+	return &csi.NodeGetCapabilitiesResponse{Capabilities: caps}, nil
+}
+
 func (ns *nodeServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
 	return &csi.NodeUnstageVolumeResponse{}, nil
 }
@@ -0,0 +1,273 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/kubernetes/pkg/util/mount"
+
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/nfs/validation"
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/nfs/volume"
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/testserver"
+)
+
+// optionsMounter is a fake mounter that records the options of mounts,
+// which mount.FakeMounter drops
+type optionsMounter struct {
+	*mount.FakeMounter
+	options [][]string
+}
+
+func (m *optionsMounter) Mount(source string, target string, fstype string, options []string) error {
+	m.options = append(m.options, options)
+	return m.FakeMounter.Mount(source, target, fstype, options)
+}
+
+func TestNodePublishVolume(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		id          string
+		volCtx      map[string]string
+		mountFlags  []string
+		readonly    bool
+		wantSource  string
+		wantOptions []string
+	}{
+		{
+			name: "context with only server and share",
+			id:   "v2:192.0.2.10/export/pvc-1/pvc-1",
+			volCtx: map[string]string{
+				volume.ContextServer: "192.0.2.10",
+				volume.ContextShare:  "/export/pvc-1",
+			},
+			wantSource: "192.0.2.10:/export/pvc-1",
+		},
+		{
+			name:        "server:/path handle only",
+			id:          "192.0.2.10:/export/data",
+			mountFlags:  []string{"nfsvers=4.1"},
+			readonly:    true,
+			wantSource:  "192.0.2.10:/export/data",
+			wantOptions: []string{"nfsvers=4.1", "ro"},
+		},
+		{
+			name: "share from context, server from handle",
+			id:   "192.0.2.10:/export",
+			volCtx: map[string]string{
+				volume.ContextShare: "/export/pvc-2",
+			},
+			wantSource: "192.0.2.10:/export/pvc-2",
+		},
+		{
+			name: "server from context, share from handle",
+			id:   "192.0.2.10:/export/data",
+			volCtx: map[string]string{
+				volume.ContextServer:   "192.0.2.11",
+				volume.ContextResvPort: "false",
+			},
+			wantSource:  "192.0.2.11:/export/data",
+			wantOptions: []string{"noresvport"},
+		},
+		{
+			name: "newer context version",
+			id:   "v2:192.0.2.10/export/pvc-3/pvc-3",
+			volCtx: map[string]string{
+				volume.ContextServer:       "192.0.2.10",
+				volume.ContextShare:        "/export/pvc-3",
+				volume.ContextMountOptions: "hard,timeo=600",
+				volume.ContextVersion:      strconv.Itoa(volume.CurrentContextVersion + 1),
+				"keyOfANewerController":    "value",
+			},
+			mountFlags:  []string{"nfsvers=4.1"},
+			wantSource:  "192.0.2.10:/export/pvc-3",
+			wantOptions: []string{"nfsvers=4.1", "hard", "timeo=600"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			mounter := &optionsMounter{FakeMounter: &mount.FakeMounter{}}
+			ns := NewNodeServer(New(WithNodeID("test"), WithMounter(mounter)))
+			targetPath := filepath.Join(t.TempDir(), "target")
+			_, err := ns.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:      test.id,
+				TargetPath:    targetPath,
+				VolumeContext: test.volCtx,
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{MountFlags: test.mountFlags},
+					},
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+					},
+				},
+				Readonly: test.readonly,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(mounter.MountPoints) != 1 || len(mounter.options) != 1 {
+				t.Fatalf("NodePublishVolume() mounted %+v, want one mount", mounter.MountPoints)
+			}
+			mp := mounter.MountPoints[0]
+			if mp.Device != test.wantSource || mp.Path != targetPath || mp.Type != "nfs" {
+				t.Errorf("NodePublishVolume() mounted %v at %v with type %v, want %v at %v with type nfs", mp.Device, mp.Path, mp.Type, test.wantSource, targetPath)
+			}
+			if len(mounter.options[0])+len(test.wantOptions) > 0 && !reflect.DeepEqual(mounter.options[0], test.wantOptions) {
+				t.Errorf("NodePublishVolume() mounted with options %q, want %q", mounter.options[0], test.wantOptions)
+			}
+		})
+	}
+}
+
+// FuzzPublishSource checks that volume handles and contexts never panic
+// the node plugin, that it rejects bad ones with InvalidArgument, and
+// that the shares it takes from server:/path handles are safe to mount
+func FuzzPublishSource(f *testing.F) {
+	f.Add("nfs.example.com:/export/pvc-1", "", "")
+	f.Add("[fd00::1]:/export", "", "")
+	f.Add("v2:nfs.example.com/export/pvc-1/pvc-1", "nfs.example.com", "/export/pvc-1")
+	f.Add("nfs.example.com/export/pvc-1", "", "/export/pvc-1")
+	f.Add("nfs:/export/../../etc", "", "")
+	f.Add(":/export", "", "")
+	f.Fuzz(func(t *testing.T, id, server, share string) {
+		volCtx, err := volume.ParseContext(map[string]string{
+			volume.ContextServer: server,
+			volume.ContextShare:  share,
+		})
+		if err != nil {
+			return
+		}
+		s, ep, err := publishSource(id, volCtx)
+		if err != nil {
+			if status.Code(err) != codes.InvalidArgument {
+				t.Fatalf("publishSource(%q, %+v) failed with %v, want InvalidArgument", id, volCtx, err)
+			}
+			return
+		}
+		if s == "" || ep == "" {
+			t.Fatalf("publishSource(%q, %+v) = %q, %q", id, volCtx, s, ep)
+		}
+		if share == "" {
+			if err := validation.ValidateShare(ep); err != nil {
+				t.Fatalf("publishSource(%q, %+v) accepted share %q: %v", id, volCtx, ep, err)
+			}
+		}
+	})
+}
+
+func TestNodePublishVolumeScratchMemory(t *testing.T) {
+	mounter := &optionsMounter{FakeMounter: &mount.FakeMounter{}}
+	d := New(WithNodeID("test"), WithMounter(mounter))
+	d.scratchDir = t.TempDir()
+	ns := NewNodeServer(d)
+	targetPath := filepath.Join(t.TempDir(), "target")
+	_, err := ns.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:   "192.0.2.10:/export/data",
+		TargetPath: targetPath,
+		VolumeContext: map[string]string{
+			volume.ContextScratchOverlay: "true",
+			volume.ContextScratchMedium:  volume.ScratchMediumMemory,
+			volume.ContextScratchSize:    "1Gi",
+		},
+		VolumeCapability: testMountCapability,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tmpfsOptions []string
+	found := false
+	for i, mp := range mounter.MountPoints {
+		if mp.Type == "tmpfs" {
+			tmpfsOptions = mounter.options[i]
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("NodePublishVolume() mounted %+v, want a tmpfs", mounter.MountPoints)
+	}
+	if want := []string{"size=1073741824"}; !reflect.DeepEqual(tmpfsOptions, want) {
+		t.Errorf("tmpfs was mounted with options %q, want %q", tmpfsOptions, want)
+	}
+}
+
+// TestNodePublishVolumeTestServer publishes a share of the user space test
+// server with the kernel nfs client, which needs root and mount.nfs
+func TestNodePublishVolumeTestServer(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("mounting needs root")
+	}
+	if _, err := exec.LookPath("mount.nfs"); err != nil {
+		t.Skipf("no nfs client: %v", err)
+	}
+	server, err := testserver.Start(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	if err := os.Mkdir(filepath.Join(server.Dir(), "pvc-1"), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	ns := NewNodeServer(New(WithNodeID("test")))
+	targetPath := filepath.Join(t.TempDir(), "target")
+	_, err = ns.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:   "pvc-1",
+		TargetPath: targetPath,
+		VolumeContext: map[string]string{
+			volume.ContextServer: server.Address,
+			volume.ContextShare:  server.Share + "/pvc-1",
+		},
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{MountFlags: server.MountOptions},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ns.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{VolumeId: "pvc-1", TargetPath: targetPath})
+
+	// Writes through the mount end up in the served directory
+	if err := ioutil.WriteFile(filepath.Join(targetPath, "hello"), []byte("hello world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(server.Dir(), "pvc-1", "hello"))
+	if err != nil || string(data) != "hello world" {
+		t.Errorf("served directory has %q, %v, want %q", data, err, "hello world")
+	}
+
+	if _, err := ns.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{VolumeId: "pvc-1", TargetPath: targetPath}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(targetPath, "hello")); !os.IsNotExist(err) {
+		t.Errorf("volume is still mounted at %v: %v", targetPath, err)
+	}
+}
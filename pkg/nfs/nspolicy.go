@@ -0,0 +1,141 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ownershipPolicy is the default owner and mode of the subdirectories of
+// volumes in one namespace. Fields that are nil are left to the
+// StorageClass and the driver defaults.
+type ownershipPolicy struct {
+	uid  *int
+	gid  *int
+	mode *os.FileMode
+}
+
+// parseOwnershipPolicy parses a policy of the form "uid=1000,gid=1000,mode=0770"
+func parseOwnershipPolicy(s string) (*ownershipPolicy, error) {
+	p := &ownershipPolicy{}
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid field %q, must be key=value", field)
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		switch key {
+		case "uid", "gid":
+			id, err := strconv.Atoi(value)
+			if err != nil || id < 0 {
+				return nil, fmt.Errorf("invalid %s %q", key, value)
+			}
+			if key == "uid" {
+				p.uid = &id
+			} else {
+				p.gid = &id
+			}
+		case "mode":
+			m, err := strconv.ParseUint(value, 8, 32)
+			if err != nil || m > 07777 {
+				return nil, fmt.Errorf("invalid mode %q, must be octal", value)
+			}
+			mode := toFileMode(m)
+			p.mode = &mode
+		default:
+			return nil, fmt.Errorf("unknown field %q", key)
+		}
+	}
+	return p, nil
+}
+
+// toFileMode converts a chmod(1) style octal mode to an os.FileMode
+func toFileMode(m uint64) os.FileMode {
+	mode := os.FileMode(m & 0777)
+	if m&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if m&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if m&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
+
+// namespacePolicy returns the ownership policy of namespace from the
+// policy ConfigMap, which maps namespaces to policies. It returns nil if
+// there is no ConfigMap or no policy for namespace.
+func (cs *controllerServer) namespacePolicy(namespace string) (*ownershipPolicy, error) {
+	ref := cs.driver.namespacePolicy
+	if ref == "" || namespace == "" {
+		return nil, nil
+	}
+	parts := strings.SplitN(ref, "/", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid policy config map %q, must be namespace/name", ref)
+	}
+	cm, err := cs.driver.kubeClient.CoreV1().ConfigMaps(parts[0]).Get(parts[1], metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		glog.V(4).Infof("Policy config map %s does not exist", ref)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s, ok := cm.Data[namespace]
+	if !ok {
+		return nil, nil
+	}
+	p, err := parseOwnershipPolicy(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy of namespace %s in config map %s: %v", namespace, ref, err)
+	}
+	return p, nil
+}
+
+// applyNamespacePolicy applies the ownership policy of the namespace of
+// the claim of vol, where the StorageClass does not set the owner or mode
+func (cs *controllerServer) applyNamespacePolicy(vol *nfsVolume) error {
+	p, err := cs.namespacePolicy(vol.pvcNamespace)
+	if err != nil || p == nil {
+		return err
+	}
+	glog.V(4).Infof("Applying the policy of namespace %s to volume %v", vol.pvcNamespace, vol.id)
+	if vol.uid == nil {
+		vol.uid = p.uid
+	}
+	if vol.gid == nil && vol.supplementalGroup == nil {
+		vol.gid = p.gid
+	}
+	if p.mode != nil && vol.mountPermissions == nil {
+		vol.dirMode = *p.mode
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/nfs/validation"
+)
+
+// Prefix of the name that archived volume directories are renamed to
+const archivePrefix = "archived-"
+
+// keepIfRequested applies the onDelete parameter the volume was created
+// with, as kept in its record. Volumes to retain are left alone and
+// volumes to archive are renamed next to the other volumes of the base
+// share. Volumes without a record are deleted; the metadata file in the
+// volume is not trusted, since pods using the volume can rewrite it. It
+// reports whether the volume was kept, in which case it must not be
+// deleted.
+func (cs *controllerServer) keepIfRequested(ctx context.Context, vol *nfsVolume) (bool, error) {
+	kept := false
+	err := cs.exports.run(ctx, vol, func(mountPath string) error {
+		dir := filepath.Join(mountPath, vol.subDir)
+		md, err := readVolumeRecord(dir)
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to read record of volume %v: %v", vol.id, err)
+		}
+		if md == nil {
+			return nil
+		}
+
+		switch validation.OnDelete(md.Parameters) {
+		case validation.OnDeleteRetain:
+			glog.V(2).Infof("Retaining subdirectory %v of volume %v", dir, vol.id)
+			kept = true
+		case validation.OnDeleteArchive:
+			target := filepath.Join(mountPath, cs.archiveName(vol))
+			glog.V(2).Infof("Archiving subdirectory %v of volume %v as %v", dir, vol.id, target)
+			err := cs.runAsProvisioner(func() error {
+				return os.Rename(dir, target)
+			})
+			if err != nil {
+				return status.Errorf(codes.Internal, "failed to archive volume %v: %v", vol.id, err)
+			}
+			if err := cs.removeVolumeRecord(dir); err != nil {
+				glog.Warningf("failed to remove record of archived volume %v: %v", vol.id, err)
+			}
+			kept = true
+		}
+		return nil
+	})
+	if err != nil {
+		return false, toStatusError(err)
+	}
+	return kept, nil
+}
+
+// archiveName returns the name the directory of vol is archived as. The
+// nfs-client-provisioner names archives archived-{subDir}, which tools
+// that clean up after it expect, but a volume name that is used again
+// cannot be archived twice then.
+func (cs *controllerServer) archiveName(vol *nfsVolume) string {
+	if cs.driver.legacyArchiveNames {
+		return archivePrefix + vol.subDir
+	}
+	return fmt.Sprintf("%s%s-%s", archivePrefix, vol.subDir, time.Now().UTC().Format("20060102T150405Z"))
+}
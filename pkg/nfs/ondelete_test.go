@@ -0,0 +1,105 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/nfs/validation"
+)
+
+func TestKeepIfRequested(t *testing.T) {
+	tests := []struct {
+		name string
+		// onDelete in the record, nil for no record
+		recordOnDelete *string
+		// onDelete in the metadata file in the volume, nil for no file
+		fileOnDelete *string
+		kept         bool
+		archived     bool
+	}{
+		{name: "delete", recordOnDelete: strPtr(validation.OnDeleteDelete), fileOnDelete: strPtr(validation.OnDeleteDelete)},
+		{name: "retain", recordOnDelete: strPtr(validation.OnDeleteRetain), fileOnDelete: strPtr(validation.OnDeleteRetain), kept: true},
+		{name: "archive", recordOnDelete: strPtr(validation.OnDeleteArchive), fileOnDelete: strPtr(validation.OnDeleteArchive), kept: true, archived: true},
+		{name: "delete with file rewritten to retain", recordOnDelete: strPtr(validation.OnDeleteDelete), fileOnDelete: strPtr(validation.OnDeleteRetain)},
+		{name: "delete with file rewritten to archive", recordOnDelete: strPtr(validation.OnDeleteDelete), fileOnDelete: strPtr(validation.OnDeleteArchive)},
+		{name: "retain with file rewritten to delete", recordOnDelete: strPtr(validation.OnDeleteRetain), fileOnDelete: strPtr(validation.OnDeleteDelete), kept: true},
+		{name: "missing record", fileOnDelete: strPtr(validation.OnDeleteRetain)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			workDir := t.TempDir()
+			cs := newTestControllerServer(WithWorkingMountDir(workDir))
+			cs.driver.ns = NewNodeServer(cs.driver)
+			vol := &nfsVolume{server: "192.0.2.10", baseDir: "export", subDir: "pvc-1", name: "pvc-1"}
+			vol.id = cs.getVolumeIdFromNfsVol(vol)
+
+			// The fake mounter leaves the share at its mount path as it is
+			mountPath := filepath.Join(workDir, exportMountName(exportKey(vol)))
+			dir := filepath.Join(mountPath, vol.subDir)
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if test.recordOnDelete != nil {
+				md := &volumeMetadata{Parameters: map[string]string{validation.ParamOnDelete: *test.recordOnDelete}}
+				if err := cs.writeVolumeMetadata(dir, md); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if test.fileOnDelete != nil {
+				data := []byte(`{"parameters":{"` + validation.ParamOnDelete + `":"` + *test.fileOnDelete + `"}}`)
+				if err := ioutil.WriteFile(filepath.Join(dir, volumeMetadataFile), data, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			kept, err := cs.keepIfRequested(context.Background(), vol)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kept != test.kept {
+				t.Errorf("keepIfRequested() = %v, want %v", kept, test.kept)
+			}
+			_, statErr := os.Stat(dir)
+			if test.archived != os.IsNotExist(statErr) {
+				t.Errorf("volume directory was moved: %v, want %v", os.IsNotExist(statErr), test.archived)
+			}
+			files, err := ioutil.ReadDir(mountPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var archives []string
+			for _, f := range files {
+				if strings.HasPrefix(f.Name(), archivePrefix+vol.subDir+"-") {
+					archives = append(archives, f.Name())
+				}
+			}
+			if test.archived && len(archives) != 1 {
+				t.Errorf("base share has archives %v, want one", archives)
+			}
+			if !test.archived && len(archives) != 0 {
+				t.Errorf("base share has archives %v, want none", archives)
+			}
+		})
+	}
+}
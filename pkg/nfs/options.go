@@ -0,0 +1,217 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"net"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"k8s.io/kubernetes/pkg/util/mount"
+
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/nfs/validation"
+)
+
+// Option configures a driver created by New
+type Option func(*Driver)
+
+// WithNodeID sets the id the node service reports
+func WithNodeID(nodeID string) Option {
+	return func(d *Driver) {
+		d.nodeID = nodeID
+	}
+}
+
+// WithEndpoint sets the CSI endpoint to serve on, e.g.
+// unix:///csi/csi.sock
+func WithEndpoint(endpoint string) Option {
+	return func(d *Driver) {
+		d.endpoint = endpoint
+	}
+}
+
+// WithListener serves on listener instead of an endpoint, e.g. on a
+// bufconn listener in integration tests. The listener is closed when
+// the driver is stopped.
+func WithListener(listener net.Listener) Option {
+	return func(d *Driver) {
+		d.listener = listener
+	}
+}
+
+// WithMounter sets the mounter of the node service, see NewMounter
+func WithMounter(mounter mount.Interface) Option {
+	return func(d *Driver) {
+		d.mounter = mounter
+	}
+}
+
+// WithWorkingMountDir sets the directory the controller mounts shares at
+func WithWorkingMountDir(dir string) Option {
+	return func(d *Driver) {
+		d.workingMountDir = dir
+	}
+}
+
+// WithControllerPublish makes the controller advertise
+// PUBLISH_UNPUBLISH_VOLUME and record the nodes volumes are published to
+func WithControllerPublish() Option {
+	return func(d *Driver) {
+		d.controllerPublish = true
+	}
+}
+
+// WithAccessModes replaces the supported volume access modes
+func WithAccessModes(modes ...csi.VolumeCapability_AccessMode_Mode) Option {
+	return func(d *Driver) {
+		d.accessModes = modes
+	}
+}
+
+// WithControllerCapabilities replaces the advertised controller
+// capabilities, e.g. to run without GET_CAPACITY
+func WithControllerCapabilities(caps ...csi.ControllerServiceCapability_RPC_Type) Option {
+	return func(d *Driver) {
+		d.controllerCaps = caps
+	}
+}
+
+// WithNodeCapabilities replaces the advertised node capabilities
+func WithNodeCapabilities(caps ...csi.NodeServiceCapability_RPC_Type) Option {
+	return func(d *Driver) {
+		d.nodeCaps = caps
+	}
+}
+
+// WithInterceptors adds unary interceptors, called in order for every
+// request
+func WithInterceptors(interceptors ...grpc.UnaryServerInterceptor) Option {
+	return func(d *Driver) {
+		d.interceptors = append(d.interceptors, interceptors...)
+	}
+}
+
+// WithDriverOptions applies all fields of options, including zero values,
+// so options should be based on DefaultDriverOptions, e.g.
+//
+//	options := DefaultDriverOptions()
+//	options.Shares = []string{"nfs.example.com:/export"}
+//	d := New(WithDriverOptions(options), WithMounter(mounter))
+func WithDriverOptions(options *DriverOptions) Option {
+	return func(d *Driver) {
+		d.nodeID = options.NodeID
+		d.endpoint = options.Endpoint
+		d.workingMountDir = options.WorkingMountDir
+		d.defaultDirMode = options.DefaultDirMode
+		d.dirModeFromAccessModes = options.DirModeFromAccessModes
+		d.provisioningUID = options.ProvisioningUID
+		d.provisioningGID = options.ProvisioningGID
+		d.deleteParallelism = options.DeleteParallelism
+		d.defaultResvPort = options.DefaultResvPort
+		d.tcpProxy = options.TCPProxy
+		d.tcpProxyUpstream = options.TCPProxyUpstream
+		d.compressResponses = options.CompressResponses
+		d.metricsAddress = options.MetricsAddress
+		d.workingDirPruneAge = options.WorkingDirPruneAge
+		d.resolver = nil
+		if options.DNSCacheTTL > 0 {
+			d.resolver = newResolver(options.DNSCacheTTL)
+		}
+		d.kubeClient = options.KubeClient
+		d.deleteJob = options.DeleteJob
+		d.verifyPVOnDelete = options.VerifyPVOnDelete
+		d.maxConcurrentSnapshots = options.MaxConcurrentSnapshots
+		d.shares = options.Shares
+		if options.SnapshotsDir != "" {
+			d.snapshotsDir = options.SnapshotsDir
+		}
+		d.scratchDir = options.ScratchDir
+		d.maxPublishesPerVolume = options.MaxPublishesPerVolume
+		d.requireEmptyOnDelete = options.RequireEmptyOnDelete
+		d.serverFailureThreshold = options.ServerFailureThreshold
+		d.serverFailureCooldown = options.ServerFailureCooldown
+		d.warmUp = options.WarmUp
+		d.warmUpServers = options.WarmUpServers
+		d.retryPolicy = options.RetryPolicy
+		d.mountStatsMetrics = options.MountStatsMetrics
+		d.capacityCacheTTL = options.CapacityCacheTTL
+		d.namespacePolicy = options.NamespacePolicy
+		d.mountRatePerServer = options.MountRatePerServer
+		d.mountBurstPerServer = options.MountBurstPerServer
+		d.autofsRoot = options.AutofsRoot
+		d.probeCanary = options.ProbeCanary
+		d.probeCanaryInterval = options.ProbeCanaryInterval
+		d.clusterID = options.ClusterID
+		d.clusterIDInSubDir = options.ClusterIDInSubDir && options.ClusterID != ""
+		d.backgroundWindows = options.BackgroundWindows
+		d.backgroundIORate = options.BackgroundIORate
+		d.maxExportMetricLabels = options.MaxExportMetricLabels
+		d.selfCheckExports = options.SelfCheckExports
+		if options.QuarantineDir != "" {
+			d.quarantineDir = options.QuarantineDir
+		}
+		d.legacyArchiveNames = options.LegacyArchiveNames
+		d.legacyVolumeIDs = options.LegacyVolumeIDs
+		d.uuidSuffix = options.UUIDSuffix
+		d.tagXattrs = options.TagXattrs
+		d.verifyServer = options.VerifyServer
+		d.controllerPublish = options.ControllerPublish
+		d.shareAliasesFile = options.ShareAliasesFile
+		d.allowedServers = nil
+		if len(options.AllowedServers) > 0 {
+			d.allowedServers = map[string]bool{}
+			for _, server := range options.AllowedServers {
+				if s, err := validation.NormalizeServer(server); err == nil {
+					server = s
+				}
+				d.allowedServers[server] = true
+			}
+		}
+		if options.TopologyKey != "" {
+			d.topologyKey = options.TopologyKey
+		}
+		d.topologyServers = map[string]string{}
+		for value, server := range options.TopologyServers {
+			if s, err := validation.NormalizeServer(server); err == nil {
+				server = s
+			}
+			d.topologyServers[value] = server
+		}
+		d.nodeTopology = options.NodeTopology
+		d.nfs4Roots = nfs4Roots{}
+		for server, root := range options.NFS4Roots {
+			if s, err := validation.NormalizeServer(server); err == nil {
+				server = s
+			}
+			d.nfs4Roots[server] = root
+		}
+		d.serverMountOptions = map[string][]string{}
+		for server, mo := range options.ServerMountOptions {
+			if s, err := validation.NormalizeServer(server); err == nil {
+				server = s
+			}
+			d.serverMountOptions[server] = mo
+		}
+		d.hostPrep = &hostPreparer{
+			sysctls:      options.Sysctls,
+			moduleParams: options.ModuleParams,
+		}
+		d.capacityProvider = options.CapacityProvider
+		d.quotaProvider = options.QuotaProvider
+		d.mounter = options.Mounter
+	}
+}
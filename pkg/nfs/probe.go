@@ -0,0 +1,112 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"sync"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"golang.org/x/net/context"
+
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/csi-common"
+)
+
+// Timeout of a canary mount and unmount
+const canaryTimeout = 30 * time.Second
+
+// identityServer reports the controller as not ready while a canary
+// export cannot be mounted, e.g. because nfs-utils are broken in the
+// image or the kernel nfs client of the controller node regressed, and
+// until the self check at start passed.
+type identityServer struct {
+	*csicommon.DefaultIdentityServer
+	canary    *canaryProbe
+	selfCheck *selfCheck
+	// Advertise VOLUME_ACCESSIBILITY_CONSTRAINTS
+	topology bool
+}
+
+func (ids *identityServer) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
+	if ids.selfCheck != nil {
+		if err := ids.selfCheck.result(); err != nil {
+			glog.V(4).Infof("Reporting not ready: %v", err)
+			return &csi.ProbeResponse{Ready: &wrappers.BoolValue{Value: false}}, nil
+		}
+	}
+	if ids.canary == nil {
+		return ids.DefaultIdentityServer.Probe(ctx, req)
+	}
+	if err := ids.canary.check(); err != nil {
+		return &csi.ProbeResponse{Ready: &wrappers.BoolValue{Value: false}}, nil
+	}
+	return &csi.ProbeResponse{Ready: &wrappers.BoolValue{Value: true}}, nil
+}
+
+// canaryProbe mounts and unmounts a canary export at most once per
+// interval. Probes in between report the last result, so that frequent
+// probes do not turn into a mount storm.
+type canaryProbe struct {
+	cs       *controllerServer
+	vol      *nfsVolume
+	interval time.Duration
+
+	mutex   sync.Mutex
+	checked time.Time
+	err     error
+}
+
+func newCanaryProbe(cs *controllerServer, server, share string, interval time.Duration) *canaryProbe {
+	return &canaryProbe{
+		cs: cs,
+		vol: &nfsVolume{
+			id:      "probe-canary",
+			server:  server,
+			baseDir: share,
+			name:    "probe-canary",
+		},
+		interval: interval,
+	}
+}
+
+// check returns the result of the last canary mount, mounting again if
+// it is older than the interval
+func (p *canaryProbe) check() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !p.checked.IsZero() && time.Since(p.checked) < p.interval {
+		return p.err
+	}
+
+	// Not bound to the probe request, so that a probe with a short
+	// timeout cannot leave the canary mounted
+	ctx, cancel := context.WithTimeout(context.Background(), canaryTimeout)
+	defer cancel()
+	p.err = p.cs.internalMount(ctx, p.vol)
+	if p.err == nil {
+		p.err = p.cs.internalUnmount(ctx, p.vol)
+	}
+	p.checked = time.Now()
+	if p.err != nil {
+		glog.Warningf("Canary mount of %v:%v failed, reporting not ready: %v", p.vol.server, p.vol.baseDir, p.err)
+	} else {
+		glog.V(4).Infof("Canary mount of %v:%v succeeded", p.vol.server, p.vol.baseDir)
+	}
+	return p.err
+}
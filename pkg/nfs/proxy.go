@@ -0,0 +1,104 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"io"
+	"net"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// nfsPort is the well known port of NFSv4 servers
+const nfsPort = "2049"
+
+// tcpProxy forwards connections accepted on a local port to an upstream
+// address. It lets the kernel mount an NFS server that is only reachable
+// from the driver process, e.g. through a port-forward or a jump host.
+// Only NFSv4 can be mounted this way, since it needs a single port.
+type tcpProxy struct {
+	listener net.Listener
+	upstream string
+	wg       sync.WaitGroup
+}
+
+// newTCPProxy starts a proxy to upstream on a random loopback port
+func newTCPProxy(upstream string) (*tcpProxy, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	p := &tcpProxy{
+		listener: listener,
+		upstream: upstream,
+	}
+	p.wg.Add(1)
+	go p.serve()
+
+	glog.V(4).Infof("Proxying %v to %v", listener.Addr(), upstream)
+	return p, nil
+}
+
+// port returns the local port of the proxy
+func (p *tcpProxy) port() int {
+	return p.listener.Addr().(*net.TCPAddr).Port
+}
+
+// Close stops accepting connections. Established connections are
+// closed by the peers when the share is unmounted.
+func (p *tcpProxy) Close() error {
+	err := p.listener.Close()
+	p.wg.Wait()
+	return err
+}
+
+func (p *tcpProxy) serve() {
+	defer p.wg.Done()
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			glog.V(4).Infof("Proxy to %v stopped: %v", p.upstream, err)
+			return
+		}
+		go p.forward(conn)
+	}
+}
+
+func (p *tcpProxy) forward(conn net.Conn) {
+	defer conn.Close()
+
+	upstream, err := net.Dial("tcp", p.upstream)
+	if err != nil {
+		glog.Errorf("Proxy failed to connect to %v: %v", p.upstream, err)
+		return
+	}
+	defer upstream.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(upstream, conn)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, upstream)
+		done <- struct{}{}
+	}()
+	// Tear down both directions as soon as one of them ends
+	<-done
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"path"
+	"strings"
+)
+
+// nfs4Roots maps nfs servers to the directory they export as NFSv4 pseudo
+// root (fsid=0). NFSv4 clients mount paths relative to that directory,
+// while shares, volume IDs and NFSv3 mounts use the filesystem path.
+type nfs4Roots map[string]string
+
+// mountPath returns the path to mount share of server with the mount
+// options mo. With NFSv4, a share below the pseudo root of server is
+// mounted relative to the pseudo root; shares outside of it and NFSv2 and
+// NFSv3 mounts use share as is.
+func (r nfs4Roots) mountPath(server, share string, mo []string) string {
+	root, ok := r[server]
+	if !ok || !isNFS4(mo) {
+		return share
+	}
+	root = path.Clean("/" + root)
+	share = path.Clean("/" + share)
+	if root == "/" {
+		return share
+	}
+	if share == root {
+		return "/"
+	}
+	if strings.HasPrefix(share, root+"/") {
+		return strings.TrimPrefix(share, root)
+	}
+	return share
+}
+
+// isNFS4 reports whether mounts with the options mo may use NFSv4, which
+// is the default of the mount helper
+func isNFS4(mo []string) bool {
+	for _, o := range mo {
+		parts := strings.SplitN(o, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch strings.ToLower(parts[0]) {
+		case "vers", "nfsvers":
+			if strings.HasPrefix(parts[1], "2") || strings.HasPrefix(parts[1], "3") {
+				return false
+			}
+		}
+	}
+	return true
+}
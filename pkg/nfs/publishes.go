@@ -0,0 +1,101 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// Number of targets of one volume on a node above which every further
+// publish is logged as a warning
+const publishWarnThreshold = 32
+
+// publishTracker keeps track of the target paths each volume is published
+// to on this node. Many pods using the same RWX volume on one node cause
+// many mounts of the same share, which is worth knowing when debugging
+// mount storms.
+type publishTracker struct {
+	// Maximum number of targets per volume, 0 for no limit
+	limit int
+
+	mutex   sync.Mutex
+	targets map[string]map[string]bool
+}
+
+func newPublishTracker(limit int) *publishTracker {
+	return &publishTracker{
+		limit:   limit,
+		targets: map[string]map[string]bool{},
+	}
+}
+
+// allowed reports whether volumeID may be published to one more target
+func (t *publishTracker) allowed(volumeID, targetPath string) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	targets := t.targets[volumeID]
+	return t.limit <= 0 || targets[targetPath] || len(targets) < t.limit
+}
+
+// add records that volumeID is published at targetPath
+func (t *publishTracker) add(volumeID, targetPath string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	targets, ok := t.targets[volumeID]
+	if !ok {
+		targets = map[string]bool{}
+		t.targets[volumeID] = targets
+	}
+	targets[targetPath] = true
+
+	n := len(targets)
+	volumePublishes.WithLabelValues(volumeID).Set(float64(n))
+	if n > publishWarnThreshold {
+		glog.Warningf("Volume %v is published to %d targets on this node", volumeID, n)
+	} else {
+		glog.V(4).Infof("Volume %v is published to %d targets on this node", volumeID, n)
+	}
+}
+
+// remove records that targetPath is unpublished
+func (t *publishTracker) remove(volumeID, targetPath string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	targets := t.targets[volumeID]
+	delete(targets, targetPath)
+	if len(targets) == 0 {
+		delete(t.targets, volumeID)
+		volumePublishes.DeleteLabelValues(volumeID)
+		return
+	}
+	volumePublishes.WithLabelValues(volumeID).Set(float64(len(targets)))
+}
+
+// volumesByTarget returns the volume published at each target path
+func (t *publishTracker) volumesByTarget() map[string]string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	result := map[string]string{}
+	for volumeID, targets := range t.targets {
+		for target := range targets {
+			result[target] = volumeID
+		}
+	}
+	return result
+}
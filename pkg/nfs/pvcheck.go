@@ -0,0 +1,78 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"github.com/golang/glog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// verifyPVDeletable checks with the Kubernetes API that the
+// PersistentVolume of vol is released and has the Delete reclaim policy,
+// so that a misbehaving sidecar or a manual gRPC call cannot wipe the
+// data of a volume that is still in use or meant to be retained.
+func (cs *controllerServer) verifyPVDeletable(vol *nfsVolume) error {
+	pv, err := cs.findPV(vol)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to look up persistent volume of volume %v: %v", vol.id, err)
+	}
+	if pv == nil {
+		return status.Errorf(codes.FailedPrecondition, "refusing to delete volume %v: no persistent volume references it", vol.id)
+	}
+	if pv.Spec.PersistentVolumeReclaimPolicy != v1.PersistentVolumeReclaimDelete {
+		return status.Errorf(codes.FailedPrecondition, "refusing to delete volume %v: persistent volume %s has reclaim policy %s", vol.id, pv.Name, pv.Spec.PersistentVolumeReclaimPolicy)
+	}
+	if pv.Status.Phase == v1.VolumeBound || pv.Status.Phase == v1.VolumeAvailable || pv.Status.Phase == v1.VolumePending {
+		return status.Errorf(codes.FailedPrecondition, "refusing to delete volume %v: persistent volume %s is %s", vol.id, pv.Name, pv.Status.Phase)
+	}
+	glog.V(4).Infof("Persistent volume %s of volume %v is %s, deleting", pv.Name, vol.id, pv.Status.Phase)
+	return nil
+}
+
+// findPV returns the persistent volume of vol, or nil if there is none.
+// Provisioned volumes are named after the persistent volume, so that one
+// is tried first before all volumes are listed.
+func (cs *controllerServer) findPV(vol *nfsVolume) (*v1.PersistentVolume, error) {
+	pvs := cs.driver.kubeClient.CoreV1().PersistentVolumes()
+
+	pv, err := pvs.Get(vol.name, metav1.GetOptions{})
+	if err == nil && cs.isPVOf(pv, vol) {
+		return pv, nil
+	}
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	list, err := pvs.List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range list.Items {
+		if cs.isPVOf(&list.Items[i], vol) {
+			return &list.Items[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func (cs *controllerServer) isPVOf(pv *v1.PersistentVolume, vol *nfsVolume) bool {
+	return pv.Spec.CSI != nil && pv.Spec.CSI.Driver == driverName && pv.Spec.CSI.VolumeHandle == vol.id
+}
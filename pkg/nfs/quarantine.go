@@ -0,0 +1,77 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Directory under the base share that volumes of other clusters are moved
+// to instead of deleting them
+const defaultQuarantineDir = ".csi-nfs-quarantine"
+
+// quarantineIfForeign moves the directory of vol into the quarantine
+// directory of its base share if its record says that it was provisioned
+// by another cluster. Several clusters may provision onto the same export,
+// and a stray DeleteVolume of one cluster, e.g. for a PersistentVolume
+// that was copied between clusters, must not delete the data of another.
+// Volumes without a record are not quarantined; the metadata file in the
+// volume is not trusted, since pods using the volume can rewrite it. It
+// reports whether the volume was quarantined.
+func (cs *controllerServer) quarantineIfForeign(ctx context.Context, vol *nfsVolume) (bool, error) {
+	quarantined := false
+	err := cs.exports.run(ctx, vol, func(mountPath string) error {
+		dir := filepath.Join(mountPath, vol.subDir)
+		md, err := readVolumeRecord(dir)
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to read record of volume %v: %v", vol.id, err)
+		}
+		if md == nil || md.ClusterID == "" || md.ClusterID == cs.driver.clusterID {
+			return nil
+		}
+
+		quarantineDir := filepath.Join(mountPath, cs.driver.quarantineDir)
+		target := filepath.Join(quarantineDir, fmt.Sprintf("%s-%s", vol.subDir, time.Now().UTC().Format("20060102T150405Z")))
+		glog.Warningf("Volume %v was provisioned by cluster %q, not by this cluster %q: moving it to %v instead of deleting it", vol.id, md.ClusterID, cs.driver.clusterID, target)
+		err = cs.runAsProvisioner(func() error {
+			if err := os.MkdirAll(quarantineDir, 0700); err != nil {
+				return err
+			}
+			return os.Rename(dir, target)
+		})
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to quarantine volume %v: %v", vol.id, err)
+		}
+		if err := cs.removeVolumeRecord(dir); err != nil {
+			glog.Warningf("failed to remove record of quarantined volume %v: %v", vol.id, err)
+		}
+		quarantined = true
+		return nil
+	})
+	if err != nil {
+		return false, toStatusError(err)
+	}
+	return quarantined, nil
+}
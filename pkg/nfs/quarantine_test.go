@@ -0,0 +1,99 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestQuarantineIfForeign(t *testing.T) {
+	tests := []struct {
+		name string
+		// Cluster in the record, nil for no record
+		recordCluster *string
+		// Cluster in the metadata file in the volume, nil for no file
+		fileCluster *string
+		quarantined bool
+	}{
+		{name: "foreign", recordCluster: strPtr("other"), fileCluster: strPtr("other"), quarantined: true},
+		{name: "foreign with rewritten file", recordCluster: strPtr("other"), fileCluster: strPtr("test"), quarantined: true},
+		{name: "same cluster", recordCluster: strPtr("test"), fileCluster: strPtr("test")},
+		{name: "same cluster with rewritten file", recordCluster: strPtr("test"), fileCluster: strPtr("other")},
+		{name: "without cluster", recordCluster: strPtr(""), fileCluster: strPtr("")},
+		{name: "missing record", fileCluster: strPtr("other")},
+		{name: "missing record and file"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			workDir := t.TempDir()
+			cs := newTestControllerServer(WithWorkingMountDir(workDir))
+			cs.driver.ns = NewNodeServer(cs.driver)
+			cs.driver.clusterID = "test"
+			vol := &nfsVolume{server: "192.0.2.10", baseDir: "export", subDir: "pvc-1", name: "pvc-1"}
+			vol.id = cs.getVolumeIdFromNfsVol(vol)
+
+			// The fake mounter leaves the share at its mount path as it is
+			mountPath := filepath.Join(workDir, exportMountName(exportKey(vol)))
+			dir := filepath.Join(mountPath, vol.subDir)
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if test.recordCluster != nil {
+				if err := cs.writeVolumeMetadata(dir, &volumeMetadata{ClusterID: *test.recordCluster}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if test.fileCluster != nil {
+				data := []byte(`{"clusterID":"` + *test.fileCluster + `"}`)
+				if err := ioutil.WriteFile(filepath.Join(dir, volumeMetadataFile), data, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			quarantined, err := cs.quarantineIfForeign(context.Background(), vol)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if quarantined != test.quarantined {
+				t.Errorf("quarantineIfForeign() = %v, want %v", quarantined, test.quarantined)
+			}
+			_, statErr := os.Stat(dir)
+			if quarantined != os.IsNotExist(statErr) {
+				t.Errorf("volume directory was moved: %v, want %v", os.IsNotExist(statErr), quarantined)
+			}
+			moved, err := ioutil.ReadDir(filepath.Join(mountPath, cs.driver.quarantineDir))
+			if err != nil && !os.IsNotExist(err) {
+				t.Fatal(err)
+			}
+			if quarantined && len(moved) != 1 {
+				t.Errorf("quarantine directory has %d entries, want 1", len(moved))
+			}
+			if md, _ := readVolumeRecord(dir); quarantined && md != nil {
+				t.Errorf("record of the quarantined volume was kept")
+			}
+		})
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
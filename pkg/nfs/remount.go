@@ -0,0 +1,102 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/nfs/validation"
+)
+
+// Mount options the kernel lets a remount change on an nfs mount. All
+// other nfs options are fixed until the volume is mounted again.
+var remountableOptions = map[string]bool{
+	"ro":         true,
+	"rw":         true,
+	"noatime":    true,
+	"relatime":   true,
+	"nodiratime": true,
+}
+
+// parseMutableMountOptions parses the mountOptions parameter of
+// ControllerModifyVolume. Only remountable options may be given, and not
+// ro or rw, which follow the access mode of each publication.
+func parseMutableMountOptions(options string) ([]string, error) {
+	mo, err := validation.ParseMountOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range mo {
+		if !remountableOptions[o] || o == "ro" || o == "rw" {
+			return nil, fmt.Errorf("mount option %q cannot be modified", o)
+		}
+	}
+	return mo, nil
+}
+
+// remountIfChanged applies options to the existing mount at target with
+// "mount -o remount" where the kernel permits it. This lets a volume that
+// is published again with changed options pick them up without the pod
+// being restarted.
+func (ns *nodeServer) remountIfChanged(target string, options []string) error {
+	mounts, err := ns.mounter.List()
+	if err != nil {
+		return fmt.Errorf("failed to list mounts: %v", err)
+	}
+	var current map[string]bool
+	for _, mp := range mounts {
+		if mp.Path != target {
+			continue
+		}
+		// The last mount at target is the visible one
+		current = map[string]bool{}
+		for _, o := range mp.Opts {
+			current[o] = true
+		}
+	}
+	if current == nil {
+		return nil
+	}
+
+	var changes []string
+	readOnly := hasMountOption(options, "ro")
+	if readOnly != current["ro"] {
+		if readOnly {
+			changes = append(changes, "ro")
+		} else {
+			changes = append(changes, "rw")
+		}
+	}
+	for _, o := range options {
+		if o == "ro" || o == "rw" || current[o] {
+			continue
+		}
+		if remountableOptions[o] {
+			changes = append(changes, o)
+		} else {
+			glog.V(2).Infof("Mount option %q of %v can only be changed by mounting the volume again", o, target)
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	glog.V(2).Infof("Remounting %v with %v", target, changes)
+	return ns.mounter.Mount("", target, "", append([]string{"remount"}, changes...))
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// parallelRemover removes directory trees like os.RemoveAll, but issues
+// the removals of different entries concurrently. On NFS every unlink is a
+// round trip to the server, so latency rather than throughput bounds how
+// fast a large tree can be removed.
+type parallelRemover struct {
+	// Free worker slots. Entries are removed inline when none is free,
+	// so the recursion can never deadlock waiting for a worker.
+	slots chan struct{}
+	// run wraps the work of every worker goroutine, e.g. to set
+	// per-thread filesystem credentials
+	run func(func() error) error
+
+	errMutex sync.Mutex
+	err      error
+}
+
+// removeAllParallel removes path and any children it contains using up to
+// workers goroutines. It returns nil if path does not exist.
+func removeAllParallel(path string, workers int, run func(func() error) error) error {
+	if workers <= 1 {
+		return run(func() error { return os.RemoveAll(path) })
+	}
+
+	r := &parallelRemover{
+		slots: make(chan struct{}, workers-1),
+		run:   run,
+	}
+	if err := run(func() error { r.remove(path); return nil }); err != nil {
+		return err
+	}
+	return r.err
+}
+
+// remove removes path, recursing into it if it is a non-empty directory
+func (r *parallelRemover) remove(path string) {
+	err := os.Remove(path)
+	if err == nil || os.IsNotExist(err) {
+		return
+	}
+
+	names, readErr := readDirNames(path)
+	if readErr != nil {
+		// Not a directory, report why it could not be removed
+		if os.IsNotExist(readErr) {
+			return
+		}
+		r.setErr(err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, name := range names {
+		child := filepath.Join(path, name)
+		select {
+		case r.slots <- struct{}{}:
+			wg.Add(1)
+			go func() {
+				defer func() {
+					<-r.slots
+					wg.Done()
+				}()
+				if err := r.run(func() error { r.remove(child); return nil }); err != nil {
+					r.setErr(err)
+				}
+			}()
+		default:
+			r.remove(child)
+		}
+	}
+	wg.Wait()
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		r.setErr(err)
+	}
+}
+
+func (r *parallelRemover) setErr(err error) {
+	r.errMutex.Lock()
+	defer r.errMutex.Unlock()
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+func readDirNames(path string) ([]string, error) {
+	dir, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+	return dir.Readdirnames(-1)
+}
@@ -0,0 +1,271 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func runDirect(fn func() error) error {
+	return fn()
+}
+
+// makeTree creates depth levels of width directories below dir, with
+// width files in every directory
+func makeTree(t *testing.T, dir string, depth, width int) {
+	for i := 0; i < width; i++ {
+		if err := ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("file-%d", i)), []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if depth == 0 {
+		return
+	}
+	for i := 0; i < width; i++ {
+		sub := filepath.Join(dir, fmt.Sprintf("dir-%d", i))
+		if err := os.Mkdir(sub, 0755); err != nil {
+			t.Fatal(err)
+		}
+		makeTree(t, sub, depth-1, width)
+	}
+}
+
+func TestRemoveAllParallelNested(t *testing.T) {
+	for _, workers := range []int{0, 1, 2, 4, 64} {
+		t.Run(fmt.Sprintf("%d workers", workers), func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "volume")
+			if err := os.Mkdir(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			makeTree(t, dir, 4, 4)
+			// A deep chain keeps workers busy in the recursion
+			deep := dir
+			for i := 0; i < 50; i++ {
+				deep = filepath.Join(deep, "deep")
+			}
+			if err := os.MkdirAll(deep, 0755); err != nil {
+				t.Fatal(err)
+			}
+			makeTree(t, deep, 1, 3)
+
+			if err := removeAllParallel(dir, workers, runDirect); err != nil {
+				t.Fatalf("removeAllParallel() failed: %v", err)
+			}
+			if _, err := os.Lstat(dir); !os.IsNotExist(err) {
+				t.Errorf("%v still exists: %v", dir, err)
+			}
+		})
+	}
+}
+
+func TestRemoveAllParallelMissing(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "missing")
+	for _, workers := range []int{1, 4} {
+		if err := removeAllParallel(dir, workers, runDirect); err != nil {
+			t.Errorf("removeAllParallel() of a missing directory with %d workers failed: %v", workers, err)
+		}
+	}
+}
+
+func TestRemoveAllParallelSingleFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	if err := ioutil.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := removeAllParallel(path, 4, runDirect); err != nil {
+		t.Fatalf("removeAllParallel() failed: %v", err)
+	}
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Errorf("%v still exists: %v", path, err)
+	}
+}
+
+func TestRemoveAllParallelSymlinks(t *testing.T) {
+	root := t.TempDir()
+	outside := filepath.Join(root, "outside")
+	if err := os.Mkdir(outside, 0755); err != nil {
+		t.Fatal(err)
+	}
+	makeTree(t, outside, 1, 3)
+	dir := filepath.Join(root, "volume")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	makeTree(t, dir, 2, 3)
+	for _, link := range []string{
+		filepath.Join(dir, "link"),
+		filepath.Join(dir, "dir-0", "link"),
+		filepath.Join(dir, "dir-1", "dir-2", "link"),
+	} {
+		if err := os.Symlink(outside, link); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(outside, "file-0"), filepath.Join(dir, "file-link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("missing", filepath.Join(dir, "dangling")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := removeAllParallel(dir, 4, runDirect); err != nil {
+		t.Fatalf("removeAllParallel() failed: %v", err)
+	}
+	if _, err := os.Lstat(dir); !os.IsNotExist(err) {
+		t.Errorf("%v still exists: %v", dir, err)
+	}
+	// Symlinks are removed, not followed
+	for _, path := range []string{
+		filepath.Join(outside, "file-0"),
+		filepath.Join(outside, "dir-2", "file-2"),
+	} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("target of a symlink was removed: %v", err)
+		}
+	}
+}
+
+// TestRemoveAllParallelReadOnly removes trees with read-only directories
+// and compares the outcome with os.RemoveAll, which fails for them unless
+// the test runs as root
+func TestRemoveAllParallelReadOnly(t *testing.T) {
+	build := func(dir string) {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		makeTree(t, dir, 2, 3)
+		for _, sub := range []string{"dir-0", filepath.Join("dir-1", "dir-1")} {
+			if err := os.Chmod(filepath.Join(dir, sub), 0555); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	restore := func(dir string) {
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err == nil && info.IsDir() {
+				os.Chmod(path, 0755)
+			}
+			return nil
+		})
+	}
+
+	root := t.TempDir()
+	expected := filepath.Join(root, "expected")
+	build(expected)
+	defer restore(expected)
+	wantErr := os.RemoveAll(expected)
+
+	for _, workers := range []int{1, 4} {
+		dir := filepath.Join(root, fmt.Sprintf("volume-%d", workers))
+		build(dir)
+		defer restore(dir)
+
+		err := removeAllParallel(dir, workers, runDirect)
+		if (err != nil) != (wantErr != nil) {
+			t.Errorf("removeAllParallel() with %d workers = %v, os.RemoveAll() = %v", workers, err, wantErr)
+		}
+		if err != nil && !os.IsPermission(err) {
+			t.Errorf("removeAllParallel() with %d workers = %v, want a permission error", workers, err)
+		}
+		if os.Geteuid() != 0 {
+			// Everything outside of the read-only directories is gone
+			for _, path := range []string{"file-0", "dir-2", filepath.Join("dir-1", "file-0"), filepath.Join("dir-1", "dir-0")} {
+				if _, err := os.Lstat(filepath.Join(dir, path)); !os.IsNotExist(err) {
+					t.Errorf("%v was not removed with %d workers: %v", path, workers, err)
+				}
+			}
+			if _, err := os.Lstat(filepath.Join(dir, "dir-0", "file-0")); err != nil {
+				t.Errorf("entry of a read-only directory is gone: %v", err)
+			}
+		}
+	}
+}
+
+func TestRemoveAllParallelWorkerErrors(t *testing.T) {
+	errNoCredentials := errors.New("failed to set credentials")
+	dir := filepath.Join(t.TempDir(), "volume")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	makeTree(t, dir, 3, 5)
+
+	// Every third worker fails to start while the others are busy
+	// removing their subtrees
+	var calls, running, maxRunning int32
+	var mutex sync.Mutex
+	run := func(fn func() error) error {
+		n := atomic.AddInt32(&calls, 1)
+		if n > 1 && n%3 == 0 {
+			return errNoCredentials
+		}
+		r := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		mutex.Lock()
+		if r > maxRunning {
+			maxRunning = r
+		}
+		mutex.Unlock()
+		return fn()
+	}
+
+	err := removeAllParallel(dir, 4, run)
+	if err != errNoCredentials {
+		t.Errorf("removeAllParallel() = %v, want %v", err, errNoCredentials)
+	}
+	if atomic.LoadInt32(&running) != 0 {
+		t.Errorf("%d workers still running after removeAllParallel() returned", running)
+	}
+	// The caller and up to 3 workers
+	if maxRunning > 4 {
+		t.Errorf("%d workers ran at a time, want at most 4", maxRunning)
+	}
+	if _, err := os.Lstat(dir); err != nil {
+		t.Errorf("%v was removed although removing entries failed: %v", dir, err)
+	}
+
+	// The remains are removed by the next attempt
+	if err := removeAllParallel(dir, 4, runDirect); err != nil {
+		t.Fatalf("second removeAllParallel() failed: %v", err)
+	}
+	if _, err := os.Lstat(dir); !os.IsNotExist(err) {
+		t.Errorf("%v still exists: %v", dir, err)
+	}
+}
+
+func TestRemoveAllParallelCallerError(t *testing.T) {
+	errNoCredentials := errors.New("failed to set credentials")
+	dir := filepath.Join(t.TempDir(), "volume")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	run := func(func() error) error { return errNoCredentials }
+	for _, workers := range []int{1, 4} {
+		if err := removeAllParallel(dir, workers, run); err != errNoCredentials {
+			t.Errorf("removeAllParallel() with %d workers = %v, want %v", workers, err, errNoCredentials)
+		}
+	}
+	if _, err := os.Lstat(dir); err != nil {
+		t.Errorf("%v was removed: %v", dir, err)
+	}
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// resolver resolves nfs server hostnames before mounting. Successful
+// lookups are cached for a short time, so that bulk provisioning does not
+// look up the same name over and over again. Failed lookups are never
+// cached, so a DNS blip only fails the mounts attempted during it.
+//
+// All addresses of a name are cached, of both families, and handed out in
+// turn. No address is pinned for the lifetime of the cache entry, so that
+// servers behind round-robin DNS get their share of the mounts and a dead
+// address does not fail every mount until the entry expires.
+type resolver struct {
+	ttl    time.Duration
+	lookup func(host string) ([]string, error)
+
+	mutex sync.Mutex
+	cache map[string]*resolverEntry
+}
+
+type resolverEntry struct {
+	addrs   []net.IP
+	expires time.Time
+	// Index of the address to hand out next
+	next int
+}
+
+func newResolver(ttl time.Duration) *resolver {
+	return &resolver{
+		ttl:    ttl,
+		lookup: net.LookupHost,
+		cache:  map[string]*resolverEntry{},
+	}
+}
+
+// resolve returns an address of host in network, which is "ip4", "ip6"
+// or "ip" for either family. It returns host itself if host is an IP
+// address or has no address in network, leaving the lookup to the mount
+// helper.
+func (r *resolver) resolve(host, network string) string {
+	if net.ParseIP(host) != nil {
+		return host
+	}
+
+	r.mutex.Lock()
+	entry, ok := r.cache[host]
+	if ok && time.Now().Before(entry.expires) {
+		defer r.mutex.Unlock()
+		return entry.pick(host, network)
+	}
+	r.mutex.Unlock()
+
+	addrs, err := r.lookup(host)
+	if err != nil {
+		glog.Warningf("failed to resolve %v: %v", host, err)
+		return host
+	}
+	entry = &resolverEntry{expires: time.Now().Add(r.ttl)}
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip != nil {
+			entry.addrs = append(entry.addrs, ip)
+		}
+	}
+	if len(entry.addrs) == 0 {
+		return host
+	}
+	glog.V(4).Infof("Resolved %v to %v", host, addrs)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.cache[host] = entry
+	return entry.pick(host, network)
+}
+
+// pick returns the next address of the entry in network, or host if it
+// has none
+func (e *resolverEntry) pick(host, network string) string {
+	for i := 0; i < len(e.addrs); i++ {
+		ip := e.addrs[(e.next+i)%len(e.addrs)]
+		isIPv4 := ip.To4() != nil
+		if network == "ip4" && !isIPv4 || network == "ip6" && isIPv4 {
+			continue
+		}
+		e.next = (e.next + i + 1) % len(e.addrs)
+		return ip.String()
+	}
+	return host
+}
+
+// mountNetwork returns the address family the proto mount option asks
+// for, "ip" for either
+func mountNetwork(options []string) string {
+	network := "ip"
+	for _, o := range options {
+		parts := strings.SplitN(o, "=", 2)
+		if len(parts) != 2 || !strings.EqualFold(parts[0], "proto") {
+			continue
+		}
+		// The last proto option wins, as in mount.nfs
+		switch strings.ToLower(parts[1]) {
+		case "tcp", "udp", "rdma":
+			network = "ip4"
+		case "tcp6", "udp6", "rdma6":
+			network = "ip6"
+		default:
+			network = "ip"
+		}
+	}
+	return network
+}
+
+// usesKerberos reports whether the mount options select a kerberos
+// security flavor, which needs the server's hostname
+func usesKerberos(options []string) bool {
+	for _, o := range options {
+		parts := strings.SplitN(o, "=", 2)
+		if len(parts) == 2 && strings.EqualFold(parts[0], "sec") && strings.HasPrefix(strings.ToLower(parts[1]), "krb5") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeLookup answers lookups from addrs, failing for names without
+// addresses, and counts the lookups of every name
+type fakeLookup struct {
+	addrs   map[string][]string
+	lookups map[string]int
+}
+
+func newFakeLookup(addrs map[string][]string) *fakeLookup {
+	return &fakeLookup{addrs: addrs, lookups: map[string]int{}}
+}
+
+func (f *fakeLookup) lookup(host string) ([]string, error) {
+	f.lookups[host]++
+	addrs, ok := f.addrs[host]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return addrs, nil
+}
+
+func TestResolverFamilies(t *testing.T) {
+	f := newFakeLookup(map[string][]string{
+		"v4.example.com":   {"192.0.2.10"},
+		"v6.example.com":   {"fd00::10"},
+		"dual.example.com": {"fd00::10", "192.0.2.10"},
+	})
+	r := newResolver(time.Minute)
+	r.lookup = f.lookup
+
+	tests := []struct {
+		host    string
+		network string
+		want    string
+	}{
+		{"v4.example.com", "ip", "192.0.2.10"},
+		{"v4.example.com", "ip4", "192.0.2.10"},
+		{"v4.example.com", "ip6", "v4.example.com"},
+		{"v6.example.com", "ip", "fd00::10"},
+		{"v6.example.com", "ip6", "fd00::10"},
+		{"v6.example.com", "ip4", "v6.example.com"},
+		{"dual.example.com", "ip4", "192.0.2.10"},
+		{"dual.example.com", "ip6", "fd00::10"},
+		{"192.0.2.20", "ip", "192.0.2.20"},
+		{"fd00::20", "ip4", "fd00::20"},
+	}
+	for _, test := range tests {
+		if got := r.resolve(test.host, test.network); got != test.want {
+			t.Errorf("resolve(%q, %q) = %q, want %q", test.host, test.network, got, test.want)
+		}
+	}
+	if f.lookups["192.0.2.20"] != 0 || f.lookups["fd00::20"] != 0 {
+		t.Errorf("IP addresses were looked up")
+	}
+}
+
+func TestResolverRotates(t *testing.T) {
+	f := newFakeLookup(map[string][]string{
+		"nfs.example.com": {"192.0.2.10", "fd00::10", "192.0.2.11"},
+	})
+	r := newResolver(time.Minute)
+	r.lookup = f.lookup
+
+	var got []string
+	for i := 0; i < 6; i++ {
+		got = append(got, r.resolve("nfs.example.com", "ip"))
+	}
+	want := []string{"192.0.2.10", "fd00::10", "192.0.2.11", "192.0.2.10", "fd00::10", "192.0.2.11"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("resolve() returned %v, want %v", got, want)
+		}
+	}
+
+	// Addresses of other families are skipped
+	got = nil
+	for i := 0; i < 4; i++ {
+		got = append(got, r.resolve("nfs.example.com", "ip4"))
+	}
+	want = []string{"192.0.2.10", "192.0.2.11", "192.0.2.10", "192.0.2.11"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("resolve() of IPv4 addresses returned %v, want %v", got, want)
+		}
+	}
+	if n := f.lookups["nfs.example.com"]; n != 1 {
+		t.Errorf("nfs.example.com was looked up %d times, want once", n)
+	}
+}
+
+func TestResolverExpires(t *testing.T) {
+	f := newFakeLookup(map[string][]string{"nfs.example.com": {"192.0.2.10"}})
+	r := newResolver(50 * time.Millisecond)
+	r.lookup = f.lookup
+
+	r.resolve("nfs.example.com", "ip")
+	r.resolve("nfs.example.com", "ip")
+	if n := f.lookups["nfs.example.com"]; n != 1 {
+		t.Fatalf("nfs.example.com was looked up %d times within the ttl, want once", n)
+	}
+
+	// The new address is used once the entry expired
+	f.addrs["nfs.example.com"] = []string{"192.0.2.11"}
+	time.Sleep(60 * time.Millisecond)
+	if got := r.resolve("nfs.example.com", "ip"); got != "192.0.2.11" {
+		t.Errorf("resolve() after the ttl = %q, want 192.0.2.11", got)
+	}
+	if n := f.lookups["nfs.example.com"]; n != 2 {
+		t.Errorf("nfs.example.com was looked up %d times, want twice", n)
+	}
+}
+
+func TestResolverNoNegativeCaching(t *testing.T) {
+	f := newFakeLookup(map[string][]string{})
+	r := newResolver(time.Minute)
+	r.lookup = f.lookup
+
+	// A failed lookup leaves resolving to the mount helper and is retried
+	// on the next mount
+	for i := 1; i <= 3; i++ {
+		if got := r.resolve("nfs.example.com", "ip"); got != "nfs.example.com" {
+			t.Errorf("resolve() during a DNS failure = %q, want the hostname", got)
+		}
+		if n := f.lookups["nfs.example.com"]; n != i {
+			t.Fatalf("nfs.example.com was looked up %d times after %d mounts, want %d", n, i, i)
+		}
+	}
+
+	// The first lookup after the failure is used and cached
+	f.addrs["nfs.example.com"] = []string{"192.0.2.10"}
+	for i := 0; i < 2; i++ {
+		if got := r.resolve("nfs.example.com", "ip"); got != "192.0.2.10" {
+			t.Errorf("resolve() after the DNS failure = %q, want 192.0.2.10", got)
+		}
+	}
+	if n := f.lookups["nfs.example.com"]; n != 4 {
+		t.Errorf("nfs.example.com was looked up %d times, want 4", n)
+	}
+
+	// Answers without addresses are not cached either
+	f.addrs["empty.example.com"] = []string{}
+	r.resolve("empty.example.com", "ip")
+	r.resolve("empty.example.com", "ip")
+	if n := f.lookups["empty.example.com"]; n != 2 {
+		t.Errorf("empty.example.com was looked up %d times, want twice", n)
+	}
+}
+
+func TestResolverFailureAfterExpiry(t *testing.T) {
+	f := newFakeLookup(map[string][]string{"nfs.example.com": {"192.0.2.10"}})
+	r := newResolver(10 * time.Millisecond)
+	r.lookup = f.lookup
+
+	r.resolve("nfs.example.com", "ip")
+	time.Sleep(20 * time.Millisecond)
+	// An expired address is not used when the lookup fails
+	delete(f.addrs, "nfs.example.com")
+	if got := r.resolve("nfs.example.com", "ip"); got != "nfs.example.com" {
+		t.Errorf("resolve() with an expired entry during a DNS failure = %q, want the hostname", got)
+	}
+}
+
+func TestMountNetwork(t *testing.T) {
+	tests := []struct {
+		options []string
+		want    string
+	}{
+		{nil, "ip"},
+		{[]string{"nfsvers=4.1", "hard"}, "ip"},
+		{[]string{"proto=tcp"}, "ip4"},
+		{[]string{"proto=UDP"}, "ip4"},
+		{[]string{"proto=rdma"}, "ip4"},
+		{[]string{"proto=tcp6"}, "ip6"},
+		{[]string{"proto=udp6"}, "ip6"},
+		{[]string{"proto=tcp", "proto=tcp6"}, "ip6"},
+		{[]string{"mountproto=udp"}, "ip"},
+	}
+	for _, test := range tests {
+		if got := mountNetwork(test.options); got != test.want {
+			t.Errorf("mountNetwork(%v) = %q, want %q", test.options, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/nfs/validation"
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/nfs/volume"
+)
+
+// checkRestoreSource checks that the snapshot with id can be restored
+// into vol and returns its parsed id. Snapshots are restored from the
+// share of the new volume.
+func (cs *controllerServer) checkRestoreSource(ctx context.Context, vol *nfsVolume, id string) (*validation.SnapshotID, error) {
+	if vol.subDir == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "snapshots cannot be restored into volumes that share the base directory")
+	}
+	snap, err := volume.ParseSnapshotID(id)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "snapshot %v not found: %v", id, err)
+	}
+	if snap.Server != vol.server || snap.BaseDir != strings.Trim(vol.baseDir, "/") {
+		return nil, status.Errorf(codes.InvalidArgument, "snapshot %v is on another share than %v:%v", id, vol.server, vol.baseDir)
+	}
+	if job, ok := cs.snapshots.get(id); ok && !job.done {
+		return nil, status.Errorf(codes.Unavailable, "snapshot %v is still being archived", id)
+	}
+	info, err := cs.readSnapshotInfo(ctx, vol, snap.SnapshotsDir, snap.Name)
+	if err != nil {
+		return nil, err
+	}
+	if info == nil {
+		return nil, status.Errorf(codes.NotFound, "snapshot %v not found", id)
+	}
+	return snap, nil
+}
+
+// How often the progress of a running restore is logged
+const restoreProgressInterval = 30 * time.Second
+
+// restoreReader counts the compressed bytes read from an archive and fails
+// reads once ctx is done, so that a canceled restore stops in the middle
+// of a large file instead of after it.
+type restoreReader struct {
+	ctx context.Context
+	r   io.Reader
+	n   int64
+}
+
+func (r *restoreReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// restoreArchive extracts the archive src, as written by writeArchive,
+// into dir. Progress is logged and reported in the
+// csi_nfs_restore_progress_ratio metric of volumeID while the archive is
+// read. File contents are written through throttle. If extracting fails
+// or ctx is done before the archive is complete, dir is removed together
+// with whatever was restored so far, so that a half restored volume is
+// never handed out.
+func restoreArchive(ctx context.Context, volumeID, src, dir string, throttle func(io.Writer) io.Writer) (err error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		restoreProgress.DeleteLabelValues(volumeID)
+		if err == nil {
+			return
+		}
+		if rmErr := os.RemoveAll(dir); rmErr != nil {
+			glog.Errorf("failed to remove partially restored %v: %v", dir, rmErr)
+		} else {
+			glog.V(2).Infof("Removed partially restored %v", dir)
+		}
+	}()
+
+	r := &restoreReader{ctx: ctx, r: bufio.NewReader(f)}
+	total := info.Size()
+	progress := func() float64 {
+		if total == 0 {
+			return 1
+		}
+		return float64(r.n) / float64(total)
+	}
+	glog.V(2).Infof("Restoring volume %v from %v (%d bytes)", volumeID, src, total)
+	restoreProgress.WithLabelValues(volumeID).Set(0)
+	lastLog := time.Now()
+
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("failed to read archive %v: %v", src, err)
+	}
+	tr := tar.NewReader(gr)
+	// Directories get their final mode after all their entries are written,
+	// a read-only directory could not be filled otherwise.
+	var dirs []*tar.Header
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			return fmt.Errorf("failed to read archive %v: %v", src, err)
+		}
+		if err := extractEntry(tr, hdr, dir, throttle); err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			return err
+		}
+		if hdr.Typeflag == tar.TypeDir && path.Clean(hdr.Name) != "." {
+			dirs = append(dirs, hdr)
+		}
+
+		restoreProgress.WithLabelValues(volumeID).Set(progress())
+		if time.Since(lastLog) >= restoreProgressInterval {
+			glog.Infof("Restoring volume %v: %.0f%% of %v read", volumeID, progress()*100, src)
+			lastLog = time.Now()
+		}
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		target := filepath.Join(dir, dirs[i].Name)
+		if err := os.Chmod(target, dirs[i].FileInfo().Mode().Perm()); err != nil {
+			return err
+		}
+		if err := os.Chtimes(target, dirs[i].ModTime, dirs[i].ModTime); err != nil {
+			return err
+		}
+	}
+	glog.V(2).Infof("Restored volume %v from %v", volumeID, src)
+	return nil
+}
+
+// extractEntry writes the archive entry hdr below dir
+func extractEntry(tr *tar.Reader, hdr *tar.Header, dir string, throttle func(io.Writer) io.Writer) error {
+	if hdr.Name == "" || strings.HasPrefix(hdr.Name, "/") || !validation.IsSafeRelativePath(hdr.Name) {
+		return fmt.Errorf("archive entry %q is outside of the volume", hdr.Name)
+	}
+	name := path.Clean(hdr.Name)
+	if name == "." {
+		// The restored volume gets a mode of its own
+		return nil
+	}
+	if name == volumeMetadataFile {
+		// The restored volume gets metadata of its own
+		return nil
+	}
+	target := filepath.Join(dir, name)
+	// Entries must not be written through symlinks restored earlier
+	parent, err := filepath.EvalSymlinks(filepath.Dir(target))
+	if err != nil {
+		return err
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	if parent != root && !strings.HasPrefix(parent, root+"/") {
+		return fmt.Errorf("archive entry %q is outside of the volume", hdr.Name)
+	}
+
+	mode := hdr.FileInfo().Mode().Perm()
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.Mkdir(target, 0700); os.IsExist(err) {
+			// Its mode is set later, which must not follow a symlink
+			if info, err := os.Lstat(target); err != nil || !info.IsDir() {
+				return fmt.Errorf("archive entry %q replaces a file that is not a directory", hdr.Name)
+			}
+		} else if err != nil {
+			return err
+		}
+	case tar.TypeReg, tar.TypeRegA:
+		f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(throttle(f), tr)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		if err := os.Chmod(target, mode); err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		if err := os.Symlink(hdr.Linkname, target); err != nil {
+			return err
+		}
+	default:
+		glog.V(4).Infof("Skipping %v of type %c while restoring", hdr.Name, hdr.Typeflag)
+		return nil
+	}
+
+	if err := os.Lchown(target, hdr.Uid, hdr.Gid); err != nil && !os.IsPermission(err) {
+		return err
+	}
+	if hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeRegA {
+		return os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+	}
+	return nil
+}
@@ -0,0 +1,408 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func noThrottle(w io.Writer) io.Writer {
+	return w
+}
+
+// archiveEntry is an entry of a test archive
+type archiveEntry struct {
+	name     string
+	typeflag byte
+	mode     int64
+	linkname string
+	content  string
+}
+
+func writeTestArchive(t *testing.T, path string, entries []archiveEntry) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:     e.name,
+			Typeflag: e.typeflag,
+			Mode:     e.mode,
+			Linkname: e.linkname,
+			Size:     int64(len(e.content)),
+			ModTime:  time.Now(),
+			Uid:      os.Getuid(),
+			Gid:      os.Getgid(),
+		}
+		if hdr.Mode == 0 {
+			hdr.Mode = 0644
+		}
+		if e.typeflag != tar.TypeReg {
+			hdr.Size = 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Size > 0 {
+			if _, err := tw.Write([]byte(e.content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// snapshotTree returns the entries below dir with their modes and the
+// contents of regular files
+func snapshotTree(t *testing.T, dir string) map[string]string {
+	tree := map[string]string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		entry := info.Mode().String()
+		if info.Mode().IsRegular() {
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			entry += " " + string(data)
+		}
+		tree[rel] = entry
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+func TestRestoreArchive(t *testing.T) {
+	root := t.TempDir()
+	source := filepath.Join(root, "source")
+	for _, dir := range []string{"a", "a/b", "ro"} {
+		if err := os.MkdirAll(filepath.Join(source, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for path, content := range map[string]string{"top": "top", "a/b/file": "nested", "ro/file": "read-only"} {
+		if err := ioutil.WriteFile(filepath.Join(source, path), []byte(content), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("b/file", filepath.Join(source, "a", "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/etc/hostname", filepath.Join(source, "absolute-link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(source, "ro"), 0555); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(filepath.Join(source, "ro"), 0755)
+	archive := filepath.Join(root, "snapshot.tar.gz")
+	if err := writeArchive(source, archive, noThrottle); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(root, "restored")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(filepath.Join(dir, "ro"), 0755)
+	if err := restoreArchive(context.Background(), "vol-1", archive, dir, noThrottle); err != nil {
+		t.Fatalf("restoreArchive() failed: %v", err)
+	}
+	want := snapshotTree(t, source)
+	got := snapshotTree(t, dir)
+	delete(want, ".")
+	delete(got, ".")
+	for path, entry := range want {
+		if got[path] != entry {
+			t.Errorf("restored %v is %q, want %q", path, got[path], entry)
+		}
+	}
+	for path := range got {
+		if _, ok := want[path]; !ok {
+			t.Errorf("restored %v is not in the archive", path)
+		}
+	}
+}
+
+func TestRestoreArchiveMalicious(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []archiveEntry
+		// Whether the restore fails, all entries are skipped otherwise
+		wantErr bool
+	}{
+		{
+			name:    "absolute path",
+			entries: []archiveEntry{{name: "/OUTSIDE/evil", typeflag: tar.TypeReg, content: "evil"}},
+			wantErr: true,
+		},
+		{
+			name:    "parent directory",
+			entries: []archiveEntry{{name: "../outside/evil", typeflag: tar.TypeReg, content: "evil"}},
+			wantErr: true,
+		},
+		{
+			name: "parent directory within path",
+			entries: []archiveEntry{
+				{name: "a/", typeflag: tar.TypeDir, mode: 0755},
+				{name: "a/../../outside/evil", typeflag: tar.TypeReg, content: "evil"},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "parent directory of directory",
+			entries: []archiveEntry{{name: "../outside/evil/", typeflag: tar.TypeDir, mode: 0777}},
+			wantErr: true,
+		},
+		{
+			name: "file through symlink",
+			entries: []archiveEntry{
+				{name: "link", typeflag: tar.TypeSymlink, linkname: "OUTSIDE"},
+				{name: "link/evil", typeflag: tar.TypeReg, content: "evil"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "file through relative symlink",
+			entries: []archiveEntry{
+				{name: "link", typeflag: tar.TypeSymlink, linkname: "../outside"},
+				{name: "link/evil", typeflag: tar.TypeReg, content: "evil"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "file through symlink in subdirectory",
+			entries: []archiveEntry{
+				{name: "a/", typeflag: tar.TypeDir, mode: 0755},
+				{name: "a/link", typeflag: tar.TypeSymlink, linkname: "../../outside"},
+				{name: "a/link/evil", typeflag: tar.TypeReg, content: "evil"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "file replacing symlink",
+			entries: []archiveEntry{
+				{name: "link", typeflag: tar.TypeSymlink, linkname: "OUTSIDE/secret"},
+				{name: "link", typeflag: tar.TypeReg, content: "evil"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "directory replacing symlink",
+			entries: []archiveEntry{
+				{name: "link", typeflag: tar.TypeSymlink, linkname: "OUTSIDE"},
+				{name: "link/", typeflag: tar.TypeDir, mode: 0777},
+			},
+			wantErr: true,
+		},
+		{
+			name: "directory through symlink",
+			entries: []archiveEntry{
+				{name: "link", typeflag: tar.TypeSymlink, linkname: "OUTSIDE"},
+				{name: "link/evil/", typeflag: tar.TypeDir, mode: 0777},
+			},
+			wantErr: true,
+		},
+		{
+			name: "symlink through symlink",
+			entries: []archiveEntry{
+				{name: "link", typeflag: tar.TypeSymlink, linkname: "OUTSIDE"},
+				{name: "link/evil", typeflag: tar.TypeSymlink, linkname: "/"},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "hard link",
+			entries: []archiveEntry{{name: "hard", typeflag: tar.TypeLink, linkname: "OUTSIDE/secret"}},
+		},
+		{
+			name:    "relative hard link",
+			entries: []archiveEntry{{name: "hard", typeflag: tar.TypeLink, linkname: "../outside/secret"}},
+		},
+		{
+			name:    "device",
+			entries: []archiveEntry{{name: "dev", typeflag: tar.TypeChar}},
+		},
+		{
+			name:    "metadata symlink",
+			entries: []archiveEntry{{name: volumeMetadataFile, typeflag: tar.TypeSymlink, linkname: "OUTSIDE/secret"}},
+		},
+		{
+			name:    "metadata symlink with dot",
+			entries: []archiveEntry{{name: "./" + volumeMetadataFile, typeflag: tar.TypeSymlink, linkname: "OUTSIDE/secret"}},
+		},
+		{
+			name:    "volume directory",
+			entries: []archiveEntry{{name: "./", typeflag: tar.TypeDir, mode: 0777}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := t.TempDir()
+			outside := filepath.Join(root, "outside")
+			if err := os.Mkdir(outside, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			before := snapshotTree(t, outside)
+
+			entries := make([]archiveEntry, len(test.entries))
+			for i, e := range test.entries {
+				e.name = replaceOutside(e.name, outside)
+				e.linkname = replaceOutside(e.linkname, outside)
+				entries[i] = e
+			}
+			archive := filepath.Join(root, "snapshot.tar.gz")
+			writeTestArchive(t, archive, entries)
+			dir := filepath.Join(root, "volume")
+			if err := os.Mkdir(dir, 0700); err != nil {
+				t.Fatal(err)
+			}
+
+			err := restoreArchive(context.Background(), "vol-1", archive, dir, noThrottle)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("restoreArchive() succeeded, want error")
+				}
+				if _, statErr := os.Lstat(dir); !os.IsNotExist(statErr) {
+					t.Errorf("partially restored %v was kept: %v", dir, statErr)
+				}
+			} else {
+				if err != nil {
+					t.Errorf("restoreArchive() failed: %v", err)
+				}
+				infos, err := ioutil.ReadDir(dir)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, info := range infos {
+					t.Errorf("%v was restored", info.Name())
+				}
+				if info, err := os.Lstat(dir); err != nil || info.Mode().Perm() != 0700 {
+					t.Errorf("volume directory changed: %v %v", info.Mode(), err)
+				}
+			}
+
+			after := snapshotTree(t, outside)
+			if len(after) != len(before) {
+				t.Errorf("entries outside of the volume changed from %v to %v", before, after)
+			}
+			for path, entry := range before {
+				if after[path] != entry {
+					t.Errorf("%v outside of the volume changed from %q to %q", path, entry, after[path])
+				}
+			}
+		})
+	}
+}
+
+// replaceOutside replaces OUTSIDE in s by the directory outside
+func replaceOutside(s, outside string) string {
+	return strings.Replace(s, "OUTSIDE", outside, 1)
+}
+
+func TestRestoreArchiveCanceled(t *testing.T) {
+	root := t.TempDir()
+	archive := filepath.Join(root, "snapshot.tar.gz")
+	writeTestArchive(t, archive, []archiveEntry{
+		{name: "a/", typeflag: tar.TypeDir, mode: 0755},
+		{name: "a/first", typeflag: tar.TypeReg, content: "first"},
+		{name: "a/second", typeflag: tar.TypeReg, content: "second"},
+		{name: "a/third", typeflag: tar.TypeReg, content: "third"},
+	})
+	dir := filepath.Join(root, "volume")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cancel once the first file is written
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	throttle := func(w io.Writer) io.Writer {
+		return writerFunc(func(p []byte) (int, error) {
+			n, err := w.Write(p)
+			cancel()
+			return n, err
+		})
+	}
+	err := restoreArchive(ctx, "vol-1", archive, dir, throttle)
+	if err != context.Canceled {
+		t.Errorf("restoreArchive() = %v, want %v", err, context.Canceled)
+	}
+	if _, err := os.Lstat(dir); !os.IsNotExist(err) {
+		t.Errorf("partially restored %v was kept: %v", dir, err)
+	}
+}
+
+func TestRestoreArchiveTruncated(t *testing.T) {
+	root := t.TempDir()
+	archive := filepath.Join(root, "snapshot.tar.gz")
+	writeTestArchive(t, archive, []archiveEntry{
+		{name: "file", typeflag: tar.TypeReg, content: string(bytes.Repeat([]byte("data"), 4096))},
+	})
+	data, err := ioutil.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(archive, data[:len(data)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(root, "volume")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := restoreArchive(context.Background(), "vol-1", archive, dir, noThrottle); err == nil {
+		t.Errorf("restoreArchive() of a truncated archive succeeded")
+	}
+	if _, err := os.Lstat(dir); !os.IsNotExist(err) {
+		t.Errorf("partially restored %v was kept: %v", dir, err)
+	}
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
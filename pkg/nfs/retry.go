@@ -0,0 +1,124 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RetryPolicy is how often and how long the driver retries mounts and
+// deletions that fail within a single request. It is shared by the
+// controller mounts, the deletion of volume data and the node mounts.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts, including the first one. 1
+	// or less disables retries.
+	MaxAttempts int
+	// BaseDelay is the delay after the first failed attempt. It doubles
+	// after every further attempt, up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// RetryableCodes are the gRPC codes of the errors that are retried
+	RetryableCodes []codes.Code
+}
+
+// DefaultRetryPolicy does not retry, leaving retries to the caller of the
+// CSI request
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    1,
+	BaseDelay:      time.Second,
+	MaxDelay:       30 * time.Second,
+	RetryableCodes: []codes.Code{codes.Unavailable, codes.Internal},
+}
+
+// ParseCodes parses gRPC code names such as "UNAVAILABLE" or "Internal",
+// e.g. for RetryPolicy.RetryableCodes
+func ParseCodes(names []string) ([]codes.Code, error) {
+	var result []codes.Code
+	for _, name := range names {
+		var c codes.Code
+		quoted := fmt.Sprintf("%q", strings.ToUpper(strings.TrimSpace(name)))
+		if err := json.Unmarshal([]byte(quoted), &c); err != nil {
+			return nil, fmt.Errorf("unknown gRPC code %q", name)
+		}
+		result = append(result, c)
+	}
+	return result, nil
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	c := status.Code(err)
+	for _, r := range p.RetryableCodes {
+		if c == r {
+			return true
+		}
+	}
+	return false
+}
+
+// delay returns the delay after the given failed attempt, counting from 1
+func (p *RetryPolicy) delay(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if p.MaxDelay > 0 && d >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return d
+}
+
+// isCanceled reports whether err means the request was given up, which
+// says nothing about the server
+func isCanceled(err error) bool {
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return true
+	}
+	if s, ok := status.FromError(err); ok && err != nil {
+		return s.Code() == codes.Canceled || s.Code() == codes.DeadlineExceeded
+	}
+	return false
+}
+
+// do runs fn until it succeeds, fails with an error that is not
+// retryable, the attempts are used up or ctx is done. fn should return
+// status errors; op describes it in the log.
+func (p *RetryPolicy) do(ctx context.Context, op string, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.MaxAttempts || isCanceled(err) || !p.retryable(err) {
+			return err
+		}
+		delay := p.delay(attempt)
+		glog.Warningf("%s failed (attempt %d of %d), retrying in %v: %v", op, attempt, p.MaxAttempts, delay, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/kubernetes-csi/csi-test/pkg/sanity"
+	"github.com/onsi/ginkgo/config"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"k8s.io/kubernetes/pkg/util/mount"
+)
+
+const (
+	sanityServer = "127.0.0.1"
+	sanityShare  = "/export"
+)
+
+// sanityParameters fills in the test parameters of CreateVolume requests
+// without parameters. csi-test 1.1.0 creates the volume of "Node Service
+// should work" without them, and the driver has no default share to
+// provision on.
+func sanityParameters(parameters map[string]string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if r, ok := req.(*csi.CreateVolumeRequest); ok && len(r.Parameters) == 0 {
+			r.Parameters = parameters
+		}
+		return handler(ctx, req)
+	}
+}
+
+// TestSanity runs the csi-test sanity suite against a driver on a unix
+// socket. The fake mounter does not mount anything, so the share is just
+// a directory below the working mount directory of the controller.
+func TestSanity(t *testing.T) {
+	// csi-test 1.1.0 fails on capabilities of newer spec versions, whose
+	// RPCs are covered by the unit tests
+	skip := `ControllerGetCapabilities should return appropriate capabilities`
+	skip += `|NodeGetCapabilities should return appropriate capabilities`
+	if config.GinkgoConfig.SkipString != "" {
+		skip = config.GinkgoConfig.SkipString + "|" + skip
+	}
+	config.GinkgoConfig.SkipString = skip
+
+	dir, err := ioutil.TempDir("", "csi-nfs-sanity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	workDir := filepath.Join(dir, "work")
+	if err := os.Mkdir(workDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	endpoint := "unix://" + filepath.Join(dir, "csi.sock")
+	options := DefaultDriverOptions()
+	options.NodeID = "sanity"
+	options.Endpoint = endpoint
+	options.WorkingMountDir = workDir
+	options.Mounter = &mount.FakeMounter{}
+	// ListSnapshots without a snapshot or source volume lists the shares
+	options.Shares = []string{sanityServer + ":" + sanityShare}
+	parameters := map[string]string{
+		paramServer: sanityServer,
+		paramShare:  sanityShare,
+	}
+	d := New(WithDriverOptions(options), WithInterceptors(sanityParameters(parameters)))
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop()
+
+	sanity.Test(t, &sanity.Config{
+		Address:              endpoint,
+		TargetPath:           filepath.Join(dir, "target"),
+		StagingPath:          filepath.Join(dir, "staging"),
+		TestVolumeParameters: parameters,
+	})
+}
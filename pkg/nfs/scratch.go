@@ -0,0 +1,133 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/nfs/volume"
+)
+
+// Layout of the scratch directory of a published volume
+const (
+	// The nfs share, mounted read-only
+	scratchLowerDir = "lower"
+	// Holds the overlay upper and work directories, which must be on the
+	// same filesystem; a tmpfs for memory backed scratch space
+	scratchRWDir    = "rw"
+	scratchUpperDir = "upper"
+	scratchWorkDir  = "work"
+)
+
+// scratchPath returns the scratch directory of a target path
+func (ns *nodeServer) scratchPath(targetPath string) string {
+	h := fnv.New64a()
+	h.Write([]byte(targetPath))
+	return filepath.Join(ns.driver.scratchDir, fmt.Sprintf("%x", h.Sum64()))
+}
+
+// mountScratchOverlay mounts the share read-only below a local scratch
+// directory and publishes an overlay of both at targetPath. Pods get fast
+// write access to shared read-mostly data, and their writes are discarded
+// when the volume is unpublished.
+func (ns *nodeServer) mountScratchOverlay(ctx context.Context, source, targetPath string, mo []string, volCtx *volume.Context) (err error) {
+	if ns.driver.scratchDir == "" {
+		return fmt.Errorf("scratch overlays are disabled on this node: invalid argument")
+	}
+
+	dir := ns.scratchPath(targetPath)
+	lower := filepath.Join(dir, scratchLowerDir)
+	rw := filepath.Join(dir, scratchRWDir)
+	upper := filepath.Join(rw, scratchUpperDir)
+	work := filepath.Join(rw, scratchWorkDir)
+	defer func() {
+		if err != nil {
+			if cleanupErr := ns.cleanupScratch(targetPath); cleanupErr != nil {
+				glog.Warningf("failed to clean up scratch directory of %v: %v", targetPath, cleanupErr)
+			}
+		}
+	}()
+
+	if err = os.MkdirAll(lower, 0750); err != nil {
+		return err
+	}
+	if err = os.MkdirAll(rw, 0750); err != nil {
+		return err
+	}
+	if volCtx.ScratchMedium == volume.ScratchMediumMemory {
+		size := "size=" + strconv.FormatInt(volCtx.ScratchSize, 10)
+		if err = ns.mounter.Mount("tmpfs", rw, "tmpfs", []string{size}); err != nil {
+			return err
+		}
+	}
+	if err = os.MkdirAll(upper, 0755); err != nil {
+		return err
+	}
+	if err = os.MkdirAll(work, 0750); err != nil {
+		return err
+	}
+
+	if err = mountWithContext(ctx, ns.mounter, source, lower, "nfs", append(mo, "ro")); err != nil {
+		return err
+	}
+	options := []string{
+		"lowerdir=" + lower,
+		"upperdir=" + upper,
+		"workdir=" + work,
+	}
+	glog.V(4).Infof("Publishing scratch overlay of %v at %v", source, targetPath)
+	return ns.mounter.Mount("overlay", targetPath, "overlay", options)
+}
+
+// cleanupScratch unmounts and removes the scratch directory of targetPath,
+// if there is one. The overlay at targetPath must be unmounted already.
+func (ns *nodeServer) cleanupScratch(targetPath string) error {
+	if ns.driver.scratchDir == "" {
+		return nil
+	}
+	dir := ns.scratchPath(targetPath)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+
+	for _, mp := range []string{filepath.Join(dir, scratchLowerDir), filepath.Join(dir, scratchRWDir)} {
+		notMnt, err := ns.mounter.IsLikelyNotMountPoint(mp)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !notMnt {
+			if err := ns.mounter.Unmount(mp); err != nil {
+				return err
+			}
+		}
+		// Never descend into a share that is still mounted
+		if notMnt, err = ns.mounter.IsLikelyNotMountPoint(mp); err != nil || !notMnt {
+			return fmt.Errorf("%v is still mounted", mp)
+		}
+	}
+	return os.RemoveAll(dir)
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/nfs/volume"
+)
+
+// Timeout of the whole lifecycle of one canary volume
+const selfCheckTimeout = 2 * time.Minute
+
+// Content of the file written to canary volumes
+var selfCheckData = []byte("csi-nfs self check\n")
+
+// selfCheck provisions, publishes, writes to, unpublishes and deletes a
+// canary volume on every export given to --self-check-on-start when the
+// driver starts, so that a misconfigured export, missing nfs-utils or
+// wrong permissions show up before the first claim is provisioned. Probe
+// reports the driver as not ready until all checks passed.
+type selfCheck struct {
+	d       *Driver
+	exports []string
+
+	mutex sync.Mutex
+	done  bool
+	err   error
+}
+
+func newSelfCheck(d *Driver, exports []string) *selfCheck {
+	return &selfCheck{d: d, exports: exports}
+}
+
+// result returns nil once all checks passed, and an error while they are
+// running or if one failed
+func (c *selfCheck) result() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.done {
+		return fmt.Errorf("self check is running")
+	}
+	return c.err
+}
+
+// run checks all exports, one after the other
+func (c *selfCheck) run() {
+	var failed []string
+	for _, export := range c.exports {
+		if err := c.check(export); err != nil {
+			glog.Errorf("Self check of %v failed: %v", export, err)
+			failed = append(failed, fmt.Sprintf("%v: %v", export, err))
+		} else {
+			glog.Infof("Self check of %v passed", export)
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.done = true
+	if len(failed) > 0 {
+		c.err = fmt.Errorf("self check failed for %d of %d exports: %v", len(failed), len(c.exports), failed)
+	}
+}
+
+// check runs the lifecycle of a canary volume on export. The returned
+// error names the step that failed.
+func (c *selfCheck) check(export string) (err error) {
+	server, share, ok := volume.ParseMigratedID(export)
+	if !ok {
+		return fmt.Errorf("invalid export %q, expected server:/path", export)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), selfCheckTimeout)
+	defer cancel()
+	name := fmt.Sprintf("csi-nfs-self-check-%d", time.Now().UnixNano())
+	capability := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+
+	glog.V(2).Infof("Self check of %v: provisioning %v", export, name)
+	created, err := c.d.cs.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:               name,
+		Parameters:         map[string]string{paramServer: server, paramShare: share},
+		VolumeCapabilities: []*csi.VolumeCapability{capability},
+	})
+	if err != nil {
+		return fmt.Errorf("provisioning failed: %v", err)
+	}
+	vol := created.GetVolume()
+	defer func() {
+		if delErr := c.delete(ctx, vol.GetVolumeId()); delErr != nil && err == nil {
+			err = fmt.Errorf("deleting failed: %v", delErr)
+		}
+	}()
+
+	target, err := ioutil.TempDir(c.d.workingMountDir, "self-check-")
+	if err != nil {
+		return fmt.Errorf("creating the target directory failed: %v", err)
+	}
+	defer os.Remove(target)
+	glog.V(2).Infof("Self check of %v: publishing %v to %v", export, vol.GetVolumeId(), target)
+	_, err = c.d.ns.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+		VolumeId:         vol.GetVolumeId(),
+		VolumeContext:    vol.GetVolumeContext(),
+		TargetPath:       target,
+		VolumeCapability: capability,
+	})
+	if err != nil {
+		return fmt.Errorf("publishing failed: %v", err)
+	}
+	defer func() {
+		_, unpubErr := c.d.ns.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{
+			VolumeId:   vol.GetVolumeId(),
+			TargetPath: target,
+		})
+		if unpubErr != nil && err == nil {
+			err = fmt.Errorf("unpublishing failed: %v", unpubErr)
+		}
+	}()
+
+	glog.V(2).Infof("Self check of %v: writing to %v", export, target)
+	file := filepath.Join(target, "self-check")
+	if err := ioutil.WriteFile(file, selfCheckData, 0644); err != nil {
+		return fmt.Errorf("writing failed: %v", err)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("reading failed: %v", err)
+	}
+	if !bytes.Equal(data, selfCheckData) {
+		return fmt.Errorf("reading failed: got %q instead of the data written", data)
+	}
+	// Leave the volume empty so that DeleteVolume does not refuse it
+	if err := os.Remove(file); err != nil {
+		return fmt.Errorf("removing the written file failed: %v", err)
+	}
+	return nil
+}
+
+// delete deletes the canary volume. With --verify-pv-before-delete,
+// DeleteVolume refuses volumes without a persistent volume, so the
+// subdirectory is removed directly instead.
+func (c *selfCheck) delete(ctx context.Context, volumeID string) error {
+	glog.V(2).Infof("Self check: deleting %v", volumeID)
+	if !c.d.verifyPVOnDelete {
+		_, err := c.d.cs.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID})
+		return err
+	}
+	vol, err := c.d.cs.getNfsVolFromId(volumeID)
+	if err != nil {
+		return err
+	}
+	return c.d.cs.exports.run(ctx, vol, func(mountPath string) error {
+		return os.RemoveAll(filepath.Join(mountPath, vol.subDir))
+	})
+}
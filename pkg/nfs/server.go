@@ -0,0 +1,129 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/glog"
+	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/csi-common"
+)
+
+// grpcServer is csicommon's non-blocking gRPC server with configurable
+// server options.
+type grpcServer struct {
+	wg     sync.WaitGroup
+	server *grpc.Server
+}
+
+// newGRPCServer returns a server that sends all responses gzip compressed
+// if compress is set. Compressed requests are always accepted. Requests
+// pass through interceptors in order after being logged.
+func newGRPCServer(compress bool, interceptors []grpc.UnaryServerInterceptor) *grpcServer {
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(chainInterceptors(append([]grpc.UnaryServerInterceptor{logGRPC}, interceptors...))),
+		grpc.RPCDecompressor(grpc.NewGZIPDecompressor()),
+	}
+	if compress {
+		opts = append(opts, grpc.RPCCompressor(grpc.NewGZIPCompressor()))
+	}
+	return &grpcServer{server: grpc.NewServer(opts...)}
+}
+
+// listen listens on a CSI endpoint such as unix:///csi/csi.sock
+func listen(endpoint string) (net.Listener, error) {
+	proto, addr, err := csicommon.ParseEndpoint(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	if proto == "unix" {
+		addr = "/" + addr
+		if err := os.Remove(addr); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to remove %s: %v", addr, err)
+		}
+	}
+
+	listener, err := net.Listen(proto, addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %v", err)
+	}
+	return listener, nil
+}
+
+// Start serves on listener in the background
+func (s *grpcServer) Start(listener net.Listener, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer) {
+	if ids != nil {
+		csi.RegisterIdentityServer(s.server, ids)
+	}
+	if cs != nil {
+		csi.RegisterControllerServer(s.server, cs)
+	}
+	if ns != nil {
+		csi.RegisterNodeServer(s.server, ns)
+	}
+
+	glog.Infof("Listening for connections on address: %#v", listener.Addr())
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.server.Serve(listener)
+	}()
+}
+
+func (s *grpcServer) Wait() {
+	s.wg.Wait()
+}
+
+func (s *grpcServer) Stop() {
+	s.server.GracefulStop()
+}
+
+// chainInterceptors returns an interceptor that calls interceptors in order
+func chainInterceptors(interceptors []grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		next := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, h := interceptors[i], next
+			next = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, h)
+			}
+		}
+		return next(ctx, req)
+	}
+}
+
+func logGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	glog.V(3).Infof("GRPC call: %s", info.FullMethod)
+	glog.V(5).Infof("GRPC request: %s", protosanitizer.StripSecrets(req))
+	resp, err := handler(ctx, req)
+	if err != nil {
+		glog.Errorf("GRPC error: %v", err)
+	} else {
+		glog.V(5).Infof("GRPC response: %s", protosanitizer.StripSecrets(resp))
+	}
+	return resp, err
+}
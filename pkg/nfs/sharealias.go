@@ -0,0 +1,149 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sigs.k8s.io/yaml"
+
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/nfs/validation"
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/nfs/volume"
+)
+
+// shareAlias is the share a StorageClass gets for an alias
+type shareAlias struct {
+	server string
+	share  string
+}
+
+// shareAliases maps the shareAlias parameter of StorageClasses to shares,
+// as read from a YAML file of the form
+//
+//	fast-tier: nfs1.example.com:/export/fast
+//
+// The file is read again when it changes, e.g. when the ConfigMap it is
+// mounted from is updated, so that admins can move an alias to another
+// server without touching the StorageClasses. Volumes that exist already
+// keep their server.
+type shareAliases struct {
+	path string
+
+	mutex   sync.Mutex
+	modTime time.Time
+	aliases map[string]shareAlias
+}
+
+func newShareAliases(path string) *shareAliases {
+	return &shareAliases{path: path}
+}
+
+// lookup returns the share of alias
+func (a *shareAliases) lookup(alias string) (shareAlias, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	info, err := os.Stat(a.path)
+	if err != nil {
+		return shareAlias{}, err
+	}
+	if a.aliases == nil || !info.ModTime().Equal(a.modTime) {
+		aliases, err := readShareAliases(a.path)
+		if err != nil {
+			return shareAlias{}, err
+		}
+		glog.V(2).Infof("Read %d share aliases from %v", len(aliases), a.path)
+		a.aliases = aliases
+		a.modTime = info.ModTime()
+	}
+	share, ok := a.aliases[alias]
+	if !ok {
+		return shareAlias{}, status.Errorf(codes.InvalidArgument, "unknown share alias %q", alias)
+	}
+	return share, nil
+}
+
+// readShareAliases reads and validates the share aliases file path
+func readShareAliases(path string) (map[string]shareAlias, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]string
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid share aliases file %v: %v", path, err)
+	}
+	aliases := map[string]shareAlias{}
+	for alias, export := range m {
+		server, share, ok := volume.ParseMigratedID(export)
+		if !ok {
+			return nil, fmt.Errorf("invalid share of alias %q in %v: must be server:/path", alias, path)
+		}
+		if server, err = validation.NormalizeServer(server); err != nil {
+			return nil, fmt.Errorf("invalid share of alias %q in %v: %v", alias, path, err)
+		}
+		if err := validation.ValidateShare(share); err != nil {
+			return nil, fmt.Errorf("invalid share of alias %q in %v: %v", alias, path, err)
+		}
+		aliases[alias] = shareAlias{server: server, share: share}
+	}
+	return aliases, nil
+}
+
+// shareAliasParameters returns StorageClass parameters params with the
+// shareAlias parameter replaced by the server and share of the alias
+func (cs *controllerServer) shareAliasParameters(params map[string]string) (map[string]string, error) {
+	key := ""
+	for k := range params {
+		switch strings.ToLower(k) {
+		case paramShareAlias:
+			key = k
+		}
+	}
+	if key == "" {
+		return params, nil
+	}
+	if cs.shareAliases == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v needs the controller to run with --share-aliases-file", paramShareAlias)
+	}
+	alias, err := cs.shareAliases.lookup(params[key])
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	resolved := map[string]string{}
+	for k, v := range params {
+		switch strings.ToLower(k) {
+		case paramShareAlias:
+			continue
+		case paramServer, paramShare:
+			return nil, status.Errorf(codes.InvalidArgument, "%v cannot be used with %v or %v", paramShareAlias, paramServer, paramShare)
+		}
+		resolved[k] = v
+	}
+	resolved[paramServer] = alias.server
+	resolved[paramShare] = alias.share
+	return resolved, nil
+}
@@ -0,0 +1,271 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/ptypes"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/nfs/validation"
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/nfs/volume"
+)
+
+// Snapshots are stored as {baseDir}/{snapshotsDir}/{name}.tar.gz on the
+// share of their volume. {name}.json is written once the archive is
+// complete and describes it.
+const (
+	defaultSnapshotsDir   = ".snapshots"
+	snapshotArchiveSuffix = ".tar.gz"
+	snapshotInfoSuffix    = ".json"
+)
+
+// snapshotInfo is the content of the info file of a complete snapshot
+type snapshotInfo struct {
+	SourceVolumeID string    `json:"sourceVolumeID"`
+	CreationTime   time.Time `json:"creationTime"`
+	SizeBytes      int64     `json:"sizeBytes"`
+}
+
+func (cs *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
+	if err := cs.driver.csiDriver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT); err != nil {
+		return nil, err
+	}
+
+	name := req.GetName()
+	if name == "" {
+		return nil, status.Error(codes.InvalidArgument, "CreateSnapshot name must be provided")
+	}
+	if !validation.IsSafePathElement(name) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid snapshot name %q: must be usable as a file name", name)
+	}
+	sourceVolumeID := req.GetSourceVolumeId()
+	if sourceVolumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "CreateSnapshot source volume id must be provided")
+	}
+	if _, _, ok := volume.ParseMigratedID(sourceVolumeID); ok {
+		return nil, status.Errorf(codes.InvalidArgument, "volume %v was migrated from an in-tree volume and cannot be snapshotted", sourceVolumeID)
+	}
+	vol, err := cs.getNfsVolFromId(sourceVolumeID)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "invalid source volume id %v: %v", sourceVolumeID, err)
+	}
+	if vol.subDir == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "volume %v shares the base directory and cannot be snapshotted", sourceVolumeID)
+	}
+
+	id := volume.NewSnapshotID(vol.server, vol.baseDir, cs.driver.snapshotsDir, name)
+	if job, ok := cs.snapshots.get(id); ok {
+		return cs.snapshotJobResponse(job, sourceVolumeID)
+	}
+
+	// The snapshot may be complete already, e.g. if the controller was
+	// restarted while the CO was waiting for it
+	info, err := cs.readSnapshotInfo(ctx, vol, cs.driver.snapshotsDir, name)
+	if err != nil {
+		return nil, err
+	}
+	if info != nil {
+		if info.SourceVolumeID != sourceVolumeID {
+			return nil, status.Errorf(codes.AlreadyExists, "snapshot %v already exists for volume %v", name, info.SourceVolumeID)
+		}
+		return snapshotResponse(id, info, true)
+	}
+
+	job := cs.snapshots.start(id, sourceVolumeID, schedulerShare(vol), func(throttle func(io.Writer) io.Writer) (int64, error) {
+		return cs.archiveSnapshot(id, vol, name, throttle)
+	})
+	return cs.snapshotJobResponse(job, sourceVolumeID)
+}
+
+func (cs *controllerServer) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
+	if err := cs.driver.csiDriver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT); err != nil {
+		return nil, err
+	}
+
+	id := req.GetSnapshotId()
+	if id == "" {
+		return nil, status.Error(codes.InvalidArgument, "snapshot id is empty")
+	}
+	snap, err := volume.ParseSnapshotID(id)
+	if err != nil {
+		// An invalid ID should be treated as doesn't exist
+		glog.Warningf("failed to parse snapshot id %v for deletion: %v", id, err)
+		return &csi.DeleteSnapshotResponse{}, nil
+	}
+	if job, ok := cs.snapshots.get(id); ok && !job.done {
+		return nil, status.Errorf(codes.Aborted, "snapshot %v is still being archived", id)
+	}
+
+	vol := &nfsVolume{server: snap.Server, baseDir: snap.BaseDir}
+	err = cs.exports.run(ctx, vol, func(mountPath string) error {
+		base := filepath.Join(mountPath, snap.SnapshotsDir, snap.Name)
+		glog.V(2).Infof("Removing snapshot archive %v", base+snapshotArchiveSuffix)
+		return cs.runAsProvisioner(func() error {
+			// The info file goes first, so that an interrupted deletion
+			// does not leave a snapshot that looks complete
+			for _, path := range []string{
+				base + snapshotInfoSuffix,
+				base + snapshotArchiveSuffix,
+				base + snapshotArchiveSuffix + partialArchiveSuffix,
+			} {
+				if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+					return status.Errorf(codes.Internal, "failed to delete snapshot: %v", err)
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+	cs.snapshots.forget(id)
+	cs.invalidateCapacity(vol)
+	return &csi.DeleteSnapshotResponse{}, nil
+}
+
+// snapshotJobResponse reports the state of the archive job of a snapshot
+// of sourceVolumeID
+func (cs *controllerServer) snapshotJobResponse(job snapshotJob, sourceVolumeID string) (*csi.CreateSnapshotResponse, error) {
+	if job.sourceVolumeID != sourceVolumeID {
+		return nil, status.Errorf(codes.AlreadyExists, "snapshot %v already exists for volume %v", job.id, job.sourceVolumeID)
+	}
+	if !job.done {
+		return snapshotResponse(job.id, &snapshotInfo{SourceVolumeID: sourceVolumeID, CreationTime: job.createdAt}, false)
+	}
+	// Complete snapshots are found on the share from now on, and failed
+	// ones are archived again by the next request
+	cs.snapshots.forget(job.id)
+	if job.err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to archive snapshot %v: %v", job.id, job.err)
+	}
+	return snapshotResponse(job.id, &snapshotInfo{SourceVolumeID: sourceVolumeID, CreationTime: job.createdAt, SizeBytes: job.size}, true)
+}
+
+func snapshotResponse(id string, info *snapshotInfo, ready bool) (*csi.CreateSnapshotResponse, error) {
+	snapshot, err := csiSnapshot(id, info, ready)
+	if err != nil {
+		return nil, err
+	}
+	return &csi.CreateSnapshotResponse{Snapshot: snapshot}, nil
+}
+
+func csiSnapshot(id string, info *snapshotInfo, ready bool) (*csi.Snapshot, error) {
+	creationTime, err := ptypes.TimestampProto(info.CreationTime)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "invalid creation time of snapshot %v: %v", id, err)
+	}
+	return &csi.Snapshot{
+		SnapshotId:     id,
+		SourceVolumeId: info.SourceVolumeID,
+		SizeBytes:      info.SizeBytes,
+		CreationTime:   creationTime,
+		ReadyToUse:     ready,
+	}, nil
+}
+
+// readSnapshotInfo reads the info file of snapshot name of a volume on
+// the share of vol. It returns nil if the snapshot is not complete.
+func (cs *controllerServer) readSnapshotInfo(ctx context.Context, vol *nfsVolume, snapshotsDir, name string) (*snapshotInfo, error) {
+	var info *snapshotInfo
+	err := cs.exports.run(ctx, vol, func(mountPath string) error {
+		var err error
+		info, err = readSnapshotInfoFile(filepath.Join(mountPath, snapshotsDir, name+snapshotInfoSuffix))
+		if os.IsNotExist(err) {
+			info = nil
+			return nil
+		}
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to read snapshot info of %v: %v", name, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+	return info, nil
+}
+
+// readSnapshotInfoFile reads the info file at path
+func readSnapshotInfoFile(path string) (*snapshotInfo, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	info := &snapshotInfo{}
+	if err := json.Unmarshal(data, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// archiveSnapshot writes the archive of snapshot id of vol. It runs in
+// the background, so it holds the mount of the share instead of holding
+// up the export queue for the duration of the archive.
+func (cs *controllerServer) archiveSnapshot(id string, vol *nfsVolume, name string, throttle func(io.Writer) io.Writer) (int64, error) {
+	job, _ := cs.snapshots.get(id)
+	mountPath, release, err := cs.mounts.acquire(context.Background(), vol)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	dir := filepath.Join(mountPath, cs.driver.snapshotsDir)
+	if err := cs.makeDir(dir, 0700); err != nil && !os.IsExist(err) {
+		return 0, fmt.Errorf("failed to create snapshots directory: %v", err)
+	}
+	archive := filepath.Join(dir, name+snapshotArchiveSuffix)
+	err = cs.runAsProvisioner(func() error {
+		return writeArchive(filepath.Join(mountPath, vol.subDir), archive, throttle)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	err = cs.runAsProvisioner(func() error {
+		fi, err := os.Stat(archive)
+		if err != nil {
+			return err
+		}
+		size = fi.Size()
+		data, err := json.Marshal(&snapshotInfo{
+			SourceVolumeID: job.sourceVolumeID,
+			CreationTime:   job.createdAt,
+			SizeBytes:      size,
+		})
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(dir, name+snapshotInfoSuffix), data, 0644)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to write snapshot info: %v", err)
+	}
+	return size, nil
+}
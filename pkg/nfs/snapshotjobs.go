@@ -0,0 +1,177 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+)
+
+// snapshotJob is a snapshot archive being written in the background
+type snapshotJob struct {
+	// Snapshot id and id of the volume it is taken of
+	id             string
+	sourceVolumeID string
+	createdAt      time.Time
+
+	// Set once the archive is complete or failed
+	done bool
+	// Size of the complete archive
+	size int64
+	err  error
+}
+
+// sourceLock is held while a snapshot of a volume is archived
+type sourceLock struct {
+	sync.Mutex
+	// Jobs of the volume that are not done yet, protected by
+	// snapshotJobs.mutex
+	refs int
+}
+
+// snapshotJobs runs snapshot archives in the background, so that
+// CreateSnapshot can return right away with ready_to_use=false and report
+// readiness on later calls instead of timing out on large volumes.
+//
+// Repeated requests for the same snapshot get the job that is already
+// running. At most maxRunning archives are written at a time, and
+// snapshots of the same volume are archived one after the other, so that
+// no two tar processes walk the same directory. Archives are written
+// through the share scheduler, within the background windows and IO rate
+// of the share of the volume.
+type snapshotJobs struct {
+	scheduler *shareScheduler
+	// Slots of running archives, nil for no limit
+	slots chan struct{}
+
+	mutex sync.Mutex
+	jobs  map[string]*snapshotJob
+	// Locks of the volumes with unfinished jobs
+	sources map[string]*sourceLock
+}
+
+// newSnapshotJobs returns jobs that write at most maxRunning archives at
+// a time, or any number if maxRunning is not positive
+func newSnapshotJobs(maxRunning int, scheduler *shareScheduler) *snapshotJobs {
+	j := &snapshotJobs{
+		scheduler: scheduler,
+		jobs:      map[string]*snapshotJob{},
+		sources:   map[string]*sourceLock{},
+	}
+	if maxRunning > 0 {
+		j.slots = make(chan struct{}, maxRunning)
+	}
+	return j
+}
+
+// start runs archive in the background for snapshot id of a volume on
+// share, unless a job for id is known already. archive must write through
+// the throttle it is given. It returns the state of the job for id.
+func (j *snapshotJobs) start(id, sourceVolumeID, share string, archive func(throttle func(io.Writer) io.Writer) (int64, error)) snapshotJob {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if job, ok := j.jobs[id]; ok {
+		return *job
+	}
+	job := &snapshotJob{
+		id:             id,
+		sourceVolumeID: sourceVolumeID,
+		createdAt:      time.Now(),
+	}
+	j.jobs[id] = job
+	source, ok := j.sources[sourceVolumeID]
+	if !ok {
+		source = &sourceLock{}
+		j.sources[sourceVolumeID] = source
+	}
+	source.refs++
+
+	go func() {
+		source.Lock()
+		defer source.Unlock()
+
+		var size int64
+		err := j.scheduler.run(context.Background(), share, "archive snapshot "+id, func(throttle func(io.Writer) io.Writer) error {
+			// Only take a slot once the share is free, jobs waiting for a
+			// busy share must not hold up other shares
+			if j.slots != nil {
+				j.slots <- struct{}{}
+				defer func() { <-j.slots }()
+			}
+			glog.V(2).Infof("Archiving snapshot %v of volume %v", id, sourceVolumeID)
+			var err error
+			size, err = archive(throttle)
+			return err
+		})
+		if err != nil {
+			glog.Errorf("failed to archive snapshot %v of volume %v: %v", id, sourceVolumeID, err)
+		} else {
+			glog.V(2).Infof("Archived snapshot %v of volume %v (%d bytes)", id, sourceVolumeID, size)
+		}
+
+		j.mutex.Lock()
+		defer j.mutex.Unlock()
+		job.done = true
+		job.size = size
+		job.err = err
+		// A later job of the volume creates a new lock, which is fine as
+		// this job has finished its archive
+		source.refs--
+		if source.refs == 0 {
+			delete(j.sources, sourceVolumeID)
+		}
+	}()
+	return *job
+}
+
+// get returns the state of the job for snapshot id
+func (j *snapshotJobs) get(id string) (snapshotJob, bool) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	job, ok := j.jobs[id]
+	if !ok {
+		return snapshotJob{}, false
+	}
+	return *job, true
+}
+
+// list returns the states of all known jobs
+func (j *snapshotJobs) list() []snapshotJob {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	jobs := make([]snapshotJob, 0, len(j.jobs))
+	for _, job := range j.jobs {
+		jobs = append(jobs, *job)
+	}
+	return jobs
+}
+
+// forget drops the job for snapshot id once it is done. Completed
+// snapshots are found on the share from then on, and a failed snapshot
+// is retried by the next start.
+func (j *snapshotJobs) forget(id string) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if job, ok := j.jobs[id]; ok && job.done {
+		delete(j.jobs, id)
+	}
+}
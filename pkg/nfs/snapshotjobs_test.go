@@ -0,0 +1,60 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestSnapshotJobsPruneSources(t *testing.T) {
+	j := newSnapshotJobs(0, newShareScheduler(nil, 0))
+	release := make(chan struct{})
+	archive := func(throttle func(io.Writer) io.Writer) (int64, error) {
+		<-release
+		return 0, nil
+	}
+	j.start("snap-1", "vol-1", "nfs:/export", archive)
+	j.start("snap-2", "vol-1", "nfs:/export", archive)
+
+	j.mutex.Lock()
+	refs := j.sources["vol-1"].refs
+	j.mutex.Unlock()
+	if refs != 2 {
+		t.Fatalf("got %d references to the lock of vol-1, want 2", refs)
+	}
+
+	close(release)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		a, _ := j.get("snap-1")
+		b, _ := j.get("snap-2")
+		if a.done && b.done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("jobs did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if len(j.sources) != 0 {
+		t.Errorf("locks of %d volumes are left after all jobs finished", len(j.sources))
+	}
+}
@@ -0,0 +1,203 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Flags and options whose values are left out of support bundles
+var sensitiveName = regexp.MustCompile(`(?i)(password|passwd|secret|token|key|credential)`)
+
+// SupportBundle gathers what is needed to investigate a bug report
+// against the driver into a single archive: the configuration, the nfs
+// related mounts, the nfs client statistics, the working mount directory
+// and the metrics of the driver.
+type SupportBundle struct {
+	// Command line of the driver. Values of sensitive flags are redacted.
+	Args []string
+	// Working mount directory of the driver
+	WorkingMountDir string
+	// Metrics of the driver in the Prometheus text format, if available
+	Metrics []byte
+}
+
+// Write writes the bundle as gzip compressed tar archive to w. Parts
+// that cannot be collected are replaced by the error.
+func (b *SupportBundle) Write(w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+
+	files := []struct {
+		name string
+		data func() ([]byte, error)
+	}{
+		{"version.txt", func() ([]byte, error) {
+			return []byte(fmt.Sprintf("%s %s\n", driverName, version)), nil
+		}},
+		{"args.txt", func() ([]byte, error) {
+			return []byte(strings.Join(redactArgs(b.Args), "\n") + "\n"), nil
+		}},
+		{"mountinfo.txt", func() ([]byte, error) {
+			return filterLines("/proc/self/mountinfo", func(line string) bool {
+				return strings.Contains(line, " - nfs") || strings.Contains(line, " - overlay") || strings.Contains(line, " - fuse")
+			})
+		}},
+		{"mountstats.txt", func() ([]byte, error) {
+			inNFS := false
+			return filterLines(mountStatsFile, func(line string) bool {
+				if strings.HasPrefix(line, "device ") {
+					inNFS = strings.Contains(line, " with fstype nfs")
+				}
+				return inNFS
+			})
+		}},
+		{"working-mount-dir.txt", func() ([]byte, error) {
+			return listDir(b.WorkingMountDir)
+		}},
+		{"metrics.txt", func() ([]byte, error) {
+			return b.Metrics, nil
+		}},
+	}
+	for _, f := range files {
+		data, err := f.data()
+		if err != nil {
+			data = []byte(fmt.Sprintf("error: %v\n", err))
+		}
+		hdr := &tar.Header{
+			Name:    f.name,
+			Mode:    0644,
+			Size:    int64(len(data)),
+			ModTime: now,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// redactArgs replaces the values of sensitive flags
+func redactArgs(args []string) []string {
+	result := make([]string, len(args))
+	redactNext := false
+	for i, arg := range args {
+		switch {
+		case redactNext:
+			result[i] = "<redacted>"
+			redactNext = false
+		case strings.HasPrefix(arg, "-") && sensitiveName.MatchString(arg):
+			if j := strings.Index(arg, "="); j >= 0 {
+				result[i] = arg[:j+1] + "<redacted>"
+			} else {
+				result[i] = arg
+				redactNext = true
+			}
+		default:
+			result[i] = arg
+		}
+	}
+	return result
+}
+
+// filterLines returns the lines of file for which keep returns true
+func filterLines(file string, keep func(line string) bool) ([]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var buf bytes.Buffer
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if keep(scanner.Text()) {
+			buf.WriteString(scanner.Text())
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes(), scanner.Err()
+}
+
+// listDir lists the entries of dir with their modes and modification times
+func listDir(dir string) ([]byte, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	for _, e := range entries {
+		fmt.Fprintf(&buf, "%v %s %s\n", e.Mode(), e.ModTime().UTC().Format(time.RFC3339), e.Name())
+	}
+	return buf.Bytes(), nil
+}
+
+// GatherMetrics returns the metrics of this process in the Prometheus text
+// format
+func GatherMetrics() ([]byte, error) {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	for _, mf := range families {
+		if _, err := expfmt.MetricFamilyToText(&buf, mf); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// supportBundleHandler serves a support bundle of the running driver
+func (d *Driver) supportBundleHandler(w http.ResponseWriter, r *http.Request) {
+	metrics, err := GatherMetrics()
+	if err != nil {
+		metrics = []byte(fmt.Sprintf("error: %v\n", err))
+	}
+	b := &SupportBundle{
+		Args:            os.Args,
+		WorkingMountDir: d.workingMountDir,
+		Metrics:         metrics,
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", "attachment; filename=nfs-support-bundle.tar.gz")
+	if err := b.Write(w); err != nil {
+		glog.Warningf("failed to write support bundle: %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultTopologyKey is the topology segment that servers are mapped by
+// unless configured otherwise
+const DefaultTopologyKey = "topology.kubernetes.io/zone"
+
+// topologyEnabled tells whether the driver reports and honors topology,
+// i.e. the controller maps segments to servers or the node knows its
+// segment
+func (d *Driver) topologyEnabled() bool {
+	return d.topologyKey != "" && (len(d.topologyServers) > 0 || d.nodeTopology != "")
+}
+
+func (ids *identityServer) GetPluginCapabilities(ctx context.Context, req *csi.GetPluginCapabilitiesRequest) (*csi.GetPluginCapabilitiesResponse, error) {
+	resp, err := ids.DefaultIdentityServer.GetPluginCapabilities(ctx, req)
+	if err != nil || !ids.topology {
+		return resp, err
+	}
+	resp.Capabilities = append(resp.Capabilities, &csi.PluginCapability{
+		Type: &csi.PluginCapability_Service_{
+			Service: &csi.PluginCapability_Service{
+				Type: csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS,
+			},
+		},
+	})
+	return resp, nil
+}
+
+func (ns *nodeServer) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
+	resp, err := ns.DefaultNodeServer.NodeGetInfo(ctx, req)
+	if err != nil || ns.driver.topologyKey == "" || ns.driver.nodeTopology == "" {
+		return resp, err
+	}
+	resp.AccessibleTopology = &csi.Topology{
+		Segments: map[string]string{ns.driver.topologyKey: ns.driver.nodeTopology},
+	}
+	return resp, nil
+}
+
+// topologyParameters returns StorageClass parameters params with the
+// server of the first of topologies that has one, if params leave the
+// server out. It returns a ResourceExhausted error if none of topologies
+// has a server.
+func (cs *controllerServer) topologyParameters(params map[string]string, topologies []*csi.Topology) (map[string]string, error) {
+	if len(cs.driver.topologyServers) == 0 {
+		return params, nil
+	}
+	for k := range params {
+		if strings.ToLower(k) == paramServer {
+			return params, nil
+		}
+	}
+
+	for _, topology := range topologies {
+		server, ok := cs.driver.topologyServers[topology.GetSegments()[cs.driver.topologyKey]]
+		if !ok {
+			continue
+		}
+		withServer := map[string]string{paramServer: server}
+		for k, v := range params {
+			withServer[k] = v
+		}
+		return withServer, nil
+	}
+	if len(topologies) == 0 {
+		return params, nil
+	}
+	return nil, status.Errorf(codes.ResourceExhausted, "no nfs server is configured for the requested %v segments", cs.driver.topologyKey)
+}
+
+// accessibleTopology returns the segments whose nodes may use volumes on
+// server, or nil if the server is not mapped to segments and therefore
+// accessible everywhere
+func (cs *controllerServer) accessibleTopology(server string) []*csi.Topology {
+	var values []string
+	for value, s := range cs.driver.topologyServers {
+		if s == server {
+			values = append(values, value)
+		}
+	}
+	sort.Strings(values)
+	var topologies []*csi.Topology
+	for _, value := range values {
+		topologies = append(topologies, &csi.Topology{
+			Segments: map[string]string{cs.driver.topologyKey: value},
+		})
+	}
+	return topologies
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"fmt"
+
+	"k8s.io/api/core/v1"
+
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/nfs/volume"
+)
+
+const (
+	// InTreePluginName is the name of the in-tree NFS volume plugin
+	InTreePluginName = "kubernetes.io/nfs"
+	// CSIDriverName is the name of this CSI driver
+	CSIDriverName = driverName
+)
+
+// TranslateInTreePVToCSI translates an in-tree NFS PersistentVolume into a
+// PersistentVolume that uses this driver. The share is mounted as is, so
+// the translated volume has the same semantics as the in-tree one.
+func TranslateInTreePVToCSI(pv *v1.PersistentVolume) (*v1.PersistentVolume, error) {
+	if pv == nil || pv.Spec.NFS == nil {
+		return nil, fmt.Errorf("pv is nil or NFS source not defined on pv")
+	}
+
+	nfsSource := pv.Spec.NFS
+	if nfsSource.Server == "" || nfsSource.Path == "" {
+		return nil, fmt.Errorf("NFS source of pv %q must have server and path", pv.Name)
+	}
+
+	csiSource := &v1.CSIPersistentVolumeSource{
+		Driver:       CSIDriverName,
+		VolumeHandle: volume.NewMigratedID(nfsSource.Server, nfsSource.Path),
+		ReadOnly:     nfsSource.ReadOnly,
+		VolumeAttributes: map[string]string{
+			paramServer: nfsSource.Server,
+			paramShare:  nfsSource.Path,
+		},
+	}
+
+	translated := pv.DeepCopy()
+	translated.Spec.NFS = nil
+	translated.Spec.CSI = csiSource
+	return translated, nil
+}
+
+// TranslateCSIPVToInTree translates a PersistentVolume of this driver that
+// references a share directly back into an in-tree NFS PersistentVolume.
+func TranslateCSIPVToInTree(pv *v1.PersistentVolume) (*v1.PersistentVolume, error) {
+	if pv == nil || pv.Spec.CSI == nil {
+		return nil, fmt.Errorf("pv is nil or CSI source not defined on pv")
+	}
+
+	csiSource := pv.Spec.CSI
+	server, share := csiSource.VolumeAttributes[paramServer], csiSource.VolumeAttributes[paramShare]
+	if server == "" || share == "" {
+		var ok bool
+		if server, share, ok = volume.ParseMigratedID(csiSource.VolumeHandle); !ok {
+			return nil, fmt.Errorf("CSI source of pv %q must have %s and %s attributes", pv.Name, paramServer, paramShare)
+		}
+	}
+
+	translated := pv.DeepCopy()
+	translated.Spec.CSI = nil
+	translated.Spec.NFS = &v1.NFSVolumeSource{
+		Server:   server,
+		Path:     share,
+		ReadOnly: csiSource.ReadOnly,
+	}
+	return translated, nil
+}
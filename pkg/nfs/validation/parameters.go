@@ -0,0 +1,418 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validation
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// StorageClass parameters. Names are matched case-insensitively.
+const (
+	ParamServer = "server"
+	ParamShare  = "share"
+	// Name of a share in the share aliases file of the controller, instead
+	// of server and share
+	ParamShareAlias = "sharealias"
+	// If true, volumes are not given their own subdirectory and the
+	// base share is handed out as is. DeleteVolume leaves the data alone.
+	ParamUseBaseDirAsShare = "usebasedirasshare"
+	// If true, the base share is created on the server if it is missing.
+	ParamCreateShare = "createshare"
+	// POSIX ACL entries in setfacl(1) syntax, e.g. "g:1000:rwx,d:g:1000:rwx"
+	ParamACL = "acl"
+	// Comma separated NFSv4 ACEs in nfs4_acl(5) syntax, e.g. "A:g:1000:rwaDxtTnNcCy"
+	ParamNFS4ACL = "nfs4acl"
+	// If set, node mounts use a reserved source port (true) or not (false)
+	ParamResvPort = "resvport"
+	// If true, CreateVolume checks that the server is reachable and the
+	// share can be mounted before it does anything else
+	ParamVerifyServer = "verifyserver"
+	// If set, a short id derived from the volume name is (true) or is not
+	// (false) appended to the new subdirectory
+	ParamUUIDSuffix = "uuidsuffix"
+	// Comma separated NFS mount options that nodes mount the volume with,
+	// see ParseMountOptions
+	ParamMountOptions = "mountoptions"
+	// If true, the share handed to nodes omits the base share, e.g. when
+	// the base share is the NFSv4 pseudo root (fsid=0) of the server.
+	ParamShareRelativeToExportRoot = "sharerelativetoexportroot"
+	// Group id that owns the new subdirectory, which also gets the setgid
+	// bit so that files created in it inherit the group
+	ParamSupplementalGroup = "supplementalgroup"
+	// Template of the name of the new subdirectory, e.g.
+	// "${pvc.metadata.namespace}-${pvc.metadata.name}"
+	ParamSubDir = "subdir"
+	// What DeleteVolume does with the subdirectory, one of the OnDelete
+	// values
+	ParamOnDelete = "ondelete"
+	// If true, volumes are created in a directory per claim namespace
+	// under the base share, by default as {share}/{namespace}/{claim}
+	ParamNamespaceDirs = "namespacedirs"
+	// chmod(1) style octal mode of the new subdirectory, e.g. "0777"
+	ParamMountPermissions = "mountpermissions"
+	// Owner of the new subdirectory
+	ParamUID = "uid"
+	ParamGID = "gid"
+
+	// Prefix of the parameters reserved for the external-provisioner.
+	// Reserved parameters the driver has no use for are ignored.
+	ReservedParamPrefix = "csi.storage.k8s.io/"
+
+	// Passed by the external-provisioner with --extra-create-metadata
+	ParamPVCName      = "csi.storage.k8s.io/pvc/name"
+	ParamPVCNamespace = "csi.storage.k8s.io/pvc/namespace"
+	ParamPVName       = "csi.storage.k8s.io/pv/name"
+)
+
+// Values of ParamOnDelete
+const (
+	// Remove the subdirectory, the default
+	OnDeleteDelete = "delete"
+	// Leave the subdirectory in place
+	OnDeleteRetain = "retain"
+	// Rename the subdirectory with an archive prefix
+	OnDeleteArchive = "archive"
+)
+
+// Parameters are validated StorageClass parameters
+type Parameters struct {
+	// Normalized address of the NFS server
+	Server string
+	// Base share that volumes are created under, as given
+	Share string
+	// Alias that the controller replaces with server and share
+	ShareAlias        string
+	UseBaseDirAsShare bool
+	CreateShare       bool
+	ACL               string
+	NFS4ACL           []string
+	// nil if not set
+	ResvPort                  *bool
+	VerifyServer              *bool
+	UUIDSuffix                *bool
+	MountOptions              []string
+	ShareRelativeToExportRoot bool
+	// nil if not set
+	SupplementalGroup *int
+	// Template of the subdirectory name, see ExpandSubDir
+	SubDir string
+	// One of the OnDelete values, "" if not set
+	OnDelete      string
+	NamespaceDirs bool
+	// Octal mode of the subdirectory, nil if not set
+	MountPermissions *uint64
+	// Owner of the subdirectory, nil if not set
+	UID *int
+	GID *int
+
+	// Claim the volume is provisioned for, if the external-provisioner
+	// passes it
+	PVCName      string
+	PVCNamespace string
+	PVName       string
+}
+
+// parameter parses the value of one StorageClass parameter into
+// Parameters
+type parameter struct {
+	// Whether the value may be empty or blank
+	allowEmpty bool
+	parse      func(p *Parameters, v string) error
+}
+
+// parameters lists every StorageClass parameter the driver accepts, by
+// lower case name. Parameters that are not listed are rejected.
+var parameters = map[string]parameter{
+	ParamServer: {parse: func(p *Parameters, v string) (err error) {
+		p.Server, err = NormalizeServer(v)
+		return err
+	}},
+	ParamShare: {parse: func(p *Parameters, v string) error {
+		p.Share = v
+		return nil
+	}},
+	ParamShareAlias: {parse: func(p *Parameters, v string) error {
+		p.ShareAlias = v
+		return nil
+	}},
+	ParamUseBaseDirAsShare: boolParameter(func(p *Parameters) *bool { return &p.UseBaseDirAsShare }),
+	ParamCreateShare:       boolParameter(func(p *Parameters) *bool { return &p.CreateShare }),
+	ParamACL: {parse: func(p *Parameters, v string) error {
+		p.ACL = v
+		return nil
+	}},
+	ParamNFS4ACL: {parse: func(p *Parameters, v string) error {
+		for _, ace := range strings.Split(v, ",") {
+			if ace = strings.TrimSpace(ace); ace != "" {
+				p.NFS4ACL = append(p.NFS4ACL, ace)
+			}
+		}
+		return nil
+	}},
+	ParamResvPort: {parse: func(p *Parameters, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		p.ResvPort = &b
+		return nil
+	}},
+	ParamVerifyServer: {parse: func(p *Parameters, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		p.VerifyServer = &b
+		return nil
+	}},
+	ParamUUIDSuffix: {parse: func(p *Parameters, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		p.UUIDSuffix = &b
+		return nil
+	}},
+	ParamMountOptions: {parse: func(p *Parameters, v string) (err error) {
+		p.MountOptions, err = ParseMountOptions(v)
+		return err
+	}},
+	ParamShareRelativeToExportRoot: boolParameter(func(p *Parameters) *bool { return &p.ShareRelativeToExportRoot }),
+	ParamSupplementalGroup:         idParameter("group", func(p *Parameters) **int { return &p.SupplementalGroup }),
+	ParamUID:                       idParameter("user", func(p *Parameters) **int { return &p.UID }),
+	ParamGID:                       idParameter("group", func(p *Parameters) **int { return &p.GID }),
+	ParamSubDir: {parse: func(p *Parameters, v string) error {
+		if strings.Contains(v, "/") {
+			return fmt.Errorf("must be a single directory")
+		}
+		for _, m := range subDirVariable.FindAllStringSubmatch(v, -1) {
+			if _, ok := subDirVariables[m[1]]; !ok {
+				return fmt.Errorf("unknown variable %q", m[0])
+			}
+		}
+		p.SubDir = v
+		return nil
+	}},
+	ParamOnDelete: {parse: func(p *Parameters, v string) error {
+		switch v = strings.ToLower(strings.TrimSpace(v)); v {
+		case OnDeleteDelete, OnDeleteRetain, OnDeleteArchive:
+			p.OnDelete = v
+			return nil
+		}
+		return fmt.Errorf("must be %q, %q or %q", OnDeleteDelete, OnDeleteRetain, OnDeleteArchive)
+	}},
+	ParamNamespaceDirs: boolParameter(func(p *Parameters) *bool { return &p.NamespaceDirs }),
+	ParamMountPermissions: {parse: func(p *Parameters, v string) error {
+		m, err := strconv.ParseUint(strings.TrimSpace(v), 8, 32)
+		if err != nil || m > 07777 {
+			return fmt.Errorf("must be an octal mode such as 0777")
+		}
+		p.MountPermissions = &m
+		return nil
+	}},
+	ParamPVCName:      stringParameter(func(p *Parameters) *string { return &p.PVCName }),
+	ParamPVCNamespace: stringParameter(func(p *Parameters) *string { return &p.PVCNamespace }),
+	ParamPVName:       stringParameter(func(p *Parameters) *string { return &p.PVName }),
+}
+
+// OnDelete returns what DeleteVolume does with a volume that was created
+// with StorageClass parameters params. Unlike ParseParameters, it does not
+// validate the other parameters, so that volumes created by other versions
+// of the driver are deleted as they were meant to be.
+func OnDelete(params map[string]string) string {
+	for k, v := range params {
+		if strings.ToLower(k) != ParamOnDelete {
+			continue
+		}
+		switch v = strings.ToLower(strings.TrimSpace(v)); v {
+		case OnDeleteRetain, OnDeleteArchive:
+			return v
+		}
+	}
+	return OnDeleteDelete
+}
+
+// subDirVariable matches the variables of a subDir template
+var subDirVariable = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+// subDirVariables returns the values of the variables of subDir templates
+// for the volume with CreateVolume name, or "" if they are not known
+var subDirVariables = map[string]func(p *Parameters, name string) string{
+	"pvc.metadata.name":      func(p *Parameters, name string) string { return p.PVCName },
+	"pvc.metadata.namespace": func(p *Parameters, name string) string { return p.PVCNamespace },
+	"pv.metadata.name": func(p *Parameters, name string) string {
+		// The external-provisioner names volumes after their PV
+		if p.PVName != "" {
+			return p.PVName
+		}
+		return name
+	},
+}
+
+// ExpandSubDir returns the name of the subdirectory of the volume with
+// CreateVolume name according to the SubDir template. The claim
+// variables are only known if the external-provisioner runs with
+// --extra-create-metadata.
+func (p *Parameters) ExpandSubDir(name string) (string, error) {
+	var errs []error
+	subDir := subDirVariable.ReplaceAllStringFunc(p.SubDir, func(v string) string {
+		value := subDirVariables[v[2:len(v)-1]](p, name)
+		if value == "" {
+			errs = append(errs, fmt.Errorf("%v is not known, the external-provisioner must run with --extra-create-metadata", v))
+		}
+		return value
+	})
+	if len(errs) > 0 {
+		return "", utilerrors.NewAggregate(errs)
+	}
+	if !IsSafePathElement(subDir) {
+		return "", fmt.Errorf("%v %q expands to %q, which cannot be used as a directory name", ParamSubDir, p.SubDir, subDir)
+	}
+	return subDir, nil
+}
+
+// boolParameter parses a boolean into the field returned by field
+func boolParameter(field func(p *Parameters) *bool) parameter {
+	return parameter{parse: func(p *Parameters, v string) (err error) {
+		*field(p), err = strconv.ParseBool(v)
+		return err
+	}}
+}
+
+// idParameter parses a user or group id, as named by what, into the field
+// returned by field
+func idParameter(what string, field func(p *Parameters) **int) parameter {
+	return parameter{parse: func(p *Parameters, v string) error {
+		id, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || id < 0 {
+			return fmt.Errorf("must be a %s id", what)
+		}
+		*field(p) = &id
+		return nil
+	}}
+}
+
+// stringParameter stores a value that may be empty in the field returned
+// by field
+func stringParameter(field func(p *Parameters) *string) parameter {
+	return parameter{allowEmpty: true, parse: func(p *Parameters, v string) error {
+		*field(p) = v
+		return nil
+	}}
+}
+
+// ParseParameters validates StorageClass parameters. All problems are
+// collected in the returned error so that they can be fixed at once.
+func ParseParameters(params map[string]string) (*Parameters, error) {
+	p := &Parameters{}
+
+	var errs []error
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	seen := map[string]string{}
+	for _, k := range keys {
+		v := params[k]
+		key := strings.ToLower(k)
+		if prev, ok := seen[key]; ok {
+			errs = append(errs, fmt.Errorf("duplicate parameters %q and %q", prev, k))
+			continue
+		}
+		seen[key] = k
+
+		param, ok := parameters[key]
+		if !ok {
+			if !strings.HasPrefix(key, ReservedParamPrefix) {
+				errs = append(errs, fmt.Errorf("invalid parameter %q", k))
+			}
+			continue
+		}
+		if !param.allowEmpty && strings.TrimSpace(v) == "" {
+			errs = append(errs, fmt.Errorf("parameter %q must not be empty", k))
+			continue
+		}
+		if err := param.parse(p, v); err != nil {
+			if key == ParamServer {
+				// Errors of NormalizeServer name the value already
+				errs = append(errs, err)
+			} else {
+				errs = append(errs, fmt.Errorf("invalid value %q for parameter %q: %v", v, k, err))
+			}
+		}
+	}
+
+	// Validate required parameters
+	_, hasServer := seen[ParamServer]
+	_, hasShare := seen[ParamShare]
+	switch {
+	case p.ShareAlias != "" && (hasServer || hasShare):
+		errs = append(errs, fmt.Errorf("%v cannot be used with %v or %v", ParamShareAlias, ParamServer, ParamShare))
+	case p.ShareAlias == "":
+		if !hasServer {
+			errs = append(errs, fmt.Errorf("%v is a required parameter", ParamServer))
+		}
+		if !hasShare {
+			errs = append(errs, fmt.Errorf("%v is a required parameter", ParamShare))
+		}
+		if err := ValidateShare(p.Share); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if p.UseBaseDirAsShare && (p.ACL != "" || len(p.NFS4ACL) > 0) {
+		errs = append(errs, fmt.Errorf("%v and %v cannot be used with %v", ParamACL, ParamNFS4ACL, ParamUseBaseDirAsShare))
+	}
+	if p.UseBaseDirAsShare && p.SubDir != "" {
+		errs = append(errs, fmt.Errorf("%v cannot be used with %v", ParamSubDir, ParamUseBaseDirAsShare))
+	}
+	if p.NamespaceDirs && (p.UseBaseDirAsShare || p.ShareRelativeToExportRoot) {
+		errs = append(errs, fmt.Errorf("%v cannot be used with %v or %v", ParamNamespaceDirs, ParamUseBaseDirAsShare, ParamShareRelativeToExportRoot))
+	}
+	if p.NamespaceDirs && p.SubDir == "" {
+		p.SubDir = "${pvc.metadata.name}"
+	}
+	if p.UseBaseDirAsShare && p.OnDelete != "" {
+		errs = append(errs, fmt.Errorf("%v cannot be used with %v, whose volumes are never deleted", ParamOnDelete, ParamUseBaseDirAsShare))
+	}
+	if p.UseBaseDirAsShare && p.MountPermissions != nil {
+		errs = append(errs, fmt.Errorf("%v cannot be used with %v", ParamMountPermissions, ParamUseBaseDirAsShare))
+	}
+	if p.UseBaseDirAsShare && (p.UID != nil || p.GID != nil) {
+		errs = append(errs, fmt.Errorf("%v and %v cannot be used with %v", ParamUID, ParamGID, ParamUseBaseDirAsShare))
+	}
+	if p.GID != nil && p.SupplementalGroup != nil {
+		errs = append(errs, fmt.Errorf("%v cannot be used with %v", ParamGID, ParamSupplementalGroup))
+	}
+	if p.UseBaseDirAsShare && p.SupplementalGroup != nil {
+		errs = append(errs, fmt.Errorf("%v cannot be used with %v", ParamSupplementalGroup, ParamUseBaseDirAsShare))
+	}
+	if p.UseBaseDirAsShare && p.UUIDSuffix != nil && *p.UUIDSuffix {
+		errs = append(errs, fmt.Errorf("%v cannot be used with %v", ParamUUIDSuffix, ParamUseBaseDirAsShare))
+	}
+	if len(errs) > 0 {
+		return nil, utilerrors.NewAggregate(errs)
+	}
+	return p, nil
+}
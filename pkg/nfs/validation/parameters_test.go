@@ -0,0 +1,50 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validation
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseParametersDefaults(t *testing.T) {
+	// Parameters that are not set keep their zero value, which the
+	// controller and node plugin take as the default
+	p, err := ParseParameters(map[string]string{
+		ParamServer:       "NFS.example.com",
+		ParamShare:        "/export",
+		ParamPVCNamespace: "team-a",
+		ParamPVCName:      "data",
+		ParamPVName:       "pvc-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Parameters{
+		Server:       "nfs.example.com",
+		Share:        "/export",
+		PVCName:      "data",
+		PVCNamespace: "team-a",
+		PVName:       "pvc-1",
+	}
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("ParseParameters() = %+v, want %+v", p, want)
+	}
+	if subDir, err := p.ExpandSubDir("pvc-1"); err == nil {
+		t.Errorf("ExpandSubDir() of the empty default template = %q, want an error", subDir)
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validation
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Ordering of elements in the CSI snapshot id.
+// ID is of the form {server}/{baseDir}/{snapshotsDir}/{name}. Volume ids
+// never have four non-empty elements, so the two cannot be confused.
+const (
+	snapshotIDServer = iota
+	snapshotIDBaseDir
+	snapshotIDSnapshotsDir
+	snapshotIDName
+	totalSnapshotIDElements // Always last
+)
+
+// SnapshotID is the parsed form of a snapshot id created by the driver
+type SnapshotID struct {
+	Server  string
+	BaseDir string
+	// Directory under BaseDir that holds the snapshot archives
+	SnapshotsDir string
+	Name         string
+}
+
+// ParseSnapshotID splits a snapshot id created by the driver into its
+// parts. Like volume ids, the elements are checked not to escape the base
+// share.
+func ParseSnapshotID(id string) (*SnapshotID, error) {
+	tokens := strings.Split(id, "/")
+	if len(tokens) != totalSnapshotIDElements {
+		return nil, fmt.Errorf("Could not split %q into server, baseDir, snapshotsDir and name", id)
+	}
+	for _, t := range tokens {
+		if t == "" {
+			return nil, fmt.Errorf("Could not split %q into server, baseDir, snapshotsDir and name", id)
+		}
+	}
+	snap := &SnapshotID{
+		Server:       UnescapeIDElement(tokens[snapshotIDServer]),
+		BaseDir:      UnescapeIDElement(tokens[snapshotIDBaseDir]),
+		SnapshotsDir: UnescapeIDElement(tokens[snapshotIDSnapshotsDir]),
+		Name:         UnescapeIDElement(tokens[snapshotIDName]),
+	}
+	if strings.Contains(snap.Server, "\x00") {
+		return nil, fmt.Errorf("invalid server in snapshot id %q", id)
+	}
+	if !IsSafeRelativePath(snap.BaseDir) || !IsSafePathElement(snap.SnapshotsDir) || !IsSafePathElement(snap.Name) {
+		return nil, fmt.Errorf("invalid path in snapshot id %q", id)
+	}
+	return snap, nil
+}
@@ -0,0 +1,237 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package validation implements the rules the NFS CSI driver applies to
+// StorageClass parameters, mount options, server addresses and volume IDs.
+// The driver itself uses this package, so webhooks and tools that call it
+// accept and reject exactly what the driver does.
+package validation
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// NormalizeServer returns the canonical spelling of an NFS server address,
+// so that equivalent spellings result in the same volume id and mount source.
+// Surrounding whitespace, an URL scheme such as "nfs://", trailing slashes and
+// the trailing dot of a fully qualified hostname are removed and hostnames are
+// lowercased. An error is returned if the result is neither an IP address nor
+// a valid hostname. IPv6 addresses with a zone, as in "fe80::1%eth0", are
+// rejected.
+//
+// The host may be followed by the port of the nfs service, as in
+// "nfs.example.com:2050" or "[fd00::1]:2050". IPv6 addresses are returned in
+// brackets only if they have a port, so that the ids of existing volumes on
+// IPv6 servers stay the same. See SplitServer.
+func NormalizeServer(server string) (string, error) {
+	s := strings.TrimSpace(server)
+	if i := strings.Index(s, "://"); i >= 0 {
+		s = s[i+len("://"):]
+	}
+	s = strings.TrimRight(s, "/")
+	s = strings.ToLower(s)
+
+	if s == "" {
+		return "", fmt.Errorf("invalid server %q: must not be empty", server)
+	}
+	host, port := s, ""
+	switch {
+	case net.ParseIP(s) != nil:
+	case strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]"):
+		host = s[1 : len(s)-1]
+	case strings.HasPrefix(s, "[") || strings.Count(s, ":") == 1:
+		var err error
+		if host, port, err = net.SplitHostPort(s); err != nil {
+			return "", fmt.Errorf("invalid server %q: %v", server, err)
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return "", fmt.Errorf("invalid server %q: port must be a number between 1 and 65535", server)
+		}
+	}
+	if strings.Contains(host, "%") {
+		return "", fmt.Errorf("invalid server %q: IPv6 zones are not supported", server)
+	}
+	if h := strings.TrimSuffix(host, "."); h != host {
+		if net.ParseIP(h) != nil {
+			return "", fmt.Errorf("invalid server %q: only hostnames may end in a dot", server)
+		}
+		host = h
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		host = ip.String()
+	} else if strings.HasPrefix(s, "[") {
+		return "", fmt.Errorf("invalid server %q: only IPv6 addresses may be in brackets", server)
+	} else if errs := validation.IsDNS1123Subdomain(host); len(errs) > 0 {
+		return "", fmt.Errorf("invalid server %q: must be an IP address or a hostname: %s", server, strings.Join(errs, ", "))
+	}
+	if port == "" {
+		return host, nil
+	}
+	return net.JoinHostPort(host, strings.TrimLeft(port, "0")), nil
+}
+
+// SplitServer splits a server returned by NormalizeServer into its host
+// and the port of the nfs service, 0 if it has none
+func SplitServer(server string) (host string, port int) {
+	if net.ParseIP(server) != nil {
+		return server, 0
+	}
+	h, p, err := net.SplitHostPort(server)
+	if err != nil {
+		return server, 0
+	}
+	port, err = strconv.Atoi(p)
+	if err != nil {
+		return server, 0
+	}
+	return h, port
+}
+
+// MountSource returns the nfs mount source for path on host, with IPv6
+// addresses in brackets as mount.nfs(8) expects them
+func MountSource(host, path string) string {
+	if strings.Contains(host, ":") {
+		return fmt.Sprintf("[%s]:%s", host, path)
+	}
+	return fmt.Sprintf("%s:%s", host, path)
+}
+
+// allowedMountOptions lists the nfs(5) mount options that may be set through
+// volume attributes. Options that change where or how the share is looked up
+// by the host (e.g. "mountproto", "mounthost") are deliberately not included.
+var allowedMountOptions = map[string]bool{
+	"ac":           true,
+	"acdirmax":     true,
+	"acdirmin":     true,
+	"acl":          true,
+	"acregmax":     true,
+	"acregmin":     true,
+	"actimeo":      true,
+	"bg":           true,
+	"cto":          true,
+	"fg":           true,
+	"fsc":          true,
+	"hard":         true,
+	"intr":         true,
+	"local_lock":   true,
+	"lock":         true,
+	"lookupcache":  true,
+	"minorversion": true,
+	"nconnect":     true,
+	"nfsvers":      true,
+	"noac":         true,
+	"noacl":        true,
+	"noatime":      true,
+	"nocto":        true,
+	"nodiratime":   true,
+	"nofsc":        true,
+	"nointr":       true,
+	"nolock":       true,
+	"nordirplus":   true,
+	"noresvport":   true,
+	"nosharecache": true,
+	"port":         true,
+	"proto":        true,
+	"rdirplus":     true,
+	"relatime":     true,
+	"resvport":     true,
+	"retrans":      true,
+	"retry":        true,
+	"ro":           true,
+	"rsize":        true,
+	"rw":           true,
+	"sec":          true,
+	"sharecache":   true,
+	"soft":         true,
+	"timeo":        true,
+	"vers":         true,
+	"wsize":        true,
+}
+
+// ParseMountOptions splits a comma separated list of mount options and
+// validates every option name against allowedMountOptions.
+func ParseMountOptions(options string) ([]string, error) {
+	var result []string
+	for _, o := range strings.Split(options, ",") {
+		o = strings.TrimSpace(o)
+		if o == "" {
+			continue
+		}
+		name := strings.SplitN(o, "=", 2)[0]
+		if !allowedMountOptions[strings.ToLower(name)] {
+			return nil, fmt.Errorf("mount option %q is not allowed", o)
+		}
+		result = append(result, o)
+	}
+	return result, nil
+}
+
+// IsSafePathElement reports whether s can be used as a single path element
+// without escaping its parent directory. Control characters are rejected
+// too, since they could end mount sources or log lines early.
+func IsSafePathElement(s string) bool {
+	return s != "" && s != "." && s != ".." && !strings.Contains(s, "/") && !hasControlChars(s)
+}
+
+// IsSafeRelativePath reports whether joining s to a directory stays within
+// that directory. Empty elements, as in "a//b", are tolerated, and so is a
+// leading slash, which is joined like the rest. Control characters are
+// rejected like in IsSafePathElement.
+func IsSafeRelativePath(s string) bool {
+	if hasControlChars(s) {
+		return false
+	}
+	for _, e := range strings.Split(s, "/") {
+		if e == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateShare checks that share, an exported path of an nfs server, can
+// be mounted and joined to the mount point of its server without leaving
+// the share
+func ValidateShare(share string) error {
+	if !IsSafeRelativePath(share) {
+		return fmt.Errorf("share %q must not contain \"..\" or control characters", share)
+	}
+	return nil
+}
+
+func hasControlChars(s string) bool {
+	for _, r := range s {
+		if r < 0x20 || r == 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateVolumeName checks that a CreateVolume name can be used as the
+// name of the volume's directory
+func ValidateVolumeName(name string) error {
+	if !IsSafePathElement(name) {
+		return fmt.Errorf("volume name %q cannot be used as a directory name", name)
+	}
+	return nil
+}
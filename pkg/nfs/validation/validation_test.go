@@ -0,0 +1,128 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validation
+
+import (
+	"testing"
+)
+
+func TestNormalizeServer(t *testing.T) {
+	tests := []struct {
+		server string
+		want   string
+		// Host and port of want as returned by SplitServer
+		host    string
+		port    int
+		wantErr bool
+	}{
+		// IPv4
+		{server: "192.0.2.10", want: "192.0.2.10", host: "192.0.2.10"},
+		{server: " 192.0.2.10 ", want: "192.0.2.10", host: "192.0.2.10"},
+		{server: "192.0.2.10:2049", want: "192.0.2.10:2049", host: "192.0.2.10", port: 2049},
+		{server: "192.0.2.10:02050", want: "192.0.2.10:2050", host: "192.0.2.10", port: 2050},
+		{server: "nfs://192.0.2.10/", want: "192.0.2.10", host: "192.0.2.10"},
+		{server: "192.0.2.10.", wantErr: true},
+		{server: "192.0.2.10:0", wantErr: true},
+		{server: "192.0.2.10:65536", wantErr: true},
+		{server: "192.0.2.10:", wantErr: true},
+		{server: "192.0.2.10:nfs", wantErr: true},
+
+		// IPv6
+		{server: "fd00::1", want: "fd00::1", host: "fd00::1"},
+		{server: "FD00:0:0::1", want: "fd00::1", host: "fd00::1"},
+		{server: "[fd00::1]", want: "fd00::1", host: "fd00::1"},
+		{server: "[fd00::1]:2050", want: "[fd00::1]:2050", host: "fd00::1", port: 2050},
+		{server: "[FD00:0::1]:02050", want: "[fd00::1]:2050", host: "fd00::1", port: 2050},
+		{server: "nfs://[fd00::1]:2050/", want: "[fd00::1]:2050", host: "fd00::1", port: 2050},
+		{server: "::ffff:192.0.2.10", want: "192.0.2.10", host: "192.0.2.10"},
+		{server: "[fd00::1]:", wantErr: true},
+		{server: "[fd00::1]:0", wantErr: true},
+		{server: "[fd00::1", wantErr: true},
+		{server: "fd00::1]", wantErr: true},
+		{server: "fd00::1.", wantErr: true},
+		{server: "[fd00::1.]", wantErr: true},
+		{server: "fd00::1:2050:", wantErr: true},
+
+		// IPv6 zones
+		{server: "fe80::1%eth0", wantErr: true},
+		{server: "[fe80::1%eth0]", wantErr: true},
+		{server: "[fe80::1%eth0]:2049", wantErr: true},
+		{server: "[fe80::1%25eth0]:2049", wantErr: true},
+
+		// Hostnames
+		{server: "nfs.example.com", want: "nfs.example.com", host: "nfs.example.com"},
+		{server: " NFS.Example.COM ", want: "nfs.example.com", host: "nfs.example.com"},
+		{server: "nfs.example.com.", want: "nfs.example.com", host: "nfs.example.com"},
+		{server: "nfs.example.com.:2050", want: "nfs.example.com:2050", host: "nfs.example.com", port: 2050},
+		{server: "nfs://nfs.example.com//", want: "nfs.example.com", host: "nfs.example.com"},
+		{server: "nfs", want: "nfs", host: "nfs"},
+		{server: "nfs.example.com..", wantErr: true},
+		{server: "nfs..example.com", wantErr: true},
+		{server: ".", wantErr: true},
+		{server: "-nfs.example.com", wantErr: true},
+		{server: "nfs_1.example.com", wantErr: true},
+		{server: "[nfs.example.com]", wantErr: true},
+		{server: "[nfs.example.com]:2049", wantErr: true},
+		{server: "nfs.example.com:", wantErr: true},
+		{server: "nfs.example.com:-1", wantErr: true},
+		{server: "nfs.example.com:2049:1", wantErr: true},
+		{server: "nfs.example.com/export", wantErr: true},
+
+		{server: "", wantErr: true},
+		{server: " ", wantErr: true},
+		{server: "nfs://", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := NormalizeServer(test.server)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("NormalizeServer(%q) = %q, want error", test.server, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("NormalizeServer(%q) failed: %v", test.server, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("NormalizeServer(%q) = %q, want %q", test.server, got, test.want)
+		}
+		if again, err := NormalizeServer(got); err != nil || again != got {
+			t.Errorf("NormalizeServer(%q) = %q, %v, want it unchanged", got, again, err)
+		}
+		host, port := SplitServer(got)
+		if host != test.host || port != test.port {
+			t.Errorf("SplitServer(%q) = %q, %d, want %q, %d", got, host, port, test.host, test.port)
+		}
+	}
+}
+
+func TestMountSource(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"192.0.2.10", "192.0.2.10:/export"},
+		{"nfs.example.com", "nfs.example.com:/export"},
+		{"fd00::1", "[fd00::1]:/export"},
+	}
+	for _, test := range tests {
+		if got := MountSource(test.host, "/export"); got != test.want {
+			t.Errorf("MountSource(%q) = %q, want %q", test.host, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validation
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Ordering of elements in the CSI volume id.
+// ID is of the form {server}/{baseDir}/{subDir}. The slashes of a nested
+// baseDir are percent-encoded, so that it is a single element.
+//
+// Volumes that share the whole base directory have no subDir of their
+// own; their ID is of the form {server}/{baseDir}//{name} so that the
+// empty subDir element tells DeleteVolume there is nothing to remove.
+// Since such a base directory is used verbatim it may be nested.
+//
+// Characters that would be mistaken for separators are percent-encoded
+// within the elements, see EscapeIDElement.
+//
+// These are version 1 ids. Version 2 ids start with VolumeIDV2Prefix and
+// are of the form v2:{server}/{baseDir}/{subDir}/{name}, where every
+// element is percent-encoded with EscapeIDV2Element and subDir is empty
+// for volumes that share the whole base directory. Their elements can
+// hold any server and path, and the name is kept even if it differs from
+// the subdirectory.
+const (
+	idServer = iota
+	idBaseDir
+	idSubDir
+	totalIDElements // Always last
+)
+
+// VolumeIDV2Prefix starts version 2 volume ids. Version 1 ids cannot
+// start with it, since colons in their server are percent-encoded.
+const VolumeIDV2Prefix = "v2:"
+
+// totalIDV2Elements is the number of elements of version 2 ids
+const totalIDV2Elements = 4
+
+// VolumeID is the parsed form of a volume id created by the driver
+type VolumeID struct {
+	// 1 or 2
+	Version int
+	Server  string
+	BaseDir string
+	// Empty if the volume shares the whole base directory
+	SubDir string
+	// Name of the volume, equal to SubDir unless that is empty
+	Name string
+}
+
+// ParseVolumeID splits a volume id created by the driver into its parts.
+// IDs come from outside of the driver, so everything that ends up in a
+// path is checked not to escape the base share.
+func ParseVolumeID(id string) (*VolumeID, error) {
+	var vol *VolumeID
+	if strings.HasPrefix(id, VolumeIDV2Prefix) {
+		var err error
+		if vol, err = parseVolumeIDV2(id); err != nil {
+			return nil, err
+		}
+	} else {
+		vol = parseVolumeIDV1(id)
+		if vol == nil {
+			return nil, fmt.Errorf("Could not split %q into server, baseDir and subDir", id)
+		}
+	}
+
+	if vol.Server == "" || strings.Contains(vol.Server, "\x00") {
+		return nil, fmt.Errorf("invalid server in volume id %q", id)
+	}
+	if !IsSafeRelativePath(vol.BaseDir) {
+		return nil, fmt.Errorf("invalid base directory in volume id %q", id)
+	}
+	if !IsSafePathElement(vol.Name) || (vol.SubDir != "" && !IsSafePathElement(vol.SubDir)) {
+		return nil, fmt.Errorf("invalid volume name in volume id %q", id)
+	}
+	return vol, nil
+}
+
+// parseVolumeIDV1 splits a version 1 id and returns nil if it is none
+func parseVolumeIDV1(id string) *VolumeID {
+	tokens := strings.Split(id, "/")
+	last := len(tokens) - 1
+
+	var vol *VolumeID
+	switch {
+	case len(tokens) == totalIDElements && tokens[idSubDir] != "":
+		vol = &VolumeID{
+			Version: 1,
+			Server:  tokens[idServer],
+			BaseDir: tokens[idBaseDir],
+			SubDir:  tokens[idSubDir],
+			Name:    tokens[idSubDir],
+		}
+	case len(tokens) > totalIDElements && tokens[last-1] == "" && tokens[last] != "":
+		vol = &VolumeID{
+			Version: 1,
+			Server:  tokens[idServer],
+			BaseDir: strings.Join(tokens[idBaseDir:last-1], "/"),
+			Name:    tokens[last],
+		}
+	default:
+		return nil
+	}
+
+	vol.Server = UnescapeIDElement(vol.Server)
+	vol.BaseDir = unescapeIDPath(vol.BaseDir)
+	vol.SubDir = UnescapeIDElement(vol.SubDir)
+	vol.Name = UnescapeIDElement(vol.Name)
+	return vol
+}
+
+// parseVolumeIDV2 splits a version 2 id. Unlike in version 1 ids, every
+// malformed escape sequence is an error.
+func parseVolumeIDV2(id string) (*VolumeID, error) {
+	tokens := strings.Split(strings.TrimPrefix(id, VolumeIDV2Prefix), "/")
+	if len(tokens) != totalIDV2Elements {
+		return nil, fmt.Errorf("Could not split %q into server, baseDir, subDir and name", id)
+	}
+	for i := range tokens {
+		element, err := url.PathUnescape(tokens[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid volume id %q: %v", id, err)
+		}
+		tokens[i] = element
+	}
+	return &VolumeID{
+		Version: 2,
+		Server:  tokens[0],
+		BaseDir: strings.Trim(tokens[1], "/"),
+		SubDir:  tokens[2],
+		Name:    tokens[3],
+	}, nil
+}
+
+// ValidateVolumeID checks that id is a volume id created by the driver
+func ValidateVolumeID(id string) error {
+	_, err := ParseVolumeID(id)
+	return err
+}
+
+// idReservedChars are percent-encoded in the elements of volume ids: "/"
+// separates the elements, ":/" marks migrated in-tree volumes and "%"
+// starts an escape sequence. Colons show up in IPv6 servers and in
+// directory names.
+const idReservedChars = "%/:"
+
+// EscapeIDElement percent-encodes the characters of s that would be
+// mistaken for separators in a volume id. All other characters are kept,
+// so the ids of ordinary servers and directories are unchanged.
+func EscapeIDElement(s string) string {
+	if !strings.ContainsAny(s, idReservedChars) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(idReservedChars, s[i]) >= 0 {
+			fmt.Fprintf(&b, "%%%02X", s[i])
+		} else {
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// UnescapeIDElement reverses EscapeIDElement. Elements that are not in the
+// form EscapeIDElement returns are kept as they are, so that ids created
+// before elements were escaped still parse to the same directories, even
+// if those contain a "%".
+func UnescapeIDElement(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+2 >= len(s) {
+			return s
+		}
+		n, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+		if err != nil {
+			return s
+		}
+		b.WriteByte(byte(n))
+		i += 2
+	}
+	if u := b.String(); EscapeIDElement(u) == s {
+		return u
+	}
+	return s
+}
+
+// EscapeIDV2Element percent-encodes s for an element of a version 2 volume
+// id. Everything but letters, digits and "-", ".", "_" and "~" is encoded,
+// so the element never contains a separator, whatever s contains.
+func EscapeIDV2Element(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("-._~", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// EscapeIDPath escapes every element of the slash separated path p
+func EscapeIDPath(p string) string {
+	elements := strings.Split(p, "/")
+	for i := range elements {
+		elements[i] = EscapeIDElement(elements[i])
+	}
+	return strings.Join(elements, "/")
+}
+
+func unescapeIDPath(p string) string {
+	elements := strings.Split(p, "/")
+	for i := range elements {
+		elements[i] = UnescapeIDElement(elements[i])
+	}
+	return strings.Join(elements, "/")
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// verifyServer checks, if the verifyServer parameter or the driver asks
+// for it, that the nfs service of the server of vol accepts connections
+// and that its share can be mounted. CreateVolume then fails right away
+// with a message that names the problem, instead of with a generic mount
+// error or a timeout of a hanging mount.
+func (cs *controllerServer) verifyServer(ctx context.Context, vol *nfsVolume) error {
+	verify := cs.driver.verifyServer
+	if vol.verifyServer != nil {
+		verify = *vol.verifyServer
+	}
+	if !verify {
+		return nil
+	}
+
+	if _, err := cs.driver.dialServer(vol.server); err != nil {
+		return status.Errorf(codes.Unavailable, "nfs server %v is not reachable: %v", vol.server, err)
+	}
+	if vol.createShare {
+		// The share may not exist yet
+		return nil
+	}
+	// The request reuses the cached mount of the export
+	err := cs.exports.run(ctx, vol, func(string) error { return nil })
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok || ctx.Err() != nil {
+		return toStatusError(err)
+	}
+	return status.Errorf(codes.FailedPrecondition, "share %v:%v cannot be mounted, check that it exists and is exported to the controller: %v", vol.server, vol.baseDir, err)
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/nfs/validation"
+)
+
+// Volume context keys
+const (
+	// Address of the NFS server
+	ContextServer = "server"
+	// Exported path that is mounted
+	ContextShare = "share"
+	// Comma separated NFS mount options, see validation.ParseMountOptions
+	ContextMountOptions = "mountOptions"
+	// Mount from a reserved source port ("true") or not ("false")
+	ContextResvPort = "resvport"
+	// "true" to publish the share read-only below a local, discardable
+	// overlay that pods can write to
+	ContextScratchOverlay = "scratchOverlay"
+	// Backing of the overlay's writable layer, ScratchMediumMemory or
+	// empty for the local disk
+	ContextScratchMedium = "scratchMedium"
+	// Size of the tmpfs of ScratchMediumMemory, a quantity such as "1Gi"
+	// that should match the capacity of the volume
+	ContextScratchSize = "scratchSize"
+	// Version of the volume context format, missing in contexts written
+	// before versions were introduced
+	ContextVersion = "contextVersion"
+)
+
+// CurrentContextVersion is the context format written by Map. It is
+// increased whenever keys are added whose absence would change how a
+// volume is mounted, so that nodes can tell a context they only partly
+// understand, e.g. during a rolling upgrade where the controller is
+// already newer than the node plugin.
+const CurrentContextVersion = 1
+
+// ScratchMediumMemory backs the writable layer of a scratch overlay with
+// a tmpfs
+const ScratchMediumMemory = "Memory"
+
+// Context is the volume context the node plugin mounts a volume with
+type Context struct {
+	Server       string
+	Share        string
+	MountOptions []string
+	// nil to use the default of the node plugin
+	ResvPort *bool
+	// Publish a scratch overlay of the share
+	ScratchOverlay bool
+	ScratchMedium  string
+	// Bytes of the tmpfs of ScratchMediumMemory
+	ScratchSize int64
+	// Format version, 0 for contexts written before versions were
+	// introduced
+	Version int
+}
+
+// Map returns the volume context as stored in a PersistentVolume
+func (c *Context) Map() map[string]string {
+	m := map[string]string{
+		ContextServer:  c.Server,
+		ContextShare:   c.Share,
+		ContextVersion: strconv.Itoa(CurrentContextVersion),
+	}
+	if len(c.MountOptions) > 0 {
+		m[ContextMountOptions] = strings.Join(c.MountOptions, ",")
+	}
+	if c.ResvPort != nil {
+		m[ContextResvPort] = strconv.FormatBool(*c.ResvPort)
+	}
+	if c.ScratchOverlay {
+		m[ContextScratchOverlay] = "true"
+		if c.ScratchMedium != "" {
+			m[ContextScratchMedium] = c.ScratchMedium
+		}
+		if c.ScratchSize > 0 {
+			m[ContextScratchSize] = strconv.FormatInt(c.ScratchSize, 10)
+		}
+	}
+	return m
+}
+
+// ParseContext parses and validates a volume context. Server and share
+// may be empty for migrated volumes, which carry them in their ID.
+//
+// Parsing is tolerant of version skew: keys that are missing get their
+// defaults, so contexts of volumes provisioned by older versions keep
+// working, and unknown keys, e.g. written by a newer controller or added
+// by the CO, are ignored. Callers can compare Version to
+// CurrentContextVersion to tell contexts of a newer format.
+func ParseContext(m map[string]string) (*Context, error) {
+	c := &Context{
+		Server: m[ContextServer],
+		Share:  m[ContextShare],
+	}
+	if err := validation.ValidateShare(c.Share); err != nil {
+		return nil, err
+	}
+	if v, ok := m[ContextVersion]; ok {
+		version, err := strconv.Atoi(v)
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("invalid value %q for %s, must be a positive number", v, ContextVersion)
+		}
+		c.Version = version
+	}
+	if v := m[ContextMountOptions]; v != "" {
+		opts, err := validation.ParseMountOptions(v)
+		if err != nil {
+			return nil, err
+		}
+		c.MountOptions = opts
+	}
+	if v, ok := m[ContextResvPort]; ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q for %s: %v", v, ContextResvPort, err)
+		}
+		c.ResvPort = &b
+	}
+	if v, ok := m[ContextScratchOverlay]; ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q for %s: %v", v, ContextScratchOverlay, err)
+		}
+		c.ScratchOverlay = b
+	}
+	if v := m[ContextScratchMedium]; v != "" {
+		if v != ScratchMediumMemory {
+			return nil, fmt.Errorf("invalid value %q for %s, must be %q or empty", v, ContextScratchMedium, ScratchMediumMemory)
+		}
+		c.ScratchMedium = v
+	}
+	if v := m[ContextScratchSize]; v != "" {
+		q, err := resource.ParseQuantity(v)
+		if err != nil || q.Sign() <= 0 {
+			return nil, fmt.Errorf("invalid value %q for %s, must be a positive quantity such as 1Gi", v, ContextScratchSize)
+		}
+		c.ScratchSize = q.Value()
+	}
+	// A tmpfs without size may take half of the memory of the node
+	if c.ScratchOverlay && c.ScratchMedium == ScratchMediumMemory && c.ScratchSize == 0 {
+		return nil, fmt.Errorf("%s is required with %s %q", ContextScratchSize, ContextScratchMedium, ScratchMediumMemory)
+	}
+	return c, nil
+}
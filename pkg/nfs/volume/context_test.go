@@ -0,0 +1,58 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"testing"
+)
+
+func TestParseContextScratch(t *testing.T) {
+	tests := []struct {
+		name    string
+		context map[string]string
+		medium  string
+		size    int64
+		wantErr bool
+	}{
+		{"disk", map[string]string{ContextScratchOverlay: "true"}, "", 0, false},
+		{"memory", map[string]string{ContextScratchOverlay: "true", ContextScratchMedium: ScratchMediumMemory, ContextScratchSize: "512Mi"}, ScratchMediumMemory, 512 << 20, false},
+		{"memory in bytes", map[string]string{ContextScratchOverlay: "true", ContextScratchMedium: ScratchMediumMemory, ContextScratchSize: "1000000"}, ScratchMediumMemory, 1000000, false},
+		{"memory without size", map[string]string{ContextScratchOverlay: "true", ContextScratchMedium: ScratchMediumMemory}, "", 0, true},
+		{"zero size", map[string]string{ContextScratchOverlay: "true", ContextScratchMedium: ScratchMediumMemory, ContextScratchSize: "0"}, "", 0, true},
+		{"negative size", map[string]string{ContextScratchOverlay: "true", ContextScratchMedium: ScratchMediumMemory, ContextScratchSize: "-1Gi"}, "", 0, true},
+		{"invalid size", map[string]string{ContextScratchOverlay: "true", ContextScratchMedium: ScratchMediumMemory, ContextScratchSize: "lots"}, "", 0, true},
+		{"medium without overlay", map[string]string{ContextScratchMedium: ScratchMediumMemory}, ScratchMediumMemory, 0, false},
+	}
+	for _, test := range tests {
+		c, err := ParseContext(test.context)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: ParseContext() = %v, want error %v", test.name, err, test.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if c.ScratchMedium != test.medium || c.ScratchSize != test.size {
+			t.Errorf("%s: ParseContext() = medium %q size %d, want %q %d", test.name, c.ScratchMedium, c.ScratchSize, test.medium, test.size)
+		}
+		// The size survives the round trip
+		again, err := ParseContext(c.Map())
+		if err != nil || again.ScratchSize != c.ScratchSize {
+			t.Errorf("%s: ParseContext(Map()) = %+v, %v", test.name, again, err)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package volume builds and parses the volume IDs and volume contexts of
+// the NFS CSI driver, for tools that create PersistentVolumes for it,
+// e.g. static volumes or volumes migrated from other provisioners.
+package volume
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/nfs/validation"
+)
+
+// NewID returns the ID of a volume in directory subDir of share baseDir
+// on server, in the form {server}/{baseDir}/{subDir}. Colons and percent
+// signs in the elements, e.g. of IPv6 servers, are percent-encoded, and
+// so are the slashes of a nested baseDir such as "exports/team1", which
+// keeps the ids of single directory base shares unchanged.
+func NewID(server, baseDir, subDir string) string {
+	return strings.Join([]string{
+		validation.EscapeIDElement(strings.Trim(server, "/")),
+		validation.EscapeIDElement(strings.Trim(baseDir, "/")),
+		validation.EscapeIDElement(strings.Trim(subDir, "/")),
+	}, "/")
+}
+
+// NewSharedID returns the ID of a volume called name that uses the whole
+// share baseDir on server, in the form {server}/{baseDir}//{name}. The
+// driver never deletes data of such volumes.
+func NewSharedID(server, baseDir, name string) string {
+	return strings.Join([]string{
+		validation.EscapeIDElement(strings.Trim(server, "/")),
+		validation.EscapeIDPath(strings.Trim(baseDir, "/")),
+		"",
+		validation.EscapeIDElement(strings.Trim(name, "/")),
+	}, "/")
+}
+
+// NewV2ID returns the version 2 ID of volume name in directory subDir of
+// share baseDir on server, in the form v2:{server}/{baseDir}/{subDir}/{name}
+// with percent-encoded elements. subDir is empty for volumes that use the
+// whole share. Drivers before version 2 ids cannot parse them.
+func NewV2ID(server, baseDir, subDir, name string) string {
+	return validation.VolumeIDV2Prefix + strings.Join([]string{
+		validation.EscapeIDV2Element(server),
+		validation.EscapeIDV2Element(strings.Trim(baseDir, "/")),
+		validation.EscapeIDV2Element(strings.Trim(subDir, "/")),
+		validation.EscapeIDV2Element(name),
+	}, "/")
+}
+
+// ParseID parses an ID returned by NewID, NewSharedID or NewV2ID
+func ParseID(id string) (*validation.VolumeID, error) {
+	return validation.ParseVolumeID(id)
+}
+
+// NewSnapshotID returns the ID of snapshot name, archived in directory
+// snapshotsDir of share baseDir on server, in the form
+// {server}/{baseDir}/{snapshotsDir}/{name}
+func NewSnapshotID(server, baseDir, snapshotsDir, name string) string {
+	return strings.Join([]string{
+		validation.EscapeIDElement(strings.Trim(server, "/")),
+		validation.EscapeIDElement(strings.Trim(baseDir, "/")),
+		validation.EscapeIDElement(strings.Trim(snapshotsDir, "/")),
+		validation.EscapeIDElement(name),
+	}, "/")
+}
+
+// ParseSnapshotID parses an ID returned by NewSnapshotID
+func ParseSnapshotID(id string) (*validation.SnapshotID, error) {
+	return validation.ParseSnapshotID(id)
+}
+
+// NewMigratedID returns the ID of an in-tree NFS volume that was migrated
+// to the driver, or of a static volume of any existing export path. It
+// uses the familiar {server}:{path} notation of NFS mount sources, and the
+// driver never deletes the data of such volumes.
+func NewMigratedID(server, path string) string {
+	return fmt.Sprintf("%s:%s", server, path)
+}
+
+// ParseMigratedID splits an ID returned by NewMigratedID into server and
+// path. ok is false if id is no such ID.
+func ParseMigratedID(id string) (server, path string, ok bool) {
+	i := strings.Index(id, ":/")
+	if i <= 0 {
+		return "", "", false
+	}
+	return id[:i], id[i+1:], true
+}
@@ -0,0 +1,247 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/nfs/validation"
+)
+
+func TestVolumeIDs(t *testing.T) {
+	tests := []struct {
+		name string
+		// id returned by a constructor, or found in existing volumes
+		id string
+		// id the constructor must return, empty for existing ids
+		wantID string
+		// nil if the id must be rejected
+		want *validation.VolumeID
+	}{
+		{
+			name:   "v1",
+			id:     NewID("nfs.example.com", "/export/", "pvc-1"),
+			wantID: "nfs.example.com/export/pvc-1",
+			want:   &validation.VolumeID{Version: 1, Server: "nfs.example.com", BaseDir: "export", SubDir: "pvc-1", Name: "pvc-1"},
+		},
+		{
+			name:   "v1 nested share",
+			id:     NewID("nfs.example.com", "exports/team1", "pvc-1"),
+			wantID: "nfs.example.com/exports%2Fteam1/pvc-1",
+			want:   &validation.VolumeID{Version: 1, Server: "nfs.example.com", BaseDir: "exports/team1", SubDir: "pvc-1", Name: "pvc-1"},
+		},
+		{
+			name:   "v1 IPv6 server",
+			id:     NewID("fd00::1", "export", "pvc-1"),
+			wantID: "fd00%3A%3A1/export/pvc-1",
+			want:   &validation.VolumeID{Version: 1, Server: "fd00::1", BaseDir: "export", SubDir: "pvc-1", Name: "pvc-1"},
+		},
+		{
+			name:   "v1 IPv6 server with port",
+			id:     NewID("[fd00::1]:2049", "export", "pvc-1"),
+			wantID: "[fd00%3A%3A1]%3A2049/export/pvc-1",
+			want:   &validation.VolumeID{Version: 1, Server: "[fd00::1]:2049", BaseDir: "export", SubDir: "pvc-1", Name: "pvc-1"},
+		},
+		{
+			name:   "v1 percent sign and colon",
+			id:     NewID("nfs%1", "ex:port", "50%:a"),
+			wantID: "nfs%251/ex%3Aport/50%25%3Aa",
+			want:   &validation.VolumeID{Version: 1, Server: "nfs%1", BaseDir: "ex:port", SubDir: "50%:a", Name: "50%:a"},
+		},
+		{
+			name:   "v1 shared",
+			id:     NewSharedID("nfs.example.com", "exports/team1", "pvc-1"),
+			wantID: "nfs.example.com/exports/team1//pvc-1",
+			want:   &validation.VolumeID{Version: 1, Server: "nfs.example.com", BaseDir: "exports/team1", Name: "pvc-1"},
+		},
+		{
+			name:   "v1 shared with percent sign and colon",
+			id:     NewSharedID("fd00::1", "a:b/c%d", "pvc:1"),
+			wantID: "fd00%3A%3A1/a%3Ab/c%25d//pvc%3A1",
+			want:   &validation.VolumeID{Version: 1, Server: "fd00::1", BaseDir: "a:b/c%d", Name: "pvc:1"},
+		},
+		{
+			name: "v1 unescaped percent sign",
+			id:   "nfs.example.com/export/pvc%zz",
+			want: &validation.VolumeID{Version: 1, Server: "nfs.example.com", BaseDir: "export", SubDir: "pvc%zz", Name: "pvc%zz"},
+		},
+		{
+			name: "v1 empty subdirectory",
+			id:   "nfs.example.com/export/",
+		},
+		{
+			name: "v1 slash in name",
+			id:   NewID("nfs.example.com", "export", "a/b"),
+		},
+		{
+			name: "v1 traversal",
+			id:   "nfs.example.com/export/..",
+		},
+		{
+			name:   "v2",
+			id:     NewV2ID("nfs.example.com", "/export/", "pvc-1", "pvc-1"),
+			wantID: "v2:nfs.example.com/export/pvc-1/pvc-1",
+			want:   &validation.VolumeID{Version: 2, Server: "nfs.example.com", BaseDir: "export", SubDir: "pvc-1", Name: "pvc-1"},
+		},
+		{
+			name:   "v2 IPv6 server with port and nested share",
+			id:     NewV2ID("[fd00::1]:2049", "exports/team1", "pvc-1", "pvc-1"),
+			wantID: "v2:%5Bfd00%3A%3A1%5D%3A2049/exports%2Fteam1/pvc-1/pvc-1",
+			want:   &validation.VolumeID{Version: 2, Server: "[fd00::1]:2049", BaseDir: "exports/team1", SubDir: "pvc-1", Name: "pvc-1"},
+		},
+		{
+			name:   "v2 empty subdirectory",
+			id:     NewV2ID("nfs.example.com", "export", "", "pvc-1"),
+			wantID: "v2:nfs.example.com/export//pvc-1",
+			want:   &validation.VolumeID{Version: 2, Server: "nfs.example.com", BaseDir: "export", Name: "pvc-1"},
+		},
+		{
+			name:   "v2 percent sign and colon",
+			id:     NewV2ID("nfs%1", "a%b:c", "50%:x", "n%:1"),
+			wantID: "v2:nfs%251/a%25b%3Ac/50%25%3Ax/n%25%3A1",
+			want:   &validation.VolumeID{Version: 2, Server: "nfs%1", BaseDir: "a%b:c", SubDir: "50%:x", Name: "n%:1"},
+		},
+		{
+			name:   "v2 name differs from subdirectory",
+			id:     NewV2ID("nfs.example.com", "export", "cluster-a-pvc-1", "pvc-1"),
+			wantID: "v2:nfs.example.com/export/cluster-a-pvc-1/pvc-1",
+			want:   &validation.VolumeID{Version: 2, Server: "nfs.example.com", BaseDir: "export", SubDir: "cluster-a-pvc-1", Name: "pvc-1"},
+		},
+		{
+			name: "v2 slash in name",
+			id:   NewV2ID("nfs.example.com", "export", "pvc-1", "a/b"),
+		},
+		{
+			name: "v2 traversal in share",
+			id:   NewV2ID("nfs.example.com", "../etc", "pvc-1", "pvc-1"),
+		},
+		{
+			name: "v2 malformed escape",
+			id:   "v2:nfs.example.com/export/pvc%zz/pvc%zz",
+		},
+		{
+			name: "v2 missing name",
+			id:   "v2:nfs.example.com/export/pvc-1",
+		},
+	}
+	for _, test := range tests {
+		if test.wantID != "" && test.id != test.wantID {
+			t.Errorf("%s: got id %q, want %q", test.name, test.id, test.wantID)
+		}
+		got, err := ParseID(test.id)
+		switch {
+		case test.want == nil && err == nil:
+			t.Errorf("%s: ParseID(%q) = %+v, want an error", test.name, test.id, got)
+		case test.want != nil && err != nil:
+			t.Errorf("%s: ParseID(%q) failed: %v", test.name, test.id, err)
+		case test.want != nil && !reflect.DeepEqual(got, test.want):
+			t.Errorf("%s: ParseID(%q) = %+v, want %+v", test.name, test.id, got, test.want)
+		}
+	}
+}
+
+// FuzzParseVolumeID checks that ParseID returns the elements every id
+// constructor was given, or an error exactly if the elements are unsafe
+func FuzzParseVolumeID(f *testing.F) {
+	f.Add("nfs.example.com", "export", "pvc-1", "pvc-1")
+	f.Add("nfs.example.com", "exports/team1", "", "pvc-1")
+	f.Add("[fd00::1]:2049", "export", "50%:/x", "pvc-1")
+	f.Add("nfs", "..", "pvc-1", "pvc-1")
+	f.Add("", "export", "pvc-1", "pvc-1")
+	f.Fuzz(func(t *testing.T, server, baseDir, subDir, name string) {
+		safeServer := func(s string) bool {
+			return s != "" && !strings.Contains(s, "\x00")
+		}
+		b := strings.Trim(baseDir, "/")
+
+		// Version 2
+		d := strings.Trim(subDir, "/")
+		want := &validation.VolumeID{Version: 2, Server: server, BaseDir: b, SubDir: d, Name: name}
+		valid := safeServer(server) && validation.IsSafeRelativePath(b) && validation.IsSafePathElement(name) &&
+			(d == "" || validation.IsSafePathElement(d))
+		checkRoundTrip(t, NewV2ID(server, baseDir, subDir, name), want, valid)
+
+		// Version 1, with a subdirectory or sharing the base directory
+		s := strings.Trim(server, "/")
+		var id string
+		if d != "" {
+			id = NewID(server, baseDir, subDir)
+			want = &validation.VolumeID{Version: 1, Server: s, BaseDir: b, SubDir: d, Name: d}
+			valid = validation.IsSafePathElement(d)
+		} else {
+			n := strings.Trim(name, "/")
+			id = NewSharedID(server, baseDir, name)
+			want = &validation.VolumeID{Version: 1, Server: s, BaseDir: b, Name: n}
+			valid = validation.IsSafePathElement(n)
+		}
+		valid = valid && safeServer(s) && validation.IsSafeRelativePath(b)
+		checkRoundTrip(t, id, want, valid)
+	})
+}
+
+func checkRoundTrip(t *testing.T, id string, want *validation.VolumeID, valid bool) {
+	t.Helper()
+	got, err := ParseID(id)
+	switch {
+	case !valid && err == nil:
+		t.Fatalf("ParseID(%q) = %+v, want an error", id, got)
+	case valid && err != nil:
+		t.Fatalf("ParseID(%q) failed: %v", id, err)
+	case valid && !reflect.DeepEqual(got, want):
+		t.Fatalf("ParseID(%q) = %+v, want %+v", id, got, want)
+	}
+}
+
+// FuzzParseID checks that the parsers of volume ids never panic, and that
+// the ids they accept cannot escape the base share
+func FuzzParseID(f *testing.F) {
+	f.Add("nfs.example.com/export/pvc-1")
+	f.Add("nfs.example.com/exports/team1//pvc-1")
+	f.Add("fd00%3A%3A1/export/pvc%2550")
+	f.Add("v2:nfs.example.com/export/pvc-1/pvc-1")
+	f.Add("v2:%5Bfd00%3A%3A1%5D/exports%2Fteam1//pvc-1")
+	f.Add("nfs.example.com:/export/pvc-1")
+	f.Add("[fd00::1]:/export")
+	f.Add("nfs/../pvc-1")
+	f.Add("nfs/export//..")
+	f.Add("v2:nfs/%2E%2E/pvc-1/pvc-1")
+	f.Add("v2:nfs/export/pvc-1/%zz")
+	f.Fuzz(func(t *testing.T, id string) {
+		if vol, err := ParseID(id); err == nil {
+			if vol.Version != 1 && vol.Version != 2 {
+				t.Fatalf("ParseID(%q) returned version %d", id, vol.Version)
+			}
+			if vol.Server == "" || !validation.IsSafeRelativePath(vol.BaseDir) || !validation.IsSafePathElement(vol.Name) ||
+				(vol.SubDir != "" && !validation.IsSafePathElement(vol.SubDir)) {
+				t.Fatalf("ParseID(%q) accepted unsafe elements %+v", id, vol)
+			}
+			if vol.Version == 2 {
+				again, err := ParseID(NewV2ID(vol.Server, vol.BaseDir, vol.SubDir, vol.Name))
+				if err != nil || !reflect.DeepEqual(again, vol) {
+					t.Fatalf("ParseID(%q) = %+v, but its v2 id parses to %+v, %v", id, vol, again, err)
+				}
+			}
+		}
+		if server, path, ok := ParseMigratedID(id); ok {
+			if server == "" || !strings.HasPrefix(path, "/") || NewMigratedID(server, path) != id {
+				t.Fatalf("ParseMigratedID(%q) = %q, %q", id, server, path)
+			}
+		}
+	})
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/nfs/validation"
+)
+
+// volumeOperation populates a new volume in the background
+type volumeOperation struct {
+	// Id of the volume and what it is populated from
+	id     string
+	source string
+	start  time.Time
+
+	// Set once the volume is populated or populating it failed
+	done bool
+	err  error
+}
+
+// volumeOperations runs restores and clones in the background, so that
+// populating large volumes does not run into the deadline of CreateVolume.
+// CreateVolume returns Aborted while the operation for its volume is
+// running, and the retry after it completed finds the finished volume.
+type volumeOperations struct {
+	mutex sync.Mutex
+	ops   map[string]*volumeOperation
+}
+
+func newVolumeOperations() *volumeOperations {
+	return &volumeOperations{ops: map[string]*volumeOperation{}}
+}
+
+// start runs populate in the background for volume id, unless an
+// operation for id is known already. It returns the state of the
+// operation for id.
+func (o *volumeOperations) start(id, source string, populate func() error) volumeOperation {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if op, ok := o.ops[id]; ok {
+		return *op
+	}
+	op := &volumeOperation{
+		id:     id,
+		source: source,
+		start:  time.Now(),
+	}
+	o.ops[id] = op
+
+	go func() {
+		glog.V(2).Infof("Populating volume %v from %v", id, source)
+		err := populate()
+		if err != nil {
+			glog.Errorf("failed to populate volume %v from %v: %v", id, source, err)
+		} else {
+			glog.V(2).Infof("Populated volume %v from %v in %v", id, source, time.Since(op.start))
+		}
+
+		o.mutex.Lock()
+		defer o.mutex.Unlock()
+		op.done = true
+		op.err = err
+	}()
+	return *op
+}
+
+// get returns the state of the operation for volume id
+func (o *volumeOperations) get(id string) (volumeOperation, bool) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	op, ok := o.ops[id]
+	if !ok {
+		return volumeOperation{}, false
+	}
+	return *op, true
+}
+
+// forget drops the operation for volume id once it is done. A populated
+// volume is found on the share from then on, and a failed one is
+// populated again by the next start.
+func (o *volumeOperations) forget(id string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if op, ok := o.ops[id]; ok && op.done {
+		delete(o.ops, id)
+	}
+}
+
+// populateVolume restores or clones the content of the new volume vol in
+// the background and sets the volume up once it is complete. The claim of
+// the volume waits for the copy, so it starts right away, outside of the
+// background windows, and is only limited to the IO rate of the share of
+// vol. It holds the mount of the share instead of holding up the export
+// queue for the duration of the copy. The volume directory is removed on
+// errors.
+func (cs *controllerServer) populateVolume(vol *nfsVolume, restoreFrom *validation.SnapshotID, cloneFrom *nfsVolume, metadata *volumeMetadata) error {
+	throttle := cs.scheduler.rateLimit(context.Background(), schedulerShare(vol))
+	return cs.copyContent(vol, restoreFrom, cloneFrom, metadata, throttle)
+}
+
+// copyContent populates vol for populateVolume, writing through throttle
+func (cs *controllerServer) copyContent(vol *nfsVolume, restoreFrom *validation.SnapshotID, cloneFrom *nfsVolume, metadata *volumeMetadata, throttle func(io.Writer) io.Writer) error {
+	ctx := context.Background()
+	mountPath, release, err := cs.mounts.acquire(ctx, vol)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	internalVolumePath := filepath.Join(mountPath, vol.subDir)
+	if restoreFrom != nil {
+		// Removes the subdirectory if the restore fails
+		archive := filepath.Join(mountPath, restoreFrom.SnapshotsDir, restoreFrom.Name+snapshotArchiveSuffix)
+		err := cs.runAsProvisioner(func() error {
+			return restoreArchive(ctx, vol.id, archive, internalVolumePath, throttle)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to restore snapshot %v: %v", restoreFrom.Name, err)
+		}
+	}
+	if cloneFrom != nil {
+		sourcePath, release, err := cs.mountCloneSource(ctx, cloneFrom, vol, mountPath)
+		if err != nil {
+			return err
+		}
+		defer release()
+		// Removes the subdirectory if the copy fails
+		err = cs.runAsProvisioner(func() error {
+			return copyTree(ctx, sourcePath, internalVolumePath, throttle)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to clone volume %v: %v", cloneFrom.id, err)
+		}
+	}
+	if err := cs.setupVolumeDir(ctx, vol, internalVolumePath, metadata); err != nil {
+		return err
+	}
+	cs.invalidateCapacity(vol)
+	return nil
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestVolumeOperationsForget(t *testing.T) {
+	o := newVolumeOperations()
+	release := make(chan struct{})
+	o.start("vol-1", "snap-1", func() error {
+		<-release
+		return nil
+	})
+
+	// Running operations are kept
+	o.forget("vol-1")
+	if _, ok := o.get("vol-1"); !ok {
+		t.Fatal("running operation of vol-1 was forgotten")
+	}
+
+	close(release)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if op, _ := o.get("vol-1"); op.done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("operation did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	o.forget("vol-1")
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if len(o.ops) != 0 {
+		t.Errorf("%d operations are left after the finished one was forgotten", len(o.ops))
+	}
+}
+
+// TestCreateVolumeDuringPopulate checks that a CreateVolume request that
+// waited in the export queue while the volume started to be populated
+// leaves the partial copy alone
+func TestCreateVolumeDuringPopulate(t *testing.T) {
+	workDir := t.TempDir()
+	cs := newTestControllerServer(WithWorkingMountDir(workDir))
+	cs.driver.ns = NewNodeServer(cs.driver)
+	share := &nfsVolume{server: "192.0.2.10", baseDir: "export"}
+	volumeID := "v2:192.0.2.10/export/pvc-2/pvc-2"
+
+	// The fake mounter leaves the share at its mount path as it is
+	mountPath := filepath.Join(workDir, exportMountName(exportKey(share)))
+	if err := os.MkdirAll(filepath.Join(mountPath, "pvc-1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Hold the export queue until the request waits in it
+	queued := make(chan struct{})
+	holding := make(chan struct{})
+	go cs.exports.run(context.Background(), share, func(string) error {
+		close(holding)
+		<-queued
+		return nil
+	})
+	<-holding
+	result := make(chan error, 1)
+	go func() {
+		_, err := cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+			Name:               "pvc-2",
+			Parameters:         map[string]string{paramServer: "192.0.2.10", paramShare: "/export"},
+			VolumeCapabilities: []*csi.VolumeCapability{testMountCapability},
+			VolumeContentSource: &csi.VolumeContentSource{
+				Type: &csi.VolumeContentSource_Volume{
+					Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: "v2:192.0.2.10/export/pvc-1/pvc-1"},
+				},
+			},
+		})
+		result <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// Meanwhile another request started to copy into the volume
+	partial := filepath.Join(mountPath, "pvc-2", "partial")
+	if err := os.MkdirAll(filepath.Dir(partial), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(partial, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	copying := make(chan struct{})
+	defer close(copying)
+	cs.operations.start(volumeID, "volume:v2:192.0.2.10/export/pvc-1/pvc-1", func() error {
+		<-copying
+		return nil
+	})
+	close(queued)
+
+	if err := <-result; status.Code(err) != codes.Aborted {
+		t.Errorf("CreateVolume() during the copy = %v, want Aborted", err)
+	}
+	if _, err := os.Stat(partial); err != nil {
+		t.Errorf("partial copy was removed: %v", err)
+	}
+}
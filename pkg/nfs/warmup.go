@@ -0,0 +1,110 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/kubernetes-csi/csi-driver-nfs/pkg/nfs/validation"
+)
+
+// warmUpDialTimeout bounds the connection check of a single server
+const warmUpDialTimeout = 5 * time.Second
+
+// warmUp resolves and connects to the nfs servers of the volumes that are
+// still mounted on this node, e.g. after a restart of the driver, and to
+// the configured servers. This fills the DNS cache and reports unreachable
+// servers in the log and in the server_reachable metric before the first
+// pod on the node needs them.
+func (ns *nodeServer) warmUp(servers []string) {
+	seen := map[string]bool{}
+	var all []string
+	add := func(server string) {
+		server, err := validation.NormalizeServer(server)
+		if err != nil {
+			glog.Warningf("warm-up: %v", err)
+			return
+		}
+		if !seen[server] {
+			seen[server] = true
+			all = append(all, server)
+		}
+	}
+	for _, s := range servers {
+		add(s)
+	}
+	mps, err := ns.mounter.List()
+	if err != nil {
+		glog.Warningf("warm-up: failed to list mounts: %v", err)
+	}
+	for _, mp := range mps {
+		if !strings.HasPrefix(mp.Type, "nfs") {
+			continue
+		}
+		if i := strings.Index(mp.Device, ":/"); i > 0 {
+			add(mp.Device[:i])
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, server := range all {
+		wg.Add(1)
+		go func(server string) {
+			defer wg.Done()
+			ns.probeServer(server)
+		}(server)
+	}
+	wg.Wait()
+}
+
+// probeServer connects to the nfs port of server and records the result
+func (ns *nodeServer) probeServer(server string) {
+	start := time.Now()
+	addr, err := ns.driver.dialServer(server)
+	if err != nil {
+		glog.Warningf("warm-up: nfs server %v is not reachable: %v", server, err)
+		serverReachable.WithLabelValues(server).Set(0)
+		return
+	}
+	glog.Infof("warm-up: nfs server %v (%v) is reachable, connected in %v", server, addr, time.Since(start))
+	serverReachable.WithLabelValues(server).Set(1)
+}
+
+// dialServer connects to the nfs port of server, or to the port given
+// with the server, and returns the address it connected to
+func (d *Driver) dialServer(server string) (string, error) {
+	addr, port := validation.SplitServer(server)
+	if d.resolver != nil {
+		addr = d.resolver.resolve(addr, "ip")
+	}
+	dialPort := nfsPort
+	if port != 0 {
+		dialPort = strconv.Itoa(port)
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(addr, dialPort), warmUpDialTimeout)
+	if err != nil {
+		return addr, err
+	}
+	conn.Close()
+	return addr, nil
+}
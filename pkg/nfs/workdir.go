@@ -0,0 +1,186 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfs
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+)
+
+// How often the usage of the working mount directory is measured
+const workingDirScanInterval = time.Minute
+
+// workingDir keeps track of the directories in use under the working
+// mount directory, so that the janitor leaves them alone.
+type workingDir struct {
+	cs *controllerServer
+
+	mutex sync.Mutex
+	inUse map[string]int
+}
+
+func newWorkingDir(cs *controllerServer) *workingDir {
+	return &workingDir{
+		cs:    cs,
+		inUse: map[string]int{},
+	}
+}
+
+// acquire marks path as in use until release is called
+func (w *workingDir) acquire(path string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.inUse[path]++
+}
+
+func (w *workingDir) release(path string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.inUse[path]--; w.inUse[path] <= 0 {
+		delete(w.inUse, path)
+	}
+}
+
+// run measures the working mount directory periodically. If pruneAge is
+// positive, it also removes empty directories directly under it that are
+// not in use and have not been modified for pruneAge. Such directories are
+// left behind when the driver dies or fails to clean up after a mount.
+// Pruning is background work and runs through the share scheduler, within
+// the background windows. It returns when stopCh is closed.
+func (w *workingDir) run(pruneAge time.Duration, stopCh <-chan struct{}) {
+	if pruneAge > 0 {
+		go w.runPrune(pruneAge, stopCh)
+	}
+	ticker := time.NewTicker(workingDirScanInterval)
+	defer ticker.Stop()
+	for {
+		w.scan()
+		select {
+		case <-ticker.C:
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+func (w *workingDir) runPrune(pruneAge time.Duration, stopCh <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	dir := w.cs.driver.workingMountDir
+	ticker := time.NewTicker(workingDirScanInterval)
+	defer ticker.Stop()
+	for {
+		err := w.cs.scheduler.run(ctx, dir, "prune leftover directories", func(func(io.Writer) io.Writer) error {
+			w.pruneAll(pruneAge)
+			return nil
+		})
+		if err != nil {
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+func (w *workingDir) scan() {
+	dir := w.cs.driver.workingMountDir
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		glog.Warningf("failed to read working mount directory %v: %v", dir, err)
+		return
+	}
+
+	var bytes int64
+	var entries, mounts int
+	for _, info := range infos {
+		path := filepath.Join(dir, info.Name())
+		if !info.IsDir() {
+			bytes += info.Size()
+			entries++
+			continue
+		}
+		notMnt, err := w.cs.driver.mounter.IsLikelyNotMountPoint(path)
+		if err != nil {
+			continue
+		}
+		if !notMnt {
+			// Don't descend into nfs shares
+			mounts++
+			continue
+		}
+		entries++
+		filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+			if err == nil {
+				bytes += info.Size()
+			}
+			return nil
+		})
+	}
+
+	workingDirBytes.Set(float64(bytes))
+	workingDirEntries.Set(float64(entries))
+	workingDirMounts.Set(float64(mounts))
+}
+
+// pruneAll prunes the directories directly under the working mount
+// directory that have not been modified for pruneAge
+func (w *workingDir) pruneAll(pruneAge time.Duration) {
+	dir := w.cs.driver.workingMountDir
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		glog.Warningf("failed to read working mount directory %v: %v", dir, err)
+		return
+	}
+	for _, info := range infos {
+		if info.IsDir() && time.Since(info.ModTime()) > pruneAge {
+			w.prune(filepath.Join(dir, info.Name()))
+		}
+	}
+}
+
+// prune removes path if it is an empty directory that is not in use
+func (w *workingDir) prune(path string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.inUse[path] > 0 {
+		return
+	}
+	// Remove refuses directories that are not empty or mounted
+	if err := os.Remove(path); err != nil {
+		return
+	}
+	glog.V(2).Infof("Pruned leftover directory %v", path)
+	workingDirPruned.Inc()
+}
@@ -0,0 +1,203 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package testserver serves a local directory over NFSv3 from user space,
+// so that tests and the driver's demo mode can exercise real mounts
+// without an external NFS server, a kernel NFS server or privileges on
+// the server side.
+//
+// The protocol is implemented by github.com/willscott/go-nfs. It serves
+// the MOUNT and NFS programs on one TCP port and does not register with
+// rpcbind, so clients have to mount with MountOptions, which give the
+// port of both. Locking (NLM) is not supported.
+package testserver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/golang/glog"
+	nfs "github.com/willscott/go-nfs"
+	nfshelper "github.com/willscott/go-nfs/helpers"
+	"golang.org/x/net/context"
+)
+
+const (
+	// Address clients use to reach the server
+	localAddress = "127.0.0.1"
+	// Path clients mount, directories below it can be mounted too
+	exportPath = "/export"
+	// File handles the server keeps, mounts with more files in use get
+	// stale handles
+	handleLimit = 1 << 16
+)
+
+// Server serves a temporary directory over NFSv3
+type Server struct {
+	// Address of the NFS server, with the port it listens on
+	Address string
+	// Exported path
+	Share string
+	// Options clients must mount the share with
+	MountOptions []string
+
+	// Local directory that is served
+	dir      string
+	listener net.Listener
+	cancel   context.CancelFunc
+	done     chan error
+}
+
+// Start serves a new temporary directory, which is created in dir, or in
+// the default directory for temporary files if dir is empty, and removed
+// again by Stop. The temporary directory is made writable for everyone,
+// so that clients mounting as any user can write to it, while dir itself
+// is left untouched.
+func Start(dir string) (*Server, error) {
+	tmp, err := ioutil.TempDir(dir, "csi-nfs-testserver")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		Share: exportPath,
+		dir:   tmp,
+		done:  make(chan error, 1),
+	}
+	if err := os.Chmod(tmp, 0777); err != nil {
+		s.cleanup()
+		return nil, err
+	}
+
+	s.listener, err = net.Listen("tcp", net.JoinHostPort(localAddress, "0"))
+	if err != nil {
+		s.cleanup()
+		return nil, err
+	}
+	port := s.listener.Addr().(*net.TCPAddr).Port
+	s.Address = net.JoinHostPort(localAddress, fmt.Sprint(port))
+	s.MountOptions = []string{
+		"nfsvers=3",
+		"tcp",
+		fmt.Sprintf("port=%d", port),
+		fmt.Sprintf("mountport=%d", port),
+		"nolock",
+		"noacl",
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	server := &nfs.Server{
+		Handler: nfshelper.NewCachingHandler(&handler{Handler: nfshelper.NewNullAuthHandler(nil), root: tmp}, handleLimit),
+		Context: ctx,
+	}
+	glog.V(2).Infof("Serving %v over NFSv3 at %v:%v", tmp, s.Address, s.Share)
+	go func() {
+		s.done <- server.Serve(s.listener)
+	}()
+	return s, nil
+}
+
+// Stop stops serving and removes the served directory
+func (s *Server) Stop() error {
+	glog.V(2).Infof("Stopping nfs server at %v", s.Address)
+	s.cancel()
+	s.listener.Close()
+	select {
+	case <-s.done:
+	case <-time.After(10 * time.Second):
+		glog.Warningf("nfs server at %v did not stop", s.Address)
+	}
+	return s.cleanup()
+}
+
+// Source returns the mount source of the share
+func (s *Server) Source() string {
+	return fmt.Sprintf("%s:%s", localAddress, s.Share)
+}
+
+// Dir returns the local directory that is served
+func (s *Server) Dir() string {
+	return s.dir
+}
+
+func (s *Server) cleanup() error {
+	return os.RemoveAll(s.dir)
+}
+
+// handler serves the directories below root for mounts of the export
+// path and of directories below it
+type handler struct {
+	nfs.Handler
+	root string
+}
+
+func (h *handler) Mount(ctx context.Context, conn net.Conn, req nfs.MountRequest) (nfs.MountStatus, billy.Filesystem, []nfs.AuthFlavor) {
+	// go-nfs converts the returned file system to a handle even for failed
+	// mounts, so errors come with the root file system instead of nil
+	root := changeFS{osfs.New(h.root)}
+	p := path.Clean("/" + string(req.Dirpath))
+	if p != exportPath && !strings.HasPrefix(p, exportPath+"/") {
+		return nfs.MountStatusErrNoEnt, root, nil
+	}
+	dir := filepath.Join(h.root, strings.TrimPrefix(p, exportPath))
+	fi, err := os.Stat(dir)
+	switch {
+	case os.IsNotExist(err):
+		return nfs.MountStatusErrNoEnt, root, nil
+	case err != nil:
+		return nfs.MountStatusErrIO, root, nil
+	case !fi.IsDir():
+		return nfs.MountStatusErrNotDir, root, nil
+	}
+	return nfs.MountStatusOk, changeFS{osfs.New(dir)}, []nfs.AuthFlavor{nfs.AuthFlavorNull}
+}
+
+func (h *handler) Change(fs billy.Filesystem) billy.Change {
+	if c, ok := fs.(billy.Change); ok {
+		return c
+	}
+	return nil
+}
+
+// changeFS adds changing modes, owners and times to a billy file system
+// of the local disk, so that clients can chmod and chown
+type changeFS struct {
+	billy.Filesystem
+}
+
+func (fs changeFS) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(fs.Join(fs.Root(), name), mode)
+}
+
+func (fs changeFS) Lchown(name string, uid, gid int) error {
+	return os.Lchown(fs.Join(fs.Root(), name), uid, gid)
+}
+
+func (fs changeFS) Chown(name string, uid, gid int) error {
+	return os.Chown(fs.Join(fs.Root(), name), uid, gid)
+}
+
+func (fs changeFS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return os.Chtimes(fs.Join(fs.Root(), name), atime, mtime)
+}
@@ -0,0 +1,222 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testserver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/willscott/go-nfs-client/nfs/rpc"
+	"github.com/willscott/go-nfs-client/nfs/xdr"
+)
+
+// RPC programs and procedures of RFC 1813 the tests call
+const (
+	mountProg     = 100005
+	mountProcMnt  = 1
+	nfsProg       = 100003
+	nfsProcLookup = 3
+	nfsProcRead   = 6
+	// Status of successful MOUNT and NFS calls
+	statusOK = 0
+)
+
+// client calls the MOUNT and NFS programs of a Server
+type client struct {
+	*rpc.Client
+}
+
+func dial(t *testing.T, s *Server) *client {
+	c, err := rpc.DialTCP("tcp", s.Address, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return &client{c}
+}
+
+func header(prog, proc uint32) rpc.Header {
+	return rpc.Header{Rpcvers: 2, Prog: prog, Vers: 3, Proc: proc, Cred: rpc.AuthNull, Verf: rpc.AuthNull}
+}
+
+// mount returns the status of mounting dir and the file handle of dir
+func (c *client) mount(dir string) (uint32, []byte, error) {
+	res, err := c.Call(&struct {
+		rpc.Header
+		Dirpath string
+	}{header(mountProg, mountProcMnt), dir})
+	if err != nil {
+		return 0, nil, err
+	}
+	var reply struct {
+		Status uint32
+	}
+	if err := xdr.Read(res, &reply); err != nil || reply.Status != statusOK {
+		return reply.Status, nil, err
+	}
+	var fh []byte
+	return statusOK, fh, xdr.Read(res, &fh)
+}
+
+// lookup returns the file handle of name in the directory dir
+func (c *client) lookup(dir []byte, name string) ([]byte, error) {
+	res, err := c.Call(&struct {
+		rpc.Header
+		Dir  []byte
+		Name string
+	}{header(nfsProg, nfsProcLookup), dir, name})
+	if err != nil {
+		return nil, err
+	}
+	var reply struct {
+		Status uint32
+		Handle []byte
+	}
+	if err := xdr.Read(res, &reply); err != nil {
+		return nil, err
+	}
+	if reply.Status != statusOK {
+		return nil, fmt.Errorf("LOOKUP of %q failed with status %d", name, reply.Status)
+	}
+	return reply.Handle, nil
+}
+
+// read returns up to count bytes of the file fh
+func (c *client) read(fh []byte, count uint32) ([]byte, error) {
+	res, err := c.Call(&struct {
+		rpc.Header
+		File   []byte
+		Offset uint64
+		Count  uint32
+	}{header(nfsProg, nfsProcRead), fh, 0, count})
+	if err != nil {
+		return nil, err
+	}
+	var reply struct {
+		Status  uint32
+		HasAttr bool
+	}
+	if err := xdr.Read(res, &reply); err != nil {
+		return nil, err
+	}
+	if reply.Status != statusOK {
+		return nil, fmt.Errorf("READ failed with status %d", reply.Status)
+	}
+	if reply.HasAttr {
+		// fattr3 has a fixed size of 84 bytes
+		var attr [84]byte
+		if err := xdr.Read(res, &attr); err != nil {
+			return nil, err
+		}
+	}
+	var data struct {
+		Count uint32
+		EOF   bool
+		Data  []byte
+	}
+	if err := xdr.Read(res, &data); err != nil {
+		return nil, err
+	}
+	return data.Data, nil
+}
+
+func TestServer(t *testing.T) {
+	s, err := Start(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	_, port, _ := net.SplitHostPort(s.Address)
+	wantOptions := []string{"nfsvers=3", "tcp", "port=" + port, "mountport=" + port, "nolock", "noacl"}
+	if !reflect.DeepEqual(s.MountOptions, wantOptions) {
+		t.Errorf("MountOptions = %v, want %v", s.MountOptions, wantOptions)
+	}
+	if want := "127.0.0.1:/export"; s.Source() != want {
+		t.Errorf("Source() = %v, want %v", s.Source(), want)
+	}
+	if fi, err := os.Stat(s.Dir()); err != nil || fi.Mode().Perm() != 0777 {
+		t.Errorf("served directory %v is not writable for everyone: %v, %v", s.Dir(), fi, err)
+	}
+
+	if err := os.Mkdir(filepath.Join(s.Dir(), "vol"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(s.Dir(), "vol", "hello"), []byte("hello world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := dial(t, s)
+	for _, test := range []struct {
+		dir  string
+		want uint32
+	}{
+		{"/export", statusOK},
+		{"/export/vol", statusOK},
+		{"/export/vol/", statusOK},
+		{"/export/missing", 2},    // MNT3ERR_NOENT
+		{"/export/vol/hello", 20}, // MNT3ERR_NOTDIR
+		{"/other", 2},
+		{"/export/../vol", 2},
+	} {
+		got, _, err := c.mount(test.dir)
+		if err != nil {
+			t.Errorf("MNT %v failed: %v", test.dir, err)
+		} else if got != test.want {
+			t.Errorf("MNT %v = %d, want %d", test.dir, got, test.want)
+		}
+	}
+
+	// Files below a mounted directory can be read
+	_, root, err := c.mount("/export/vol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh, err := c.lookup(root, "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := c.read(fh, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello world" {
+		t.Errorf("READ = %q, want %q", data, "hello world")
+	}
+}
+
+func TestServerStop(t *testing.T) {
+	s, err := Start(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(s.Dir()); !os.IsNotExist(err) {
+		t.Errorf("served directory %v was not removed: %v", s.Dir(), err)
+	}
+	if conn, err := net.Dial("tcp", s.Address); err == nil {
+		conn.Close()
+		t.Errorf("server at %v still accepts connections", s.Address)
+	}
+}